@@ -7,9 +7,14 @@ import (
 
 	"github.com/alecthomas/kong"
 	"github.com/davecgh/go-spew/spew"
+	"github.com/iancoleman/strcase"
 	"github.com/rmrfslashbin/mastopost/pkg/cmds/lambda"
 	"github.com/rmrfslashbin/mastopost/pkg/cmds/oneshot"
+	"github.com/rmrfslashbin/mastopost/pkg/cmds/scheduled"
+	"github.com/rmrfslashbin/mastopost/pkg/cmds/undo"
 	"github.com/rmrfslashbin/mastopost/pkg/config"
+	"github.com/rmrfslashbin/mastopost/pkg/history"
+	"github.com/rmrfslashbin/mastopost/pkg/ssmparams"
 	"github.com/rs/zerolog"
 )
 
@@ -27,6 +32,9 @@ const (
 
 	// CONFIG_FILE is the name of the config file
 	CONFIG_FILE = "config.json"
+
+	// HISTORY_FILE is the name of the default history file
+	HISTORY_FILE = "history.jsonl"
 )
 
 // Context is used to pass context/global configs to the commands
@@ -195,6 +203,7 @@ func (r *RssXPostOneshotCmd) Run(ctx *Context) error {
 		oneshot.WithConfigFile(ctx.configFile),
 		oneshot.WithFeedName(&r.Feedname),
 		oneshot.WithDryrun(r.DryRun),
+		oneshot.WithHistoryFile(path.Join(*ctx.homeConfigDir, HISTORY_FILE)),
 	); err != nil {
 		return err
 	} else {
@@ -203,6 +212,211 @@ func (r *RssXPostOneshotCmd) Run(ctx *Context) error {
 	}
 }
 
+// RssXPostPruneCmd deletes a feed's posts older than its expireafter setting
+type RssXPostPruneCmd struct {
+	DryRun   bool   `name:"dryrun" help:"Don't actually delete from Mastodon."`
+	Feedname string `name:"feedname" env:"FEED_NAME" required:"" help:"Name of the feed to prune."`
+}
+
+// Run is the entry point for the prune command
+func (r *RssXPostPruneCmd) Run(ctx *Context) error {
+	foo, err := oneshot.NewOneshot(
+		oneshot.WithLogger(ctx.log),
+		oneshot.WithConfigFile(ctx.configFile),
+		oneshot.WithFeedName(&r.Feedname),
+		oneshot.WithDryrun(r.DryRun),
+		oneshot.WithHistoryFile(path.Join(*ctx.homeConfigDir, HISTORY_FILE)),
+	)
+	if err != nil {
+		return err
+	}
+	return foo.Prune()
+}
+
+// HistoryCmd lists the history of what's been posted
+type HistoryCmd struct {
+	AWSProfile string `name:"profile" help:"AWS profile to use with --ssm" default:"default"`
+	AWSRegion  string `name:"region" help:"AWS region to use with --ssm" default:"us-east-1"`
+	SSM        bool   `name:"ssm" help:"Read the recent history kept by the Lambda function instead of the history file. Needs --feedname."`
+	FeedName   string `name:"feedname" help:"Only list this feed's history."`
+	RunID      string `name:"run" help:"Only list this run's history."`
+	Since      string `name:"since" help:"Only list history since this time (RFC 3339 or YYYY-MM-DD)."`
+	Until      string `name:"until" help:"Only list history before this time (RFC 3339 or YYYY-MM-DD)."`
+	Search     string `name:"search" help:"Only list items with this text in their title or link."`
+	Limit      int    `name:"limit" help:"Only list the newest N entries."`
+	JSON       bool   `name:"json" help:"Output JSON instead of a table."`
+}
+
+// Run is the entry point for the history command
+func (r *HistoryCmd) Run(ctx *Context) error {
+	query := &history.Query{
+		FeedName: r.FeedName,
+		RunID:    r.RunID,
+		Search:   r.Search,
+		Limit:    r.Limit,
+	}
+	if r.Since != "" {
+		since, err := history.ParseTime(r.Since)
+		if err != nil {
+			return err
+		}
+		query.Since = &since
+	}
+	if r.Until != "" {
+		until, err := history.ParseTime(r.Until)
+		if err != nil {
+			return err
+		}
+		query.Until = &until
+	}
+
+	var store history.Store
+	if r.SSM {
+		if r.FeedName == "" {
+			return fmt.Errorf("--ssm needs --feedname")
+		}
+		query.FeedName = strcase.ToCamel(r.FeedName)
+		params, err := ssmparams.New(
+			ssmparams.WithLogger(ctx.log),
+			ssmparams.WithProfile(r.AWSProfile),
+			ssmparams.WithRegion(r.AWSRegion),
+		)
+		if err != nil {
+			return err
+		}
+		// The Lambda jobs are named in CamelCase
+		if store, err = history.NewSSM(params, "/mastopost/"+strcase.ToCamel(r.FeedName)+"/"); err != nil {
+			return err
+		}
+	} else {
+		c, err := config.NewConfig(*ctx.configFile)
+		if err != nil {
+			return err
+		}
+		historyFile := c.HistoryFile
+		if historyFile == "" {
+			historyFile = path.Join(*ctx.homeConfigDir, HISTORY_FILE)
+		}
+		if store, err = history.NewFile(historyFile); err != nil {
+			return err
+		}
+	}
+
+	records, err := store.Query(query)
+	if err != nil {
+		return err
+	}
+
+	if r.JSON {
+		return history.WriteJSON(os.Stdout, records)
+	}
+	return history.WriteTable(os.Stdout, records)
+}
+
+// RssXPostUndoCmd deletes the posts made by a run and optionally rewinds the feed's state
+type RssXPostUndoCmd struct {
+	AWSProfile string `name:"profile" help:"AWS profile to use with --lambda" default:"default"`
+	AWSRegion  string `name:"region" help:"AWS region to use with --lambda" default:"us-east-1"`
+	Lambda     bool   `name:"lambda" help:"Undo a run of the Lambda function, using the feed's state and history in SSM."`
+	FeedName   string `name:"feedname" required:"" help:"Feed name to use"`
+	RunID      string `name:"run" xor:"Select" help:"ID of the run to undo (see the history command). Defaults to the last run that posted anything."`
+	Since      string `name:"since" xor:"Select" help:"Undo every post since this time (RFC 3339 or YYYY-MM-DD)."`
+	Rewind     bool   `name:"rewind" help:"Rewind the feed's state to before the posts, so the items are posted again on the next run."`
+	Confirm    bool   `name:"confirm" default:"false" help:"Confirm the undo (don't prompt for confirmation)"`
+	DryRun     bool   `name:"dryrun" help:"List the posts to delete without deleting them."`
+}
+
+// Run is the entry point for the undo command
+func (r *RssXPostUndoCmd) Run(ctx *Context) error {
+	opts := []undo.UndoOptions{
+		undo.WithLogger(ctx.log),
+		undo.WithConfigFile(ctx.configFile),
+		undo.WithFeedName(&r.FeedName),
+		undo.WithHistoryFile(path.Join(*ctx.homeConfigDir, HISTORY_FILE)),
+	}
+	if r.Lambda {
+		opts = append(opts, undo.WithLambda(&r.AWSProfile, &r.AWSRegion))
+	}
+	u, err := undo.NewUndo(opts...)
+	if err != nil {
+		return err
+	}
+
+	input := &undo.UndoInput{
+		RunID:   r.RunID,
+		Rewind:  r.Rewind,
+		Confirm: r.Confirm,
+		DryRun:  r.DryRun,
+	}
+	if r.Since != "" {
+		since, err := history.ParseTime(r.Since)
+		if err != nil {
+			return err
+		}
+		input.Since = &since
+	}
+	return u.Undo(input)
+}
+
+// ScheduledListCmd lists the scheduled statuses on a feed's account
+type ScheduledListCmd struct {
+	AWSProfile string `name:"profile" help:"AWS profile to use with --lambda" default:"default"`
+	AWSRegion  string `name:"region" help:"AWS region to use with --lambda" default:"us-east-1"`
+	Lambda     bool   `name:"lambda" help:"Use the feed's Lambda function job config in SSM."`
+	FeedName   string `name:"feedname" required:"" help:"Feed name to use"`
+}
+
+// Run is the entry point for the scheduled list command
+func (r *ScheduledListCmd) Run(ctx *Context) error {
+	opts := []scheduled.ScheduledOptions{
+		scheduled.WithLogger(ctx.log),
+		scheduled.WithConfigFile(ctx.configFile),
+		scheduled.WithFeedName(&r.FeedName),
+		scheduled.WithHistoryFile(path.Join(*ctx.homeConfigDir, HISTORY_FILE)),
+	}
+	if r.Lambda {
+		opts = append(opts, scheduled.WithLambda(&r.AWSProfile, &r.AWSRegion))
+	}
+	s, err := scheduled.NewScheduled(opts...)
+	if err != nil {
+		return err
+	}
+	return s.List()
+}
+
+// ScheduledCancelCmd cancels scheduled statuses on a feed's account
+type ScheduledCancelCmd struct {
+	AWSProfile string   `name:"profile" help:"AWS profile to use with --lambda" default:"default"`
+	AWSRegion  string   `name:"region" help:"AWS region to use with --lambda" default:"us-east-1"`
+	Lambda     bool     `name:"lambda" help:"Use the feed's Lambda function job config in SSM."`
+	FeedName   string   `name:"feedname" required:"" help:"Feed name to use"`
+	IDs        []string `name:"id" xor:"Select" required:"" help:"ID of a scheduled status to cancel (see scheduled list). Can be repeated."`
+	All        bool     `name:"all" xor:"Select" required:"" help:"Cancel every scheduled status on the account."`
+	Confirm    bool     `name:"confirm" default:"false" help:"Confirm the cancel (don't prompt for confirmation)"`
+}
+
+// Run is the entry point for the scheduled cancel command
+func (r *ScheduledCancelCmd) Run(ctx *Context) error {
+	opts := []scheduled.ScheduledOptions{
+		scheduled.WithLogger(ctx.log),
+		scheduled.WithConfigFile(ctx.configFile),
+		scheduled.WithFeedName(&r.FeedName),
+		scheduled.WithHistoryFile(path.Join(*ctx.homeConfigDir, HISTORY_FILE)),
+	}
+	if r.Lambda {
+		opts = append(opts, scheduled.WithLambda(&r.AWSProfile, &r.AWSRegion))
+	}
+	s, err := scheduled.NewScheduled(opts...)
+	if err != nil {
+		return err
+	}
+	return s.Cancel(&scheduled.CancelInput{
+		IDs:     r.IDs,
+		All:     r.All,
+		Confirm: r.Confirm,
+	})
+}
+
 // LambdaInstallCmd installs a new lambda function
 type LambdaInstallCmd struct {
 	AWSProfile   string `name:"profile" help:"AWS profile to use" default:"default"`
@@ -259,6 +473,15 @@ type CLI struct {
 	// Cfg commmand
 	Cfg CfgCmd `cmd:"" help:"Show Mastopost config details."`
 
+	// History command
+	History HistoryCmd `cmd:"" help:"List the history of what's been posted."`
+
+	// Scheduled commands
+	Scheduled struct {
+		List   ScheduledListCmd   `cmd:"" help:"List the scheduled statuses on a feed's account."`
+		Cancel ScheduledCancelCmd `cmd:"" help:"Cancel scheduled statuses on a feed's account."`
+	} `cmd:"" help:"Manage scheduled statuses."`
+
 	RssXpost struct {
 		// Job commands
 		Job struct {
@@ -269,6 +492,10 @@ type CLI struct {
 		} `cmd:"" help:"Manages jobs/events"`
 		// Oneshot command
 		Oneshot RssXPostOneshotCmd `cmd:"" help:"Run an RSS feed parser and post to Mastodon."`
+		// Prune command
+		Prune RssXPostPruneCmd `cmd:"" help:"Delete a feed's posts older than its expireafter setting."`
+		// Undo command
+		Undo RssXPostUndoCmd `cmd:"" help:"Delete the posts made by a run and optionally rewind the feed's state."`
 	} `cmd:"" help:"RSS cross-posting commands."`
 
 	/*
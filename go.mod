@@ -3,6 +3,7 @@ module github.com/rmrfslashbin/mastopost
 go 1.19
 
 require (
+	github.com/PuerkitoBio/goquery v1.8.0
 	github.com/alecthomas/kong v0.7.1
 	github.com/arran4/golang-ical v0.0.0-20221122102835-109346913e54
 	github.com/aws/aws-lambda-go v1.35.0
@@ -17,10 +18,10 @@ require (
 	github.com/mattn/go-mastodon v0.0.6
 	github.com/mmcdole/gofeed v1.1.3
 	github.com/rs/zerolog v1.28.0
+	golang.org/x/net v0.2.0
 )
 
 require (
-	github.com/PuerkitoBio/goquery v1.8.0 // indirect
 	github.com/andybalholm/cascadia v1.3.1 // indirect
 	github.com/aws/aws-sdk-go-v2/credentials v1.13.4 // indirect
 	github.com/aws/aws-sdk-go-v2/feature/ec2/imds v1.12.20 // indirect
@@ -44,7 +45,6 @@ require (
 	github.com/modern-go/reflect2 v1.0.2 // indirect
 	github.com/stretchr/testify v1.8.1 // indirect
 	github.com/tomnomnom/linkheader v0.0.0-20180905144013-02ca5825eb80 // indirect
-	golang.org/x/net v0.2.0 // indirect
 	golang.org/x/sys v0.3.0 // indirect
 	golang.org/x/text v0.5.0 // indirect
 	gopkg.in/yaml.v2 v2.4.0 // indirect
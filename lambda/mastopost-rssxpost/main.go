@@ -4,20 +4,13 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"net/url"
 	"os"
-	"strings"
-	"time"
 
 	"github.com/aws/aws-lambda-go/lambda"
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/service/ssm"
-	"github.com/aws/aws-sdk-go-v2/service/ssm/types"
-	"github.com/mattn/go-mastodon"
-	"github.com/rmrfslashbin/mastopost/pkg/mastoclient"
+	"github.com/rmrfslashbin/mastopost/pkg/history"
+	"github.com/rmrfslashbin/mastopost/pkg/pipeline"
 	"github.com/rmrfslashbin/mastopost/pkg/rssfeed"
 	"github.com/rmrfslashbin/mastopost/pkg/ssmparams"
-	"github.com/rmrfslashbin/mastopost/pkg/utils"
 	"github.com/rs/zerolog"
 )
 
@@ -26,18 +19,19 @@ var (
 	log        zerolog.Logger
 )
 
+const (
+	// ACTION_POST posts the feed's new items. It's the default action.
+	ACTION_POST = "post"
+
+	// ACTION_PRUNE deletes the feed's expired posts
+	ACTION_PRUNE = "prune"
+)
+
 type Message struct {
 	FeedName string `json:"feed_name"`
-}
 
-type Config struct {
-	feedUrl       *url.URL
-	instance      *url.URL
-	clientID      string
-	clientSec     string
-	token         string
-	lastUpdated   *time.Time
-	lastPublished *time.Time
+	// Action is what to do with the feed: ACTION_POST or ACTION_PRUNE
+	Action string `json:"action"`
 }
 
 func init() {
@@ -70,147 +64,60 @@ func handler(ctx context.Context, message Message) error {
 	}
 
 	path := "/mastopost/" + message.FeedName + "/"
-	config := &Config{}
-	var nextToken *string
-	for {
-		opt, err := params.ListAllParams(path, nextToken)
-		if err != nil {
-			return err
-		}
-
-		for _, p := range opt.Parameters {
-			/*
-				log.Info().
-					Str("name", *p.Name).
-					Str("value", *p.Value).
-					Str("modified", p.LastModifiedDate.String()).
-					Int64("version", p.Version).
-					Str("Arn", *p.ARN).
-					Msg("found parameter")
-			*/
-			key := strings.TrimPrefix(*p.Name, path)
-			switch key {
-			case "mastodon/instanceUrl":
-				if instanceUrl, err := url.Parse(*p.Value); err != nil {
-					return err
-				} else {
-					config.instance = instanceUrl
-				}
-			case "mastodon/clientId":
-				config.clientID = *p.Value
-			case "mastodon/clientSecret":
-				config.clientSec = *p.Value
-			case "mastodon/accessToken":
-				config.token = *p.Value
-			case "rss/feedUrl":
-				if feedUrl, err := url.Parse(*p.Value); err != nil {
-					return err
-				} else {
-					config.feedUrl = feedUrl
-				}
-			case "runtime/lastUpdated":
-				if t, err := time.Parse(time.RFC3339, *p.Value); err == nil {
-					config.lastUpdated = &t
-				}
-			case "runtime/lastPublished":
-				if t, err := time.Parse(time.RFC3339, *p.Value); err == nil {
-					config.lastPublished = &t
-				}
-			default:
-				log.Warn().Str("key", key).Msg("unknown key")
-			}
-		}
-
-		nextToken = opt.NextToken
-		if nextToken == nil {
-			break
-		}
-	}
-
-	// Set up a new feed parser
-	feed, err := rssfeed.New(
-		rssfeed.WithLogger(&log),
-		rssfeed.WithURL(config.feedUrl),
-		rssfeed.WithLastUpdated(config.lastUpdated),
-		rssfeed.WithLastPublished(config.lastPublished),
-	)
+	feedConfig, state, err := params.LoadFeed(path)
 	if err != nil {
 		return err
 	}
 
-	// Get the new items
-	newItems, err := feed.Parse()
+	// Keep the feed's recent history alongside its state
+	store, err := history.NewSSM(params, path)
 	if err != nil {
 		return err
 	}
 
-	if len(newItems) < 1 {
-		log.Info().
-			Str("feedName", message.FeedName).
-			Msg("no new items")
-		return nil
-	}
-
-	client, err := mastoclient.New(
-		mastoclient.WithLogger(&log),
-		mastoclient.WithInstance(config.instance),
-		mastoclient.WithClientID(config.clientID),
-		mastoclient.WithClientSecret(config.clientSec),
-		mastoclient.WithToken(config.token),
+	// Run the feed's pipeline against the saved state
+	p, err := pipeline.New(
+		pipeline.WithLogger(&log),
+		pipeline.WithFeedName(message.FeedName),
+		pipeline.WithFeedConfig(feedConfig),
+		pipeline.WithState(state),
+		pipeline.WithHistory(store),
 	)
 	if err != nil {
 		return err
 	}
 
-	ch := make(chan *mastodon.ID)
-
-	for _, item := range newItems {
-		// create a new post/toot
-		newPost, err := utils.MakePost(item)
-		if err != nil {
-			return err
-		}
-		go func(newPost *mastodon.Toot) {
-			id, err := client.Post(newPost)
-			if err != nil {
-				log.Error().Err(err).Msg("failed to post")
-			}
-			ch <- id
-		}(newPost)
+	var runErr error
+	switch message.Action {
+	case "", ACTION_POST:
+		runErr = p.Run()
+	case ACTION_PRUNE:
+		runErr = p.Prune()
+	default:
+		return fmt.Errorf("unknown action %q", message.Action)
 	}
+	if runErr != nil {
+		var noUpdates *rssfeed.NoUpdates
+		if errors.As(runErr, &noUpdates) {
+			log.Info().
+				Str("feedName", message.FeedName).
+				Msg("feed has no updates")
+			return nil
+		}
 
-	for i := 0; i < len(newItems); i++ {
-		status := <-ch
-		log.Info().
-			Str("toInstance", config.instance.String()).
-			Str("statusId", fmt.Sprintf("%v", status)).
-			Msg("posted")
+		// Keep the state for the items that did go out or the posts that
+		// were deleted
+		var partial *pipeline.PartialFailure
+		var partialPrune *pipeline.PruneFailure
+		if !errors.As(runErr, &partial) && !errors.As(runErr, &partialPrune) {
+			return runErr
+		}
 	}
-	close(ch)
 
 	// Update state/config
-	var paramNames []*ssm.PutParameterInput
-
-	paramNames = append(paramNames, &ssm.PutParameterInput{
-		Name:      aws.String(fmt.Sprintf("%sruntime/lastUpdated", path)),
-		Value:     aws.String(feed.GetLastUpdated().Format(time.RFC3339)),
-		Type:      types.ParameterTypeString,
-		Overwrite: aws.Bool(true),
-	})
-
-	paramNames = append(paramNames, &ssm.PutParameterInput{
-		Name:      aws.String(fmt.Sprintf("%sruntime/lastPublished", path)),
-		Value:     aws.String(feed.GetLastPublished().Format(time.RFC3339)),
-		Type:      types.ParameterTypeString,
-		Overwrite: aws.Bool(true),
-	})
-
-	for _, param := range paramNames {
-		_, err := params.PutParam(param)
-		if err != nil {
-			return err
-		}
+	if err := params.SaveState(path, state); err != nil {
+		return err
 	}
 
-	return nil
+	return runErr
 }
@@ -1,7 +1,9 @@
 package lambda
 
 import (
+	"encoding/json"
 	"fmt"
+	"strconv"
 	"strings"
 	"time"
 
@@ -11,6 +13,7 @@ import (
 	"github.com/rmrfslashbin/mastopost/pkg/config"
 	"github.com/rmrfslashbin/mastopost/pkg/events"
 	"github.com/rmrfslashbin/mastopost/pkg/ssmparams"
+	"github.com/rmrfslashbin/mastopost/pkg/utils"
 	"github.com/rs/zerolog/log"
 )
 
@@ -58,6 +61,19 @@ func (l *LambdaConfig) Add(addInput *AddInput) error {
 	// Easy access to the feed config
 	feedConfig := cfg.Feeds[*l.feedName]
 
+	// Check the template before storing it. It's stored base64 encoded, as
+	// SSM rejects values containing "{{", and tiered so long ones fit.
+	if _, err := utils.ParseTemplate(feedConfig.Template); err != nil {
+		return &utils.InvalidSetting{Setting: "template", Err: err}
+	}
+	template := ""
+	if feedConfig.Template != "" {
+		template = ssmparams.EncodeTemplate(feedConfig.Template)
+		if len(template) > ssmparams.MAX_PARAM_SIZE {
+			return &utils.InvalidSetting{Setting: "template", Err: fmt.Errorf("%d bytes encoded, over the %d an SSM parameter holds", len(template), ssmparams.MAX_PARAM_SIZE)}
+		}
+	}
+
 	// Check for lambda function ARN
 	if _, ok := cfg.LambdaFunctionConfig[*l.lambdaFunctionName]; !ok {
 		return &NoLambdaFunctionARN{LambdaFunctionName: l.lambdaFunctionName}
@@ -105,8 +121,55 @@ func (l *LambdaConfig) Add(addInput *AddInput) error {
 		/mastopost/${feedname}/mastodon/clientSecret
 		/mastopost/${feedname}/mastodon/accessToken
 		/mastopost/${feedname}/rss/feedUrl
+		/mastopost/${feedname}/rss/filterPublished
+		/mastopost/${feedname}/rss/timeout (optional)
+		/mastopost/${feedname}/rss/userAgent (optional)
+		/mastopost/${feedname}/rss/headers (optional, JSON object)
+		/mastopost/${feedname}/rss/basicAuthUser (optional)
+		/mastopost/${feedname}/rss/basicAuthPassword (optional)
+		/mastopost/${feedname}/rss/proxyUrl (optional)
+		/mastopost/${feedname}/post/maxPostsPerRun (optional)
+		/mastopost/${feedname}/post/firstRun (optional)
+		/mastopost/${feedname}/post/minAge (optional)
+		/mastopost/${feedname}/post/postDelay (optional)
+		/mastopost/${feedname}/post/scheduleSpacing (optional)
+		/mastopost/${feedname}/post/filters (optional, JSON object)
+		/mastopost/${feedname}/post/postingWindow (optional, JSON object)
+		/mastopost/${feedname}/post/contentWarnings (optional, JSON array)
+		/mastopost/${feedname}/post/template (optional, base64)
+		/mastopost/${feedname}/post/hashtags (optional, JSON array)
+		/mastopost/${feedname}/post/hashtagMap (optional, JSON object)
+		/mastopost/${feedname}/post/maxHashtags (optional)
+		/mastopost/${feedname}/post/visibility (optional)
+		/mastopost/${feedname}/post/language (optional)
+		/mastopost/${feedname}/post/excerpt (optional)
+		/mastopost/${feedname}/post/excerptLength (optional)
+		/mastopost/${feedname}/post/media (optional)
+		/mastopost/${feedname}/post/maxAttachments (optional)
+		/mastopost/${feedname}/post/cardImage (optional)
+		/mastopost/${feedname}/post/cardImageMaxSize (optional)
+		/mastopost/${feedname}/post/cardTimeout (optional)
+		/mastopost/${feedname}/post/editUpdates (optional)
+		/mastopost/${feedname}/post/editWindow (optional)
+		/mastopost/${feedname}/post/deleteTombstones (optional)
+		/mastopost/${feedname}/post/deleteRemoved (optional)
+		/mastopost/${feedname}/post/deleteWindow (optional)
+		/mastopost/${feedname}/post/expireAfter (optional)
+		/mastopost/${feedname}/post/expireScan (optional)
+		/mastopost/${feedname}/post/thread (optional)
+		/mastopost/${feedname}/post/threadUnlisted (optional)
+		/mastopost/${feedname}/post/maxThreadPosts (optional)
 		/mastopost/${feedname}/runtime/lastUpdated
 		/mastopost/${feedname}/runtime/lastPublished
+		/mastopost/${feedname}/runtime/etag (written by the lambda function)
+		/mastopost/${feedname}/runtime/lastModified (written by the lambda function)
+		/mastopost/${feedname}/runtime/seen (written by the lambda function)
+		/mastopost/${feedname}/runtime/firstSeen (written by the lambda function)
+		/mastopost/${feedname}/runtime/seenTrimmed (written by the lambda function)
+		/mastopost/${feedname}/runtime/posted (written by the lambda function)
+		/mastopost/${feedname}/runtime/posted-N (written by the lambda function when runtime/posted is full)
+		/mastopost/${feedname}/runtime/held (written by the lambda function)
+		/mastopost/${feedname}/runtime/history (written by the lambda function)
 	*/
 
 	var paramNames []*ssm.PutParameterInput
@@ -146,6 +209,214 @@ func (l *LambdaConfig) Add(addInput *AddInput) error {
 		Overwrite: aws.Bool(true),
 	})
 
+	paramNames = append(paramNames, &ssm.PutParameterInput{
+		Name:      aws.String(fmt.Sprintf("/mastopost/%s/rss/filterPublished", *l.feedName)),
+		Value:     aws.String(strconv.FormatBool(feedConfig.FilterPublished)),
+		Type:      types.ParameterTypeString,
+		Overwrite: aws.Bool(true),
+	})
+
+	headers := ""
+	if len(feedConfig.Headers) > 0 {
+		b, err := json.Marshal(feedConfig.Headers)
+		if err != nil {
+			return err
+		}
+		headers = string(b)
+	}
+
+	filters := ""
+	if feedConfig.Filters != nil {
+		b, err := json.Marshal(feedConfig.Filters)
+		if err != nil {
+			return err
+		}
+		filters = string(b)
+	}
+
+	postingWindow := ""
+	if feedConfig.PostingWindow != nil {
+		b, err := json.Marshal(feedConfig.PostingWindow)
+		if err != nil {
+			return err
+		}
+		postingWindow = string(b)
+	}
+
+	contentWarnings := ""
+	if len(feedConfig.ContentWarnings) > 0 {
+		b, err := json.Marshal(feedConfig.ContentWarnings)
+		if err != nil {
+			return err
+		}
+		contentWarnings = string(b)
+	}
+
+	minAge := ""
+	if feedConfig.MinAge > 0 {
+		minAge = strconv.Itoa(feedConfig.MinAge)
+	}
+
+	maxPostsPerRun := ""
+	if feedConfig.MaxPostsPerRun > 0 {
+		maxPostsPerRun = strconv.Itoa(feedConfig.MaxPostsPerRun)
+	}
+
+	hashtags := ""
+	if len(feedConfig.Hashtags) > 0 {
+		b, err := json.Marshal(feedConfig.Hashtags)
+		if err != nil {
+			return err
+		}
+		hashtags = string(b)
+	}
+
+	hashtagMap := ""
+	if len(feedConfig.HashtagMap) > 0 {
+		b, err := json.Marshal(feedConfig.HashtagMap)
+		if err != nil {
+			return err
+		}
+		hashtagMap = string(b)
+	}
+
+	maxHashtags := ""
+	if feedConfig.MaxHashtags > 0 {
+		maxHashtags = strconv.Itoa(feedConfig.MaxHashtags)
+	}
+
+	excerptLength := ""
+	if feedConfig.ExcerptLength > 0 {
+		excerptLength = strconv.Itoa(feedConfig.ExcerptLength)
+	}
+
+	media := ""
+	if feedConfig.Media {
+		media = strconv.FormatBool(feedConfig.Media)
+	}
+
+	maxAttachments := ""
+	if feedConfig.MaxAttachments > 0 {
+		maxAttachments = strconv.Itoa(feedConfig.MaxAttachments)
+	}
+
+	cardImage := ""
+	if feedConfig.CardImage {
+		cardImage = strconv.FormatBool(feedConfig.CardImage)
+	}
+
+	cardImageMaxSize := ""
+	if feedConfig.CardImageMaxSize > 0 {
+		cardImageMaxSize = strconv.FormatInt(feedConfig.CardImageMaxSize, 10)
+	}
+
+	editUpdates := ""
+	if feedConfig.EditUpdates {
+		editUpdates = strconv.FormatBool(feedConfig.EditUpdates)
+	}
+
+	deleteTombstones := ""
+	if feedConfig.DeleteTombstones {
+		deleteTombstones = strconv.FormatBool(feedConfig.DeleteTombstones)
+	}
+
+	deleteRemoved := ""
+	if feedConfig.DeleteRemoved {
+		deleteRemoved = strconv.FormatBool(feedConfig.DeleteRemoved)
+	}
+
+	expireAfter := ""
+	if feedConfig.ExpireAfter > 0 {
+		expireAfter = strconv.Itoa(feedConfig.ExpireAfter)
+	}
+
+	expireScan := ""
+	if feedConfig.ExpireScan {
+		expireScan = strconv.FormatBool(feedConfig.ExpireScan)
+	}
+
+	thread := ""
+	if feedConfig.Thread {
+		thread = strconv.FormatBool(feedConfig.Thread)
+	}
+
+	threadUnlisted := ""
+	if feedConfig.ThreadUnlisted {
+		threadUnlisted = strconv.FormatBool(feedConfig.ThreadUnlisted)
+	}
+
+	maxThreadPosts := ""
+	if feedConfig.MaxThreadPosts > 0 {
+		maxThreadPosts = strconv.Itoa(feedConfig.MaxThreadPosts)
+	}
+
+	// Optional settings. SSM doesn't allow empty values, so settings that
+	// aren't set are deleted in case an earlier add set them.
+	optionalParams := map[string]string{
+		"rss/timeout":           feedConfig.Timeout,
+		"rss/userAgent":         feedConfig.UserAgent,
+		"rss/headers":           headers,
+		"rss/basicAuthUser":     feedConfig.BasicAuthUser,
+		"rss/basicAuthPassword": feedConfig.BasicAuthPassword,
+		"rss/proxyUrl":          feedConfig.ProxyURL,
+		"post/maxPostsPerRun":   maxPostsPerRun,
+		"post/firstRun":         feedConfig.FirstRun,
+		"post/minAge":           minAge,
+		"post/postDelay":        feedConfig.PostDelay,
+		"post/scheduleSpacing":  feedConfig.ScheduleSpacing,
+		"post/filters":          filters,
+		"post/postingWindow":    postingWindow,
+		"post/contentWarnings":  contentWarnings,
+		"post/hashtags":         hashtags,
+		"post/hashtagMap":       hashtagMap,
+		"post/maxHashtags":      maxHashtags,
+		"post/visibility":       feedConfig.Visibility,
+		"post/language":         feedConfig.Language,
+		"post/excerpt":          feedConfig.Excerpt,
+		"post/excerptLength":    excerptLength,
+		"post/media":            media,
+		"post/maxAttachments":   maxAttachments,
+		"post/cardImage":        cardImage,
+		"post/cardImageMaxSize": cardImageMaxSize,
+		"post/cardTimeout":      feedConfig.CardTimeout,
+		"post/editUpdates":      editUpdates,
+		"post/editWindow":       feedConfig.EditWindow,
+		"post/deleteTombstones": deleteTombstones,
+		"post/deleteRemoved":    deleteRemoved,
+		"post/deleteWindow":     feedConfig.DeleteWindow,
+		"post/expireAfter":      expireAfter,
+		"post/expireScan":       expireScan,
+		"post/thread":           thread,
+		"post/threadUnlisted":   threadUnlisted,
+		"post/maxThreadPosts":   maxThreadPosts,
+	}
+	var unsetParams []string
+	for key, value := range optionalParams {
+		name := fmt.Sprintf("/mastopost/%s/%s", *l.feedName, key)
+		if value == "" {
+			unsetParams = append(unsetParams, name)
+			continue
+		}
+		paramNames = append(paramNames, &ssm.PutParameterInput{
+			Name:      aws.String(name),
+			Value:     aws.String(value),
+			Type:      types.ParameterTypeString,
+			Overwrite: aws.Bool(true),
+		})
+	}
+
+	if template != "" {
+		paramNames = append(paramNames, &ssm.PutParameterInput{
+			Name:      aws.String(fmt.Sprintf("/mastopost/%s/post/template", *l.feedName)),
+			Value:     aws.String(template),
+			Type:      types.ParameterTypeString,
+			Tier:      types.ParameterTierIntelligentTiering,
+			Overwrite: aws.Bool(true),
+		})
+	} else {
+		unsetParams = append(unsetParams, fmt.Sprintf("/mastopost/%s/post/template", *l.feedName))
+	}
+
 	epoch := time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC).Format(time.RFC3339)
 
 	paramNames = append(paramNames, &ssm.PutParameterInput{
@@ -170,6 +441,12 @@ func (l *LambdaConfig) Add(addInput *AddInput) error {
 		log.Info().Str("name", *param.Name).Msg("put parameter")
 	}
 
+	if len(unsetParams) > 0 {
+		if opt, err := params.DeleteParams(unsetParams); err != nil {
+			return &ParametersDeleteError{InvalidParameters: opt.InvalidParameters, Err: err}
+		}
+	}
+
 	eb, err := events.New(
 		events.WithLogger(l.log),
 		events.WithProfile(*l.awsprofile),
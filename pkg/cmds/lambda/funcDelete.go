@@ -86,31 +86,29 @@ func (l *LambdaConfig) Delete(confirm bool) error {
 
 	}
 
-	/*
-		/mastopost/${feedname}/mastodon/instanceUrl
-		/mastopost/${feedname}/mastodon/clientId
-		/mastopost/${feedname}/mastodon/clientSecret
-		/mastopost/${feedname}/mastodon/accessToken
-		/mastopost/${feedname}/rss/feedUrl
-		/mastopost/${feedname}/runtime/lastUpdated
-		/mastopost/${feedname}/runtime/lastPublished
-	*/
-
-	paramNames := []string{
-		fmt.Sprintf("/mastopost/%s/mastodon/instanceUrl", *l.feedName),
-		fmt.Sprintf("/mastopost/%s/mastodon/clientId", *l.feedName),
-		fmt.Sprintf("/mastopost/%s/mastodon/clientSecret", *l.feedName),
-		fmt.Sprintf("/mastopost/%s/mastodon/accessToken", *l.feedName),
-		fmt.Sprintf("/mastopost/%s/rss/feedUrl", *l.feedName),
-		fmt.Sprintf("/mastopost/%s/runtime/lastUpdated", *l.feedName),
-		fmt.Sprintf("/mastopost/%s/runtime/lastPublished", *l.feedName),
+	// Collect every parameter under the feed's path, including the runtime
+	// state written by the lambda function
+	path := fmt.Sprintf("/mastopost/%s/", *l.feedName)
+	var paramNames []string
+	var nextToken *string
+	for {
+		opt, err := params.ListAllParams(path, nextToken)
+		if err != nil {
+			return err
+		}
+		for _, p := range opt.Parameters {
+			paramNames = append(paramNames, *p.Name)
+		}
+		nextToken = opt.NextToken
+		if nextToken == nil {
+			break
+		}
 	}
 
 	if opt, err := params.DeleteParams(paramNames); err != nil {
 		return &ParametersDeleteError{InvalidParameters: opt.InvalidParameters, Err: err}
-	} else {
-		l.log.Info().Msgf("Deleted %d parameters", len(paramNames))
 	}
+	l.log.Info().Msgf("Deleted %d parameters", len(paramNames))
 
 	if err := eb.DeleteRule(&events.DeleteRuleInput{
 		FunctionName: l.lambdaFunctionName,
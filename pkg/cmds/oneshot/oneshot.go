@@ -1,17 +1,14 @@
 package oneshot
 
 import (
-	"fmt"
-	"net/url"
+	"errors"
 	"os"
 
-	"github.com/mattn/go-mastodon"
 	"github.com/rmrfslashbin/mastopost/pkg/config"
-	"github.com/rmrfslashbin/mastopost/pkg/mastoclient"
+	"github.com/rmrfslashbin/mastopost/pkg/history"
+	"github.com/rmrfslashbin/mastopost/pkg/pipeline"
 	"github.com/rmrfslashbin/mastopost/pkg/rssfeed"
-	"github.com/rmrfslashbin/mastopost/pkg/utils"
 	"github.com/rs/zerolog"
-	"github.com/rs/zerolog/log"
 )
 
 // NoConfigFile is returned when a filename is required but not provided
@@ -95,55 +92,16 @@ func (e *FeedNotInConfig) Error() string {
 	return e.Msg
 }
 
-type FeedUrlParseError struct {
-	Err error
-	Msg string
-	Url string
-}
-
-func (e *FeedUrlParseError) Error() string {
-	if e.Msg == "" {
-		e.Msg = "error parsing feed url"
-	}
-	if e.Url != "" {
-		e.Msg += ": " + e.Url
-	}
-	if e.Err != nil {
-		e.Msg += ": " + e.Err.Error()
-	}
-	return e.Msg
-}
-
-// InstanceUrlParseError is returned when the instance url cannot be parsed
-type InstanceUrlParseError struct {
-	Err error
-	Msg string
-	Url string
-}
-
-// Error returns the error message
-func (e *InstanceUrlParseError) Error() string {
-	if e.Msg == "" {
-		e.Msg = "error parsing instance url"
-	}
-	if e.Url != "" {
-		e.Msg += ": " + e.Url
-	}
-	if e.Err != nil {
-		e.Msg += ": " + e.Err.Error()
-	}
-	return e.Msg
-}
-
 // OneshotOptions is a function that can be used to configure the OneshotConfig
 type OneshotOptions func(config *OneshotConfig)
 
 // OneshotConfig is the configuration for the oneshot command
 type OneshotConfig struct {
-	log        *zerolog.Logger
-	configFile *string
-	feedName   *string
-	dryrun     bool
+	log         *zerolog.Logger
+	configFile  *string
+	feedName    *string
+	dryrun      bool
+	historyFile string
 }
 
 // NewOneshotConfig creates a new OneshotConfig
@@ -188,6 +146,13 @@ func WithFeedName(feedName *string) OneshotOptions {
 	}
 }
 
+// WithHistoryFile sets the history file used when the config doesn't set one
+func WithHistoryFile(historyFile string) OneshotOptions {
+	return func(config *OneshotConfig) {
+		config.historyFile = historyFile
+	}
+}
+
 // WithLogger sets the logger to use
 func WithLogger(log *zerolog.Logger) OneshotOptions {
 	return func(config *OneshotConfig) {
@@ -199,133 +164,123 @@ func WithLogger(log *zerolog.Logger) OneshotOptions {
 func (c *OneshotConfig) Run() error {
 	c.log.Debug().Msg("Running oneshot")
 
-	if c.configFile == nil {
-		return &NoConfigFile{}
+	p, lastUpdateConfig, err := c.load()
+	if err != nil {
+		return err
 	}
 
-	if c.feedName == nil {
-		return &NoFeedName{}
+	runErr := p.Run()
+	if runErr != nil {
+		var noUpdates *rssfeed.NoUpdates
+		if errors.As(runErr, &noUpdates) {
+			c.log.Info().Str("feedname", *c.feedName).Msg("feed has no updates")
+			return nil
+		}
+
+		// Keep the state for the items that did go out
+		var partial *pipeline.PartialFailure
+		if !errors.As(runErr, &partial) {
+			return runErr
+		}
 	}
 
-	// Load the config file
-	cfg, err := config.NewConfig(*c.configFile)
-	if err != nil {
-		return &FeedLoadError{Err: err}
+	// Dry runs leave the state alone
+	if c.dryrun {
+		return nil
 	}
 
-	// Ensure the feed is in the config
-	if _, ok := cfg.Feeds[*c.feedName]; !ok {
-		return &FeedNotInConfig{feedname: *c.feedName}
+	if err := lastUpdateConfig.Save(); err != nil {
+		return err
 	}
 
-	// Easy access to the feed config
-	feedConfig := cfg.Feeds[*c.feedName]
+	return runErr
+}
 
-	// Load the last update time config
-	lastUpdateConfig, err := config.NewLastUpdates(feedConfig.LastUpdateFile)
+// Prune deletes the feed's expired posts
+func (c *OneshotConfig) Prune() error {
+	c.log.Debug().Msg("Running prune")
+
+	p, lastUpdateConfig, err := c.load()
 	if err != nil {
-		return &LastUpdateLoadError{Err: err}
+		return err
 	}
 
-	// Easy access to the last update data
-	feedlastUpdateData := &lastUpdateConfig.FeedLastUpdate
-
-	// Parse the feed url
-	feedURL, err := url.Parse(feedConfig.FeedURL)
-	if err != nil {
-		return &FeedUrlParseError{Url: feedConfig.FeedURL, Err: err}
+	pruneErr := p.Prune()
+	if pruneErr != nil {
+		// Keep the state for the posts that were deleted
+		var partial *pipeline.PruneFailure
+		if !errors.As(pruneErr, &partial) {
+			return pruneErr
+		}
 	}
 
-	// Set up a new feed parser
-	feed, err := rssfeed.New(
-		rssfeed.WithLogger(c.log),
-		rssfeed.WithURL(feedURL),
-		rssfeed.WithLastUpdated(feedlastUpdateData.LastUpdated),
-		rssfeed.WithLastPublished(feedlastUpdateData.LastPublished),
-	)
-	if err != nil {
-		return err
+	// Dry runs leave the state alone
+	if c.dryrun {
+		return nil
 	}
 
-	// Get the new items
-	newItems, err := feed.Parse()
-	if err != nil {
+	if err := lastUpdateConfig.Save(); err != nil {
 		return err
 	}
 
-	// Log some info
-	c.log.Info().
-		Str("lastupdate", feed.GetLastUpdated().String()).
-		Str("lastpublished", feed.GetLastPublished().String()).
-		Str("feedname", *c.feedName).
-		Msgf("Found %d new items", len(newItems))
+	return pruneErr
+}
 
-	// Bail out if there's nothing new
-	if len(newItems) < 1 {
-		c.log.Info().Msg("no new posts")
-		return nil
+// load sets up the feed's pipeline against its saved state
+func (c *OneshotConfig) load() (*pipeline.Config, *config.LastUpdates, error) {
+	if c.configFile == nil {
+		return nil, nil, &NoConfigFile{}
 	}
 
-	// Are we doing a dry run?
-	if c.dryrun {
-		c.log.Info().Msg("dryrun mode. not posting to Mastodon")
-		return nil
+	if c.feedName == nil {
+		return nil, nil, &NoFeedName{}
 	}
 
-	instanceUrl, err := url.Parse(feedConfig.Instance)
+	// Load the config file
+	cfg, err := config.NewConfig(*c.configFile)
 	if err != nil {
-		return &InstanceUrlParseError{Url: feedConfig.Instance, Err: err}
+		return nil, nil, &FeedLoadError{Err: err}
 	}
 
-	// Set up the Mastodon client
-	client, err := mastoclient.New(
-		mastoclient.WithLogger(c.log),
-		mastoclient.WithInstance(instanceUrl),
-		mastoclient.WithClientID(feedConfig.ClientId),
-		mastoclient.WithClientSecret(feedConfig.ClientSecret),
-		mastoclient.WithToken(feedConfig.AccessToken),
-	)
-	if err != nil {
-		return err
+	// Ensure the feed is in the config
+	if _, ok := cfg.Feeds[*c.feedName]; !ok {
+		return nil, nil, &FeedNotInConfig{feedname: *c.feedName}
 	}
 
-	// Set up a channel to receive the results
-	ch := make(chan *mastodon.ID)
+	// Easy access to the feed config
+	feedConfig := cfg.Feeds[*c.feedName]
 
-	for _, item := range newItems {
-		// create a new post/toot
-		newPost, err := utils.MakePost(item)
-		if err != nil {
-			return err
-		}
-		go func(newPost *mastodon.Toot) {
-			id, err := client.Post(newPost)
-			if err != nil {
-				log.Error().Err(err).Msg("error posting to Mastodon")
-			}
-			ch <- id
-		}(newPost)
+	// Load the last update time config
+	lastUpdateConfig, err := config.NewLastUpdates(feedConfig.LastUpdateFile)
+	if err != nil {
+		return nil, nil, &LastUpdateLoadError{Err: err}
 	}
 
-	for i := 0; i < len(newItems); i++ {
-		status := <-ch
-		log.Info().
-			Str("id", fmt.Sprintf("%v", status)).
-			Str("toInstance", instanceUrl.String()).
-			Msg("posted to Mastodon")
+	opts := []pipeline.Option{
+		pipeline.WithLogger(c.log),
+		pipeline.WithFeedName(*c.feedName),
+		pipeline.WithFeedConfig(&feedConfig),
+		pipeline.WithState(&lastUpdateConfig.FeedLastUpdate),
+		pipeline.WithDryrun(c.dryrun),
 	}
-	close(ch)
 
-	// Update state/config
-	feedlastUpdateData.LastPublished = feed.GetLastPublished()
-	feedlastUpdateData.LastUpdated = feed.GetLastUpdated()
-	if feedlastUpdateData.FeedName == "" {
-		feedlastUpdateData.FeedName = *c.feedName
+	// Keep a history of what's posted
+	historyFile := cfg.HistoryFile
+	if historyFile == "" {
+		historyFile = c.historyFile
+	}
+	if historyFile != "" {
+		store, err := history.NewFile(historyFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		opts = append(opts, pipeline.WithHistory(store))
 	}
 
-	if err := lastUpdateConfig.Save(); err != nil {
-		return err
+	p, err := pipeline.New(opts...)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	return nil
+	return p, lastUpdateConfig, nil
 }
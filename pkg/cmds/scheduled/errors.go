@@ -0,0 +1,89 @@
+package scheduled
+
+import "fmt"
+
+// NoConfigFile is returned when a filename is required but not provided
+type NoConfigFile struct {
+	Err error
+}
+
+// Error returns the error message
+func (e *NoConfigFile) Error() string {
+	if e.Err == nil {
+		return "no config file provided. use WithConfigFile() to set the config file"
+	}
+	return e.Err.Error()
+}
+
+// NoFeedName is returned when a feed name is required but not provided
+type NoFeedName struct {
+	Err error
+}
+
+// Error returns the error message
+func (e *NoFeedName) Error() string {
+	if e.Err == nil {
+		return "no feed name provided. use WithFeedName() to set the feed name"
+	}
+	return e.Err.Error()
+}
+
+// NoConfirm is returned when a confirmation is required but not provided
+type NoConfirm struct {
+	Err error
+}
+
+// Error returns the error message
+func (e *NoConfirm) Error() string {
+	if e.Err == nil {
+		return "user rejected confirmation"
+	}
+	return e.Err.Error()
+}
+
+// NothingToCancel is returned when there are no scheduled statuses to cancel
+type NothingToCancel struct {
+	Err      error
+	Msg      string
+	feedname string
+}
+
+// Error returns the error message
+func (e *NothingToCancel) Error() string {
+	msg := e.Msg
+	if msg == "" {
+		msg = "no scheduled statuses to cancel"
+	}
+	if e.feedname != "" {
+		msg += " for " + e.feedname
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+// CancelFailure is returned when some scheduled statuses could not be cancelled
+type CancelFailure struct {
+	Err       error
+	Msg       string
+	Cancelled int
+	Failed    int
+}
+
+// Error returns the error message
+func (e *CancelFailure) Error() string {
+	msg := e.Msg
+	if msg == "" {
+		msg = fmt.Sprintf("failed to cancel %d scheduled statuses (%d cancelled)", e.Failed, e.Cancelled)
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+// Unwrap returns the error from the first failed cancel
+func (e *CancelFailure) Unwrap() error {
+	return e.Err
+}
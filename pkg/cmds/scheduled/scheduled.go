@@ -0,0 +1,265 @@
+package scheduled
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"unicode/utf8"
+
+	"github.com/rmrfslashbin/mastopost/pkg/history"
+	"github.com/rmrfslashbin/mastopost/pkg/mastoclient"
+	"github.com/rmrfslashbin/mastopost/pkg/utils"
+	"github.com/rs/zerolog"
+)
+
+// ScheduledOptions is a function that can be used to configure the ScheduledConfig
+type ScheduledOptions func(config *ScheduledConfig)
+
+// ScheduledConfig is the configuration for the scheduled command
+type ScheduledConfig struct {
+	log         *zerolog.Logger
+	configFile  *string
+	feedName    *string
+	historyFile string
+	awsprofile  *string
+	awsregion   *string
+}
+
+// CancelInput selects the scheduled statuses to cancel
+type CancelInput struct {
+	// IDs are the IDs of the scheduled statuses to cancel
+	IDs []string
+
+	// All cancels every scheduled status on the account
+	All bool
+
+	// Confirm skips the confirmation prompt
+	Confirm bool
+}
+
+// NewScheduled creates a new ScheduledConfig
+func NewScheduled(opts ...ScheduledOptions) (*ScheduledConfig, error) {
+	cfg := &ScheduledConfig{}
+
+	// apply the list of options to Config
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	// Set up the default logger if not set
+	if cfg.log == nil {
+		log := zerolog.New(os.Stderr).With().Timestamp().Logger()
+		cfg.log = &log
+	}
+
+	return cfg, nil
+}
+
+// WithConfigFile sets the config file to use
+func WithConfigFile(configFile *string) ScheduledOptions {
+	return func(config *ScheduledConfig) {
+		config.configFile = configFile
+	}
+}
+
+// WithFeedName sets the feed name
+func WithFeedName(feedName *string) ScheduledOptions {
+	return func(config *ScheduledConfig) {
+		config.feedName = feedName
+	}
+}
+
+// WithHistoryFile sets the history file used when the config doesn't set one
+func WithHistoryFile(historyFile string) ScheduledOptions {
+	return func(config *ScheduledConfig) {
+		config.historyFile = historyFile
+	}
+}
+
+// WithLambda uses the config, state and history of the feed's Lambda
+// function job in SSM
+func WithLambda(awsprofile *string, awsregion *string) ScheduledOptions {
+	return func(config *ScheduledConfig) {
+		config.awsprofile = awsprofile
+		config.awsregion = awsregion
+	}
+}
+
+// WithLogger sets the logger to use
+func WithLogger(log *zerolog.Logger) ScheduledOptions {
+	return func(config *ScheduledConfig) {
+		config.log = log
+	}
+}
+
+// List lists the scheduled statuses on the feed's account, soonest first
+func (c *ScheduledConfig) List() error {
+	feed, err := c.load()
+	if err != nil {
+		return err
+	}
+	client, err := newClient(c.log, feed)
+	if err != nil {
+		return err
+	}
+
+	pending, err := client.ScheduledStatuses()
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tSCHEDULED AT\tVISIBILITY\tTEXT")
+	for _, status := range pending {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n",
+			status.ID, status.ScheduledAt.Local().Format("2006-01-02 15:04:05"), status.Params.Visibility, summary(status.Params.Text))
+	}
+	return tw.Flush()
+}
+
+// Cancel cancels scheduled statuses on the feed's account. A cancelled
+// item stays seen, so it isn't posted again, but its scheduled status is
+// forgotten and recorded as deleted in the history.
+func (c *ScheduledConfig) Cancel(input *CancelInput) error {
+	feed, err := c.load()
+	if err != nil {
+		return err
+	}
+	client, err := newClient(c.log, feed)
+	if err != nil {
+		return err
+	}
+
+	pending, err := client.ScheduledStatuses()
+	if err != nil {
+		return err
+	}
+
+	var cancel []*mastoclient.ScheduledStatus
+	for _, status := range pending {
+		if input.All || utils.Contains(input.IDs, string(status.ID)) {
+			cancel = append(cancel, status)
+		}
+	}
+	if len(cancel) == 0 {
+		return &NothingToCancel{feedname: *c.feedName}
+	}
+
+	if !input.Confirm {
+		fmt.Println("Confirm cancel:")
+		fmt.Printf("Feed name:            %s\n", *c.feedName)
+		for _, status := range cancel {
+			fmt.Printf("  %s  %s  %s\n", status.ID, status.ScheduledAt.Local().Format("2006-01-02 15:04:05"), summary(status.Params.Text))
+		}
+		fmt.Print("Cancel these scheduled statuses? (y/n): ")
+		var userConfirm string
+		fmt.Scanln(&userConfirm)
+		if strings.ToLower(userConfirm) != "y" {
+			return &NoConfirm{}
+		}
+	}
+
+	// The feed's items scheduled as each status
+	items := make(map[string]string)
+	for id, posted := range feed.State.Posted {
+		if posted.ScheduledID != "" {
+			items[posted.ScheduledID] = id
+		}
+	}
+
+	runID := history.NewRunID(time.Now())
+	var records []history.Record
+	var firstErr error
+	failed := 0
+	for _, status := range cancel {
+		if err := client.CancelScheduled(status.ID); err != nil && !mastoclient.IsNotFound(err) {
+			c.log.Error().
+				Err(err).
+				Str("scheduledId", string(status.ID)).
+				Msg("error cancelling scheduled status")
+			if firstErr == nil {
+				firstErr = err
+			}
+			failed++
+			continue
+		}
+
+		record := history.Record{
+			RunID:       runID,
+			FeedName:    feed.Name,
+			ScheduledID: string(status.ID),
+			Instance:    feed.Config.Instance,
+			Time:        time.Now().UTC(),
+			Outcome:     history.OUTCOME_DELETED,
+		}
+		if id, ok := items[string(status.ID)]; ok {
+			record.ItemID = id
+			record.ScheduledAt = feed.State.Posted[id].ScheduledAt
+			delete(feed.State.Posted, id)
+		}
+		records = append(records, record)
+
+		c.log.Info().
+			Str("feedname", feed.Name).
+			Str("scheduledId", string(status.ID)).
+			Msg("cancelled scheduled status")
+	}
+
+	if len(records) > 0 {
+		if err := feed.Save(); err != nil {
+			return err
+		}
+		if err := feed.Store.Add(records); err != nil {
+			c.log.Warn().Err(err).Msg("unable to save history")
+		}
+	}
+
+	if failed > 0 {
+		return &CancelFailure{Cancelled: len(cancel) - failed, Failed: failed, Err: firstErr}
+	}
+	return nil
+}
+
+// load reads the feed's config, state and history from the config file, or
+// from SSM for the feed's Lambda function job
+func (c *ScheduledConfig) load() (*history.Feed, error) {
+	if c.feedName == nil {
+		return nil, &NoFeedName{}
+	}
+
+	if c.awsregion != nil {
+		return history.LoadSSMFeed(c.log, *c.awsprofile, *c.awsregion, *c.feedName)
+	}
+
+	if c.configFile == nil {
+		return nil, &NoConfigFile{}
+	}
+	return history.LoadFeed(*c.configFile, *c.feedName, c.historyFile)
+}
+
+// newClient sets up the Mastodon client for the feed's account
+func newClient(log *zerolog.Logger, feed *history.Feed) (*mastoclient.Config, error) {
+	instanceUrl, err := url.Parse(feed.Config.Instance)
+	if err != nil {
+		return nil, err
+	}
+	return mastoclient.New(
+		mastoclient.WithLogger(log),
+		mastoclient.WithInstance(instanceUrl),
+		mastoclient.WithClientID(feed.Config.ClientId),
+		mastoclient.WithClientSecret(feed.Config.ClientSecret),
+		mastoclient.WithToken(feed.Config.AccessToken),
+	)
+}
+
+// summary returns the first line of a status's text, shortened for a listing
+func summary(text string) string {
+	line, _, _ := strings.Cut(text, "\n")
+	if utf8.RuneCountInString(line) > 60 {
+		line = string([]rune(line)[:59]) + "…"
+	}
+	return line
+}
@@ -0,0 +1,118 @@
+package undo
+
+import "fmt"
+
+// NoConfigFile is returned when a filename is required but not provided
+type NoConfigFile struct {
+	Err error
+}
+
+// Error returns the error message
+func (e *NoConfigFile) Error() string {
+	if e.Err == nil {
+		return "no config file provided. use WithConfigFile() to set the config file"
+	}
+	return e.Err.Error()
+}
+
+// NoFeedName is returned when a feed name is required but not provided
+type NoFeedName struct {
+	Err error
+}
+
+// Error returns the error message
+func (e *NoFeedName) Error() string {
+	if e.Err == nil {
+		return "no feed name provided. use WithFeedName() to set the feed name"
+	}
+	return e.Err.Error()
+}
+
+// NoConfirm is returned when a confirmation is required but not provided
+type NoConfirm struct {
+	Err error
+}
+
+// Error returns the error message
+func (e *NoConfirm) Error() string {
+	if e.Err == nil {
+		return "user rejected confirmation"
+	}
+	return e.Err.Error()
+}
+
+// NothingToUndo is returned when the history has no posts to undo
+type NothingToUndo struct {
+	Err      error
+	Msg      string
+	feedname string
+}
+
+// Error returns the error message
+func (e *NothingToUndo) Error() string {
+	msg := e.Msg
+	if msg == "" {
+		msg = "no posts to undo in the history"
+	}
+	if e.feedname != "" {
+		msg += " for " + e.feedname
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+// NotPublished is returned when a scheduled post is gone from the scheduled
+// statuses but the status it was published as can't be found
+type NotPublished struct {
+	Err error
+	Msg string
+	id  string
+}
+
+// Error returns the error message
+func (e *NotPublished) Error() string {
+	msg := e.Msg
+	if msg == "" {
+		msg = "scheduled post is gone but its published status wasn't found"
+	}
+	if e.id != "" {
+		msg += ": scheduled status " + e.id
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+// Unwrap returns the underlying error
+func (e *NotPublished) Unwrap() error {
+	return e.Err
+}
+
+// UndoFailure is returned when some posts could not be deleted. They're
+// left in the feed's state and history, so the undo can be run again.
+type UndoFailure struct {
+	Err     error
+	Msg     string
+	Deleted int
+	Failed  int
+}
+
+// Error returns the error message
+func (e *UndoFailure) Error() string {
+	msg := e.Msg
+	if msg == "" {
+		msg = fmt.Sprintf("failed to delete %d posts (%d deleted)", e.Failed, e.Deleted)
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+// Unwrap returns the error from the first failed delete
+func (e *UndoFailure) Unwrap() error {
+	return e.Err
+}
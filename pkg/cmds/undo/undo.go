@@ -0,0 +1,362 @@
+package undo
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-mastodon"
+	"github.com/rmrfslashbin/mastopost/pkg/config"
+	"github.com/rmrfslashbin/mastopost/pkg/history"
+	"github.com/rmrfslashbin/mastopost/pkg/mastoclient"
+	"github.com/rs/zerolog"
+)
+
+// UndoOptions is a function that can be used to configure the UndoConfig
+type UndoOptions func(config *UndoConfig)
+
+// UndoConfig is the configuration for the undo command
+type UndoConfig struct {
+	log         *zerolog.Logger
+	configFile  *string
+	feedName    *string
+	historyFile string
+	awsprofile  *string
+	awsregion   *string
+}
+
+// UndoInput selects the posts to undo
+type UndoInput struct {
+	// RunID undoes the posts of this run
+	RunID string
+
+	// Since undoes the posts made since this time
+	Since *time.Time
+
+	// Rewind restores the feed's state to before the posts were made, so
+	// the items are posted again on the next run
+	Rewind bool
+
+	// Confirm skips the confirmation prompt
+	Confirm bool
+
+	// DryRun lists the posts without deleting them
+	DryRun bool
+}
+
+// NewUndo creates a new UndoConfig
+func NewUndo(opts ...UndoOptions) (*UndoConfig, error) {
+	cfg := &UndoConfig{}
+
+	// apply the list of options to Config
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	// Set up the default logger if not set
+	if cfg.log == nil {
+		log := zerolog.New(os.Stderr).With().Timestamp().Logger()
+		cfg.log = &log
+	}
+
+	return cfg, nil
+}
+
+// WithConfigFile sets the config file to use
+func WithConfigFile(configFile *string) UndoOptions {
+	return func(config *UndoConfig) {
+		config.configFile = configFile
+	}
+}
+
+// WithFeedName sets the feed name
+func WithFeedName(feedName *string) UndoOptions {
+	return func(config *UndoConfig) {
+		config.feedName = feedName
+	}
+}
+
+// WithHistoryFile sets the history file used when the config doesn't set one
+func WithHistoryFile(historyFile string) UndoOptions {
+	return func(config *UndoConfig) {
+		config.historyFile = historyFile
+	}
+}
+
+// WithLambda undoes a run of the Lambda function, using the feed's config,
+// state and history in SSM
+func WithLambda(awsprofile *string, awsregion *string) UndoOptions {
+	return func(config *UndoConfig) {
+		config.awsprofile = awsprofile
+		config.awsregion = awsregion
+	}
+}
+
+// WithLogger sets the logger to use
+func WithLogger(log *zerolog.Logger) UndoOptions {
+	return func(config *UndoConfig) {
+		config.log = log
+	}
+}
+
+// Undo deletes the posts made by a run (the last one by default, or the one
+// given by RunID) or since a time, and optionally rewinds the feed's state
+// to before them
+func (c *UndoConfig) Undo(input *UndoInput) error {
+	if c.feedName == nil {
+		return &NoFeedName{}
+	}
+
+	feed, err := c.load()
+	if err != nil {
+		return err
+	}
+
+	records, err := feed.Store.Query(&history.Query{FeedName: feed.Name})
+	if err != nil {
+		return err
+	}
+
+	runLabel, posts := selectPosts(records, input)
+	if len(posts) == 0 {
+		return &NothingToUndo{feedname: feed.Name}
+	}
+
+	if !input.Confirm && !input.DryRun {
+		fmt.Println("Confirm undo:")
+		fmt.Printf("Feed name:               %s\n", feed.Name)
+		fmt.Printf("Run:                     %s\n", runLabel)
+		fmt.Printf("Mastodon instance:       %s\n", feed.Config.Instance)
+		fmt.Printf("Rewind feed state:       %t\n", input.Rewind)
+		fmt.Printf("Posts to delete:         %d\n", len(posts))
+		for _, post := range posts {
+			fmt.Printf("  %s  %s\n", post.StatusID, post.Title)
+		}
+		fmt.Print("Confirm delete of posts? (y/n): ")
+		var userConfirm string
+		fmt.Scanln(&userConfirm)
+		if strings.ToLower(userConfirm) != "y" {
+			return &NoConfirm{}
+		}
+	}
+
+	instanceUrl, err := url.Parse(feed.Config.Instance)
+	if err != nil {
+		return err
+	}
+	client, err := mastoclient.New(
+		mastoclient.WithLogger(c.log),
+		mastoclient.WithInstance(instanceUrl),
+		mastoclient.WithClientID(feed.Config.ClientId),
+		mastoclient.WithClientSecret(feed.Config.ClientSecret),
+		mastoclient.WithToken(feed.Config.AccessToken),
+	)
+	if err != nil {
+		return err
+	}
+
+	undoRunID := history.NewRunID(time.Now())
+	var undone []history.Record
+	// deleted are the posts deleted, as selected, for rewinding the state
+	var deleted []history.Record
+	var firstErr error
+	failed := 0
+
+	// The statuses of the feed's other posts, so a published scheduled post
+	// isn't taken for one of them
+	known := make(map[mastodon.ID]bool)
+	for _, posted := range feed.State.Posted {
+		for _, id := range posted.StatusIDs() {
+			if id != "" {
+				known[mastodon.ID(id)] = true
+			}
+		}
+	}
+
+	for _, post := range posts {
+		if input.DryRun {
+			c.log.Info().
+				Str("feedname", feed.Name).
+				Str("statusId", post.StatusID).
+				Str("scheduledId", post.ScheduledID).
+				Str("title", post.Title).
+				Msg("dryrun mode. not deleting from Mastodon")
+			continue
+		}
+
+		if err := deletePost(client, &post, known); err != nil {
+			c.log.Error().
+				Err(err).
+				Str("statusId", post.StatusID).
+				Str("scheduledId", post.ScheduledID).
+				Str("title", post.Title).
+				Msg("error deleting from Mastodon")
+			if firstErr == nil {
+				firstErr = err
+			}
+			failed++
+			continue
+		}
+
+		deleted = append(deleted, post)
+		delete(feed.State.Posted, post.ItemID)
+		if input.Rewind {
+			delete(feed.State.Seen, post.ItemID)
+		}
+
+		record := post
+		record.RunID = undoRunID
+		record.Time = time.Now().UTC()
+		record.Outcome = history.OUTCOME_UNDONE
+		record.Error = ""
+		record.PrevLastUpdated = nil
+		record.PrevLastPublished = nil
+		undone = append(undone, record)
+
+		c.log.Info().
+			Str("feedname", feed.Name).
+			Str("statusId", post.StatusID).
+			Str("title", post.Title).
+			Msg("deleted from Mastodon")
+	}
+
+	if input.DryRun {
+		return nil
+	}
+
+	// Only the posts actually deleted are rewound, so the next run doesn't
+	// post again the items of posts still live
+	if input.Rewind && len(deleted) > 0 {
+		rewind(feed.State, deleted)
+		c.log.Info().
+			Str("feedname", feed.Name).
+			Str("lastupdate", feed.State.LastUpdated.String()).
+			Str("lastpublished", feed.State.LastPublished.String()).
+			Msg("rewound feed state")
+	}
+
+	if err := feed.Save(); err != nil {
+		return err
+	}
+
+	if err := feed.Store.Add(undone); err != nil {
+		c.log.Warn().Err(err).Msg("unable to save history")
+	}
+
+	if failed > 0 {
+		return &UndoFailure{Deleted: len(undone), Failed: failed, Err: firstErr}
+	}
+	return nil
+}
+
+// load reads the feed's config, state and history from the config file, or
+// from SSM when undoing a run of the Lambda function
+func (c *UndoConfig) load() (*history.Feed, error) {
+	if c.awsregion != nil {
+		return history.LoadSSMFeed(c.log, *c.awsprofile, *c.awsregion, *c.feedName)
+	}
+
+	if c.configFile == nil {
+		return nil, &NoConfigFile{}
+	}
+	return history.LoadFeed(*c.configFile, *c.feedName, c.historyFile)
+}
+
+// selectPosts returns the posts in the history to undo, newest first, and a
+// label for the run or time they were selected by. Posts already deleted are
+// left out.
+func selectPosts(records []history.Record, input *UndoInput) (string, []history.Record) {
+	gone := make(map[string]bool)
+	for _, record := range records {
+		switch record.Outcome {
+		case history.OUTCOME_DELETED, history.OUTCOME_EXPIRED, history.OUTCOME_UNDONE:
+			gone[record.StatusID] = true
+			gone[record.ScheduledID] = true
+		}
+	}
+
+	var posted []history.Record
+	for _, record := range records {
+		switch {
+		case record.Outcome == history.OUTCOME_POSTED && record.StatusID != "" && !gone[record.StatusID]:
+			posted = append(posted, record)
+		case record.Outcome == history.OUTCOME_SCHEDULED && record.ScheduledID != "" && !gone[record.ScheduledID]:
+			posted = append(posted, record)
+		}
+	}
+
+	label := input.RunID
+	if input.Since != nil {
+		label = "since " + input.Since.Format(time.RFC3339)
+	} else if label == "" && len(posted) > 0 {
+		// Default to the last run that posted anything
+		label = posted[len(posted)-1].RunID
+	}
+
+	var posts []history.Record
+	for i := len(posted) - 1; i >= 0; i-- {
+		record := posted[i]
+		if input.Since != nil {
+			if record.Time.Before(*input.Since) {
+				continue
+			}
+		} else if record.RunID != label {
+			continue
+		}
+		posts = append(posts, record)
+	}
+	return label, posts
+}
+
+// deletePost deletes a post and the rest of its thread, or cancels it if it
+// was scheduled. A scheduled post the instance has already published is
+// looked up, leaving out the statuses in skip, and deleted, and its status ID
+// is filled in.
+func deletePost(client *mastoclient.Config, post *history.Record, skip map[mastodon.ID]bool) error {
+	if post.StatusID == "" {
+		err := client.CancelScheduled(mastodon.ID(post.ScheduledID))
+		if err == nil || !mastoclient.IsNotFound(err) {
+			return err
+		}
+
+		// Gone from the scheduled statuses, so most likely published
+		if post.ScheduledAt == nil {
+			return &NotPublished{Err: err, id: post.ScheduledID}
+		}
+		status, err := client.PublishedStatus(*post.ScheduledAt, skip)
+		if err != nil {
+			return err
+		}
+		if status == nil {
+			return &NotPublished{id: post.ScheduledID}
+		}
+		post.StatusID = string(status.ID)
+	}
+
+	ids := []mastodon.ID{mastodon.ID(post.StatusID)}
+	for _, id := range post.ThreadIDs {
+		ids = append(ids, mastodon.ID(id))
+	}
+	return client.DeleteThread(ids)
+}
+
+// rewind restores the feed's last updated and last published times to
+// before the earliest of the posts, and clears the fetch validators so the
+// feed is fetched in full on the next run
+func rewind(state *config.FeedLastUpdate, posts []history.Record) {
+	for i := len(posts) - 1; i >= 0; i-- {
+		if posts[i].PrevLastUpdated != nil || posts[i].PrevLastPublished != nil {
+			if posts[i].PrevLastUpdated != nil {
+				state.LastUpdated = posts[i].PrevLastUpdated
+			}
+			if posts[i].PrevLastPublished != nil {
+				state.LastPublished = posts[i].PrevLastPublished
+			}
+			break
+		}
+	}
+	state.ETag = ""
+	state.LastModified = ""
+}
@@ -0,0 +1,268 @@
+package undo
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattn/go-mastodon"
+	"github.com/rmrfslashbin/mastopost/pkg/config"
+	"github.com/rmrfslashbin/mastopost/pkg/history"
+	"github.com/rmrfslashbin/mastopost/pkg/mastoclient"
+	"github.com/rs/zerolog"
+)
+
+var testTime = time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+
+// testHistory returns the history of two runs, in the order it was written
+func testHistory() []history.Record {
+	return []history.Record{
+		{RunID: "run1", Title: "a", Time: testTime, Outcome: history.OUTCOME_POSTED, StatusID: "1"},
+		{RunID: "run1", Title: "b", Time: testTime.Add(time.Minute), Outcome: history.OUTCOME_POSTED, StatusID: "2"},
+		{RunID: "run2", Title: "c", Time: testTime.Add(time.Hour), Outcome: history.OUTCOME_POSTED, StatusID: "3"},
+		{RunID: "run2", Title: "d", Time: testTime.Add(time.Hour + time.Minute), Outcome: history.OUTCOME_FAILED},
+		{RunID: "run2", Title: "e", Time: testTime.Add(time.Hour + 2*time.Minute), Outcome: history.OUTCOME_SCHEDULED, ScheduledID: "s1"},
+		{RunID: "run2", Title: "f", Time: testTime.Add(time.Hour + 3*time.Minute), Outcome: history.OUTCOME_POSTED, StatusID: "4"},
+		{RunID: "run3", Title: "f", Time: testTime.Add(2 * time.Hour), Outcome: history.OUTCOME_DELETED, StatusID: "4"},
+		{RunID: "run4", Title: "c", Time: testTime.Add(3 * time.Hour), Outcome: history.OUTCOME_EDITED, StatusID: "3"},
+	}
+}
+
+func TestSelectPosts(t *testing.T) {
+	since := testTime.Add(30 * time.Second)
+	tests := []struct {
+		name      string
+		input     *UndoInput
+		wantLabel string
+		want      []string
+	}{
+		{"last run", &UndoInput{}, "run2", []string{"e", "c"}},
+		{"run", &UndoInput{RunID: "run1"}, "run1", []string{"b", "a"}},
+		{"no such run", &UndoInput{RunID: "run9"}, "run9", nil},
+		{"since", &UndoInput{Since: &since}, "since 2024-01-01T10:00:30Z", []string{"e", "c", "b"}},
+	}
+	for _, tt := range tests {
+		label, posts := selectPosts(testHistory(), tt.input)
+		var titles []string
+		for _, post := range posts {
+			titles = append(titles, post.Title)
+		}
+		if label != tt.wantLabel {
+			t.Errorf("%s: label is %q, want %q", tt.name, label, tt.wantLabel)
+		}
+		if strings.Join(titles, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("%s: selected %v, want %v", tt.name, titles, tt.want)
+		}
+	}
+}
+
+func TestSelectPostsUndone(t *testing.T) {
+	records := testHistory()
+	records = append(records,
+		history.Record{RunID: "undo1", Title: "c", Time: testTime.Add(4 * time.Hour), Outcome: history.OUTCOME_UNDONE, StatusID: "3"},
+		history.Record{RunID: "undo1", Title: "e", Time: testTime.Add(4 * time.Hour), Outcome: history.OUTCOME_UNDONE, ScheduledID: "s1"},
+	)
+
+	// Run 2 has nothing left, so the default is the run before it
+	label, posts := selectPosts(records, &UndoInput{})
+	if label != "run1" || len(posts) != 2 {
+		t.Errorf("selected %d posts from %q, want 2 from run1", len(posts), label)
+	}
+	if _, posts := selectPosts(records, &UndoInput{RunID: "run2"}); len(posts) != 0 {
+		t.Errorf("selected %d undone posts", len(posts))
+	}
+}
+
+func TestRewind(t *testing.T) {
+	updated := testTime.Add(-time.Hour)
+	published := testTime.Add(-2 * time.Hour)
+	later := testTime
+	lastUpdated := testTime.Add(time.Hour)
+	state := &config.FeedLastUpdate{
+		LastUpdated:   &lastUpdated,
+		LastPublished: &lastUpdated,
+		ETag:          `"abc"`,
+		LastModified:  "Mon, 01 Jan 2024 11:00:00 GMT",
+	}
+
+	// Newest first, as selected. Only the first post of a run carries the
+	// previous times, and rewinding goes back to the earliest post's.
+	posts := []history.Record{
+		{Title: "c", PrevLastUpdated: &later, PrevLastPublished: &later},
+		{Title: "b"},
+		{Title: "a", PrevLastUpdated: &updated, PrevLastPublished: &published},
+	}
+	rewind(state, posts)
+	if !state.LastUpdated.Equal(updated) || !state.LastPublished.Equal(published) {
+		t.Errorf("rewound to %v and %v, want %v and %v", state.LastUpdated, state.LastPublished, updated, published)
+	}
+	if state.ETag != "" || state.LastModified != "" {
+		t.Errorf("validators %q and %q left in place", state.ETag, state.LastModified)
+	}
+
+	// Posts without previous times leave the times alone
+	state.LastUpdated = &lastUpdated
+	rewind(state, []history.Record{{Title: "a"}})
+	if !state.LastUpdated.Equal(lastUpdated) {
+		t.Errorf("last updated moved to %v", state.LastUpdated)
+	}
+}
+
+func TestUndoRewindsDeletedOnly(t *testing.T) {
+	// Status 1 can't be deleted; status 2 can
+	var deleted []string
+	instance := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodDelete && r.URL.Path == "/api/v1/statuses/2":
+			deleted = append(deleted, "2")
+			w.Write([]byte(`{"id":"2"}`))
+		case r.Method == http.MethodDelete && r.URL.Path == "/api/v1/statuses/1":
+			http.Error(w, `{"error":"unavailable"}`, http.StatusServiceUnavailable)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer instance.Close()
+
+	dir := t.TempDir()
+	stateFile := filepath.Join(dir, "test.gob")
+	historyFile := filepath.Join(dir, "history.jsonl")
+	configFile := filepath.Join(dir, "config.json")
+	cfg := config.Config{
+		Feeds: map[string]config.FeedConfig{"test": {
+			Instance:       instance.URL,
+			ClientId:       "client",
+			ClientSecret:   "secret",
+			AccessToken:    "token",
+			LastUpdateFile: stateFile,
+		}},
+		HistoryFile: historyFile,
+	}
+	b, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(configFile, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Two runs, each starting from the state before it
+	before1 := testTime.Add(-time.Hour)
+	before2 := testTime.Add(30 * time.Minute)
+	latest := testTime.Add(2 * time.Hour)
+	state, err := config.NewLastUpdates(stateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.FeedLastUpdate.LastUpdated = &latest
+	state.FeedLastUpdate.LastPublished = &latest
+	state.FeedLastUpdate.Seen = map[string]time.Time{"a": testTime, "b": testTime}
+	if err := state.Save(); err != nil {
+		t.Fatal(err)
+	}
+	store, err := history.NewFile(historyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Add([]history.Record{
+		{RunID: "run1", FeedName: "test", ItemID: "a", Title: "a", Time: testTime, Outcome: history.OUTCOME_POSTED, StatusID: "1", PrevLastUpdated: &before1, PrevLastPublished: &before1},
+		{RunID: "run2", FeedName: "test", ItemID: "b", Title: "b", Time: testTime.Add(time.Hour), Outcome: history.OUTCOME_POSTED, StatusID: "2", PrevLastUpdated: &before2, PrevLastPublished: &before2},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	log := zerolog.Nop()
+	feedName := "test"
+	undo, err := NewUndo(WithLogger(&log), WithConfigFile(&configFile), WithFeedName(&feedName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	since := testTime.Add(-time.Minute)
+	err = undo.Undo(&UndoInput{Since: &since, Rewind: true, Confirm: true})
+	if _, ok := err.(*UndoFailure); !ok {
+		t.Fatalf("Undo returned %v, want *UndoFailure", err)
+	}
+	if len(deleted) != 1 {
+		t.Fatalf("deleted %v, want status 2: %v", deleted, err)
+	}
+
+	// The state is only rewound to before the post that was deleted
+	state, err = config.NewLastUpdates(stateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !state.FeedLastUpdate.LastUpdated.Equal(before2) || !state.FeedLastUpdate.LastPublished.Equal(before2) {
+		t.Errorf("rewound to %v and %v, want %v", state.FeedLastUpdate.LastUpdated, state.FeedLastUpdate.LastPublished, before2)
+	}
+	if _, ok := state.FeedLastUpdate.Seen["a"]; !ok {
+		t.Error("item of the post still live forgotten")
+	}
+	if _, ok := state.FeedLastUpdate.Seen["b"]; ok {
+		t.Error("item of the deleted post still seen")
+	}
+}
+
+func TestDeletePostPublished(t *testing.T) {
+	// Scheduled status s1 is gone, published as status 7 just after its time
+	var deleted []string
+	instance := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/api/v1/accounts/verify_credentials":
+			w.Write([]byte(`{"id":"1"}`))
+		case r.Method == http.MethodGet && r.URL.Path == "/api/v1/accounts/1/statuses":
+			page := []map[string]interface{}{}
+			if r.URL.Query().Get("max_id") == "" {
+				page = append(page,
+					map[string]interface{}{"id": "7", "created_at": testTime.Add(20 * time.Second)},
+					map[string]interface{}{"id": "6", "created_at": testTime.Add(-time.Hour)},
+				)
+			}
+			json.NewEncoder(w).Encode(page)
+		case r.Method == http.MethodDelete && r.URL.Path == "/api/v1/statuses/7":
+			deleted = append(deleted, "7")
+			w.Write([]byte(`{"id":"7"}`))
+		default:
+			http.Error(w, `{"error":"Record not found"}`, http.StatusNotFound)
+		}
+	}))
+	defer instance.Close()
+
+	instanceUrl, err := url.Parse(instance.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := zerolog.Nop()
+	client, err := mastoclient.New(
+		mastoclient.WithLogger(&log),
+		mastoclient.WithInstance(instanceUrl),
+		mastoclient.WithClientID("client"),
+		mastoclient.WithClientSecret("secret"),
+		mastoclient.WithToken("token"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Status 7 belonging to another post, it isn't taken for this one
+	post := history.Record{ScheduledID: "s1", ScheduledAt: &testTime}
+	err = deletePost(client, &post, map[mastodon.ID]bool{"7": true})
+	if _, ok := err.(*NotPublished); !ok {
+		t.Fatalf("deletePost returned %v, want *NotPublished", err)
+	}
+	if len(deleted) != 0 {
+		t.Fatalf("deleted %v, another post's status", deleted)
+	}
+
+	if err := deletePost(client, &post, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 1 || post.StatusID != "7" {
+		t.Errorf("deleted %v and recorded status %q, want status 7", deleted, post.StatusID)
+	}
+}
@@ -6,6 +6,8 @@ import (
 	"io"
 	"os"
 	"time"
+
+	"github.com/mmcdole/gofeed"
 )
 
 // FilenameRequired is returned when a filename is required but not provided
@@ -74,6 +76,151 @@ type FeedConfig struct {
 	// Instance is the URL of the Mastodon instance
 	Instance string `json:"instance"`
 
+	// Timeout is the time limit for fetching the feed as a duration, e.g. "30s"
+	Timeout string `json:"timeout"`
+
+	// UserAgent is the User-Agent sent when fetching the feed
+	UserAgent string `json:"useragent"`
+
+	// Headers are extra HTTP headers sent when fetching the feed
+	Headers map[string]string `json:"headers"`
+
+	// BasicAuthUser is the HTTP basic auth user name for the feed
+	BasicAuthUser string `json:"basicauthuser"`
+
+	// BasicAuthPassword is the HTTP basic auth password for the feed
+	BasicAuthPassword string `json:"basicauthpassword"`
+
+	// ProxyURL is the URL of an HTTP proxy used to fetch the feed
+	ProxyURL string `json:"proxyurl"`
+
+	// FilterPublished also requires new items to be published after the last published item
+	FilterPublished bool `json:"filterpublished"`
+
+	// MaxPostsPerRun caps the number of items posted per run. Items over the cap
+	// are posted on later runs. Zero means no cap.
+	MaxPostsPerRun int `json:"maxpostsperrun"`
+
+	// MinAge is how many minutes after an item is published it's posted, so
+	// publishers can fix mistakes first. Younger items are carried forward
+	// to a later run. Zero posts items straight away.
+	MinAge int `json:"minage"`
+
+	// FirstRun is what to post on a new job's first run: "post-none",
+	// "post-latest-N" or "post-all". Defaults to "post-latest-1".
+	FirstRun string `json:"firstrun"`
+
+	// PostDelay is the pause between posts as a duration, e.g. "5s"
+	PostDelay string `json:"postdelay"`
+
+	// ScheduleSpacing spreads posts out as a duration, e.g. "10m": each new
+	// item is scheduled on the instance that long after the one before,
+	// instead of being posted straight away
+	ScheduleSpacing string `json:"schedulespacing"`
+
+	// Filters decide which items are posted
+	Filters *Filters `json:"filters"`
+
+	// PostingWindow limits when items are posted. Items that arrive outside
+	// it are held until the next run inside it.
+	PostingWindow *PostingWindow `json:"postingwindow"`
+
+	// ContentWarnings put a content warning on posts for items with certain
+	// categories or title keywords
+	ContentWarnings []ContentWarning `json:"contentwarnings"`
+
+	// Template is a Go text/template used to format posts. It's rendered with
+	// the feed item's fields plus .Feed and .FeedName. Defaults to
+	// utils.DEFAULT_TEMPLATE.
+	Template string `json:"template"`
+
+	// Hashtags are tags added to every post, after the item's categories
+	Hashtags []string `json:"hashtags"`
+
+	// HashtagMap maps categories (ignoring case) to the tag to use instead,
+	// or to "" to leave them out
+	HashtagMap map[string]string `json:"hashtagmap"`
+
+	// MaxHashtags caps the number of hashtags on a post. Zero means no cap.
+	MaxHashtags int `json:"maxhashtags"`
+
+	// Visibility is the visibility of posts: "public", "unlisted" or
+	// "private". Defaults to the account's default.
+	Visibility string `json:"visibility"`
+
+	// Language is the language of posts as an ISO 639 code such as "en", or
+	// "auto" to take it from the item's xml:lang or the feed's <language>
+	Language string `json:"language"`
+
+	// Excerpt is where the .Excerpt template field is taken from:
+	// "description" (the default), "lead" (the lead paragraph of the item's
+	// content) or "article" (the lead paragraph of the linked article)
+	Excerpt string `json:"excerpt"`
+
+	// ExcerptLength is the longest excerpt in characters. Defaults to 280.
+	ExcerptLength int `json:"excerptlength"`
+
+	// Media attaches the images in an item's enclosures, media:content or
+	// image to its post
+	Media bool `json:"media"`
+
+	// MaxAttachments caps the images attached to a post. Zero means as many
+	// as the instance allows.
+	MaxAttachments int `json:"maxattachments"`
+
+	// CardImage attaches the OpenGraph or Twitter card image of the linked
+	// article to posts for items with no images of their own
+	CardImage bool `json:"cardimage"`
+
+	// CardImageMaxSize is the largest card image attached, in bytes. Zero
+	// means the instance's limit.
+	CardImageMaxSize int64 `json:"cardimagemaxsize"`
+
+	// CardTimeout is the time limit for fetching the article and its card
+	// image as a duration, e.g. "10s"
+	CardTimeout string `json:"cardtimeout"`
+
+	// EditUpdates edits the posts of items that change after they're
+	// posted, such as a corrected headline
+	EditUpdates bool `json:"editupdates"`
+
+	// EditWindow is how long after posting an item's post is still edited,
+	// as a duration, e.g. "24h". Defaults to 24 hours.
+	EditWindow string `json:"editwindow"`
+
+	// DeleteTombstones deletes the posts of items the feed marks as deleted
+	// with Atom at:deleted-entry tombstones
+	DeleteTombstones bool `json:"deletetombstones"`
+
+	// DeleteRemoved deletes the posts of items that drop out of the feed
+	// within the delete window of being posted
+	DeleteRemoved bool `json:"deleteremoved"`
+
+	// DeleteWindow is how long after posting an item that drops out of the
+	// feed still has its post deleted, as a duration, e.g. "6h". Defaults to
+	// 24 hours.
+	DeleteWindow string `json:"deletewindow"`
+
+	// Thread posts items too long for one status as a thread of replies
+	// instead of shortening them
+	Thread bool `json:"thread"`
+
+	// ThreadUnlisted makes the follow-ups of a public thread unlisted so
+	// only the first status shows on public timelines
+	ThreadUnlisted bool `json:"threadunlisted"`
+
+	// MaxThreadPosts caps the statuses in a thread. Defaults to 10.
+	MaxThreadPosts int `json:"maxthreadposts"`
+
+	// ExpireAfter is how many days a post is kept before prune deletes it.
+	// Zero keeps posts forever.
+	ExpireAfter int `json:"expireafter"`
+
+	// ExpireScan makes prune delete every old status on the account, not
+	// just the posts remembered in the feed's state. Only use it on accounts
+	// the feed has to itself.
+	ExpireScan bool `json:"expirescan"`
+
 	// GOB file to store the last update time data
 	LastUpdateFile string `json:"lastupdatefile"`
 
@@ -82,6 +229,72 @@ type FeedConfig struct {
 	ScheduleExpression string `json:"schedule"`
 }
 
+// PostingWindow is when a feed's items may be posted
+type PostingWindow struct {
+	// Days are the days of the week posts go out on, e.g. ["mon", "tue"].
+	// Empty means every day.
+	Days []string `json:"days"`
+
+	// Times are the times of day posts go out, as "HH:MM-HH:MM" ranges, e.g.
+	// ["07:00-12:00", "13:00-22:00"]. A range that ends before it starts
+	// runs past midnight and belongs to the day it starts on. Empty means
+	// all day.
+	Times []string `json:"times"`
+
+	// Timezone is the IANA time zone of Days and Times, e.g.
+	// "Europe/Berlin". Defaults to UTC.
+	Timezone string `json:"timezone"`
+}
+
+// Filters are include and exclude rules for a feed's items. An item is
+// posted if it matches any include rule (or there are none) and no exclude rule.
+type Filters struct {
+	// Include rules, any of which lets an item through
+	Include []FilterRule `json:"include"`
+
+	// Exclude rules, any of which skips an item
+	Exclude []FilterRule `json:"exclude"`
+}
+
+// FilterRule matches items on one of their fields, or combines other rules.
+// A rule with All or Any set is a group and ignores its own match settings.
+type FilterRule struct {
+	// Field is the item field matched: "title", "description", "categories",
+	// "author" or "link". Empty matches any of them.
+	Field string `json:"field"`
+
+	// Contains matches fields containing this text
+	Contains string `json:"contains"`
+
+	// Regex matches fields matching this regular expression
+	Regex string `json:"regex"`
+
+	// IgnoreCase makes Contains and Regex case-insensitive
+	IgnoreCase bool `json:"ignorecase"`
+
+	// All matches if every one of these rules matches
+	All []FilterRule `json:"all"`
+
+	// Any matches if at least one of these rules matches
+	Any []FilterRule `json:"any"`
+}
+
+// ContentWarning is a rule that puts a content warning on posts. It applies
+// to items with any of its categories or with any of its keywords in the title.
+type ContentWarning struct {
+	// Categories are matched against the item's categories, ignoring case
+	Categories []string `json:"categories"`
+
+	// Keywords are matched as whole words in the item's title, ignoring case
+	Keywords []string `json:"keywords"`
+
+	// Text is the content warning. Texts from several matching rules are combined.
+	Text string `json:"text"`
+
+	// Sensitive marks the post's media as sensitive
+	Sensitive bool `json:"sensitive"`
+}
+
 // LambdaFunctionConfig contains the configuration for a lambda function
 type LambdaFunctionConfig struct {
 	// FunctionName is the name of the lambda function
@@ -101,6 +314,9 @@ type Config struct {
 
 	// LambdaFunctionConfig is the configuration for the Lambda function
 	LambdaFunctionConfig map[string]LambdaFunctionConfig `json:"lambdaFunctions"`
+
+	// HistoryFile is the file the history of what's posted is kept in
+	HistoryFile string `json:"historyFile"`
 }
 
 // FeedLastUpdate is the configuration for a single RSS feed
@@ -113,6 +329,67 @@ type FeedLastUpdate struct {
 
 	// LastPublished is the last date an item was published
 	LastPublished *time.Time `json:"lastpublished"`
+
+	// ETag is the ETag validator returned by the last fetch of the feed
+	ETag string `json:"etag"`
+
+	// LastModified is the Last-Modified validator returned by the last fetch of the feed
+	LastModified string `json:"lastmodified"`
+
+	// Seen maps the ID of each item already handled to the time it was last seen in the feed
+	Seen map[string]time.Time `json:"seen"`
+
+	// FirstSeen maps the ID of each undated item in the feed to the time it was first seen
+	FirstSeen map[string]time.Time `json:"firstseen"`
+
+	// Current holds the IDs of the items in the feed on the last fetch, so
+	// they're kept if the seen set has to be trimmed to be stored
+	Current map[string]bool `json:"current"`
+
+	// SeenTrimmed is set when items still in the feed had to be dropped from
+	// the seen set to store it. The next run then also requires new items
+	// to be published after the last published item, so they aren't posted again.
+	SeenTrimmed bool `json:"seentrimmed"`
+
+	// Posted maps the ID of each item posted to the status it was posted as
+	Posted map[string]PostedItem `json:"posted"`
+
+	// Held maps the ID of each item held outside the posting window to the
+	// item, so it's still posted if it drops out of the feed meanwhile
+	Held map[string]*gofeed.Item `json:"held"`
+}
+
+// PostedItem is the status a feed item was posted as
+type PostedItem struct {
+	// StatusID is the ID of the status
+	StatusID string `json:"status_id"`
+
+	// PostedAt is when the status was posted
+	PostedAt time.Time `json:"posted_at"`
+
+	// Updated is the item's update date when it was last posted or edited
+	Updated *time.Time `json:"updated"`
+
+	// Hash is the item's content hash when it was last posted or edited
+	Hash string `json:"hash"`
+
+	// ThreadIDs are the IDs of the follow-up statuses if the item was
+	// posted as a thread, in order
+	ThreadIDs []string `json:"thread_ids,omitempty"`
+
+	// ScheduledID is the ID of the scheduled status if the item was
+	// scheduled. StatusID stays empty, since the status only gets its ID
+	// when the instance publishes it.
+	ScheduledID string `json:"scheduled_id,omitempty"`
+
+	// ScheduledAt is when the scheduled status is published
+	ScheduledAt *time.Time `json:"scheduled_at,omitempty"`
+}
+
+// StatusIDs returns the IDs of all the item's statuses, the first status
+// first and then any follow-ups
+func (p PostedItem) StatusIDs() []string {
+	return append([]string{p.StatusID}, p.ThreadIDs...)
 }
 
 // LastUpdates contains the last update time for each feed
@@ -399,7 +399,7 @@ func (e *EventPramsConfig) InstallLambdaFunction(input *InstallLambdaFunctionInp
 
 	policy, err := e.iam.CreatePolicy(context.TODO(), &iam.CreatePolicyInput{
 		Description:    aws.String("Policy for mastopost lambda function: " + *input.FunctionName),
-		PolicyDocument: aws.String(`{"Version": "2012-10-17","Statement": [{"Action": ["ssm:GetParameter","ssm:GetParameters","ssm:GetParametersByPath","ssm:PutParameter"],"Resource": "arn:aws:ssm:us-east-1:150319663043:parameter/mastopost/*","Effect": "Allow"}]}`),
+		PolicyDocument: aws.String(`{"Version": "2012-10-17","Statement": [{"Action": ["ssm:GetParameter","ssm:GetParameters","ssm:GetParametersByPath","ssm:PutParameter","ssm:DeleteParameters"],"Resource": "arn:aws:ssm:us-east-1:150319663043:parameter/mastopost/*","Effect": "Allow"}]}`),
 		PolicyName:     aws.String("policy-mastopost-lambda-" + *input.FunctionName),
 	})
 	if err != nil {
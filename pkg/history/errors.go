@@ -0,0 +1,80 @@
+package history
+
+// FilenameRequired is returned when a history store has nowhere to keep the history
+type FilenameRequired struct {
+	Err error
+}
+
+// Error returns the error message
+func (e *FilenameRequired) Error() string {
+	if e.Err == nil {
+		return "history filename required"
+	}
+	return e.Err.Error()
+}
+
+// ReadError is returned when the history can't be read
+type ReadError struct {
+	Err      error
+	Msg      string
+	Filename string
+}
+
+// Error returns the error message
+func (e *ReadError) Error() string {
+	msg := e.Msg
+	if msg == "" {
+		msg = "error reading history"
+	}
+	if e.Filename != "" {
+		msg += ": " + e.Filename
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+// WriteError is returned when the history can't be written
+type WriteError struct {
+	Err      error
+	Msg      string
+	Filename string
+}
+
+// Error returns the error message
+func (e *WriteError) Error() string {
+	msg := e.Msg
+	if msg == "" {
+		msg = "error writing history"
+	}
+	if e.Filename != "" {
+		msg += ": " + e.Filename
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+// FeedNotInConfig is returned when a feed is not in the config
+type FeedNotInConfig struct {
+	Err      error
+	Msg      string
+	feedname string
+}
+
+// Error returns the error message
+func (e *FeedNotInConfig) Error() string {
+	msg := e.Msg
+	if msg == "" {
+		msg = "feed not in config"
+	}
+	if e.feedname != "" {
+		msg += ": " + e.feedname
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
@@ -0,0 +1,101 @@
+package history
+
+import (
+	"github.com/iancoleman/strcase"
+	"github.com/rmrfslashbin/mastopost/pkg/config"
+	"github.com/rmrfslashbin/mastopost/pkg/ssmparams"
+	"github.com/rs/zerolog"
+)
+
+// Feed is a feed's config, state and history, wherever they're kept
+type Feed struct {
+	// Name is the feed's name, or its Lambda function job's name
+	Name string
+
+	// Config is the feed's config
+	Config *config.FeedConfig
+
+	// State is the feed's state, saved by Save
+	State *config.FeedLastUpdate
+
+	// Store is the feed's history
+	Store Store
+
+	save func() error
+}
+
+// Save writes the feed's state back to where it was read from
+func (f *Feed) Save() error {
+	return f.save()
+}
+
+// LoadFeed reads a feed's config and state from the config file, with the
+// history in the file the config names, or else in historyFile
+func LoadFeed(configFile string, feedName string, historyFile string) (*Feed, error) {
+	cfg, err := config.NewConfig(configFile)
+	if err != nil {
+		return nil, err
+	}
+
+	// Ensure the feed is in the config
+	feedConfig, ok := cfg.Feeds[feedName]
+	if !ok {
+		return nil, &FeedNotInConfig{feedname: feedName}
+	}
+
+	lastUpdateConfig, err := config.NewLastUpdates(feedConfig.LastUpdateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.HistoryFile != "" {
+		historyFile = cfg.HistoryFile
+	}
+	store, err := NewFile(historyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Feed{
+		Name:   feedName,
+		Config: &feedConfig,
+		State:  &lastUpdateConfig.FeedLastUpdate,
+		Store:  store,
+		save:   lastUpdateConfig.Save,
+	}, nil
+}
+
+// LoadSSMFeed reads the config, state and history of a feed's Lambda
+// function job from SSM
+func LoadSSMFeed(log *zerolog.Logger, awsprofile string, awsregion string, feedName string) (*Feed, error) {
+	// The Lambda jobs are named in CamelCase
+	name := strcase.ToCamel(feedName)
+	params, err := ssmparams.New(
+		ssmparams.WithLogger(log),
+		ssmparams.WithProfile(awsprofile),
+		ssmparams.WithRegion(awsregion),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	path := "/mastopost/" + name + "/"
+	feedConfig, state, err := params.LoadFeed(path)
+	if err != nil {
+		return nil, err
+	}
+	store, err := NewSSM(params, path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Feed{
+		Name:   name,
+		Config: feedConfig,
+		State:  state,
+		Store:  store,
+		save: func() error {
+			return params.SaveState(path, state)
+		},
+	}, nil
+}
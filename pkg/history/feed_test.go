@@ -0,0 +1,91 @@
+package history
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rmrfslashbin/mastopost/pkg/config"
+)
+
+// writeConfig writes a config file with one feed, and the history file if
+// one is given
+func writeConfig(t *testing.T, dir string, historyFile string) string {
+	t.Helper()
+	cfg := config.Config{
+		Feeds: map[string]config.FeedConfig{"test": {
+			Instance:       "https://example.com",
+			LastUpdateFile: filepath.Join(dir, "test.gob"),
+		}},
+		HistoryFile: historyFile,
+	}
+	b, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	configFile := filepath.Join(dir, "config.json")
+	if err := os.WriteFile(configFile, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return configFile
+}
+
+func TestLoadFeed(t *testing.T) {
+	dir := t.TempDir()
+	configFile := writeConfig(t, dir, "")
+	fallback := filepath.Join(dir, "fallback.jsonl")
+
+	feed, err := LoadFeed(configFile, "test", fallback)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if feed.Name != "test" || feed.Config.Instance != "https://example.com" {
+		t.Errorf("loaded %s for %s, want test", feed.Name, feed.Config.Instance)
+	}
+	if store, ok := feed.Store.(*File); !ok || store.filename != fallback {
+		t.Errorf("history kept in %+v, want %s", feed.Store, fallback)
+	}
+
+	// The state is saved where it was read from
+	now := time.Now().UTC().Truncate(time.Second)
+	feed.State.LastUpdated = &now
+	feed.State.LastPublished = &now
+	if err := feed.Save(); err != nil {
+		t.Fatal(err)
+	}
+	feed, err = LoadFeed(configFile, "test", fallback)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if feed.State.LastUpdated == nil || !feed.State.LastUpdated.Equal(now) {
+		t.Errorf("state saved as %v, want %v", feed.State.LastUpdated, now)
+	}
+}
+
+func TestLoadFeedHistoryFile(t *testing.T) {
+	dir := t.TempDir()
+	historyFile := filepath.Join(dir, "history.jsonl")
+	configFile := writeConfig(t, dir, historyFile)
+
+	feed, err := LoadFeed(configFile, "test", filepath.Join(dir, "fallback.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if store, ok := feed.Store.(*File); !ok || store.filename != historyFile {
+		t.Errorf("history kept in %+v, want the config's %s", feed.Store, historyFile)
+	}
+}
+
+func TestLoadFeedNotInConfig(t *testing.T) {
+	configFile := writeConfig(t, t.TempDir(), "")
+
+	_, err := LoadFeed(configFile, "other", "")
+	if _, ok := err.(*FeedNotInConfig); !ok {
+		t.Fatalf("LoadFeed returned %v, want *FeedNotInConfig", err)
+	}
+	if err.Error() != "feed not in config: other" {
+		t.Errorf("message is %q", err.Error())
+	}
+}
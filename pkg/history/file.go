@@ -0,0 +1,78 @@
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+// File keeps the history in a file, one JSON record per line
+type File struct {
+	filename string
+}
+
+// NewFile creates a history store in the named file
+func NewFile(filename string) (*File, error) {
+	if filename == "" {
+		return nil, &FilenameRequired{}
+	}
+	return &File{filename: filename}, nil
+}
+
+// Add appends records to the history file, creating it if need be
+func (f *File) Add(records []Record) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(f.filename), 0o755); err != nil {
+		return &WriteError{Err: err, Filename: f.filename}
+	}
+
+	file, err := os.OpenFile(f.filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return &WriteError{Err: err, Filename: f.filename}
+	}
+	defer file.Close()
+
+	enc := json.NewEncoder(file)
+	for _, record := range records {
+		if err := enc.Encode(record); err != nil {
+			return &WriteError{Err: err, Filename: f.filename}
+		}
+	}
+	return nil
+}
+
+// Query returns the records in the history file matching the query, oldest
+// first. A missing file is an empty history.
+func (f *File) Query(q *Query) ([]Record, error) {
+	file, err := os.Open(f.filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, &ReadError{Err: err, Filename: f.filename}
+	}
+	defer file.Close()
+
+	var records []Record
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, &ReadError{Err: err, Filename: f.filename}
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, &ReadError{Err: err, Filename: f.filename}
+	}
+
+	return filter(records, q), nil
+}
@@ -0,0 +1,44 @@
+package history
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestFile(t *testing.T) {
+	store, err := NewFile(filepath.Join(t.TempDir(), "history", "history.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A missing file is an empty history
+	records, err := store.Query(nil)
+	if err != nil || len(records) != 0 {
+		t.Fatalf("Query() on a new file = %v, %v", records, err)
+	}
+
+	all := testRecords()
+	if err := store.Add(all[:2]); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Add(all[2:]); err != nil {
+		t.Fatal(err)
+	}
+	records, err = store.Query(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != len(all) {
+		t.Fatalf("read %d records, want %d", len(records), len(all))
+	}
+	for i := range all {
+		if records[i].RunID != all[i].RunID || records[i].Title != all[i].Title || !records[i].Time.Equal(all[i].Time) {
+			t.Errorf("record %d is %+v, want %+v", i, records[i], all[i])
+		}
+	}
+
+	if _, err := NewFile(""); !errors.As(err, new(*FilenameRequired)) {
+		t.Errorf("NewFile(\"\") returned %v, want *FilenameRequired", err)
+	}
+}
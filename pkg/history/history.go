@@ -0,0 +1,214 @@
+package history
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	// OUTCOME_POSTED is recorded for an item posted to Mastodon
+	OUTCOME_POSTED = "posted"
+
+	// OUTCOME_SCHEDULED is recorded for an item scheduled to be published
+	// by the instance later
+	OUTCOME_SCHEDULED = "scheduled"
+
+	// OUTCOME_FAILED is recorded for an item that couldn't be posted
+	OUTCOME_FAILED = "failed"
+
+	// OUTCOME_EDITED is recorded for a post edited after its item changed
+	OUTCOME_EDITED = "edited"
+
+	// OUTCOME_DELETED is recorded for a post deleted after its item was
+	// removed from the feed
+	OUTCOME_DELETED = "deleted"
+
+	// OUTCOME_EXPIRED is recorded for a post deleted by prune
+	OUTCOME_EXPIRED = "expired"
+
+	// OUTCOME_UNDONE is recorded for a post deleted by undo
+	OUTCOME_UNDONE = "undone"
+
+	// RUN_ID_FORMAT is the time format run IDs are made from
+	RUN_ID_FORMAT = "20060102T150405Z"
+)
+
+// Record is an entry in the history of what was posted
+type Record struct {
+	// RunID identifies the run that made the record
+	RunID string `json:"run_id"`
+
+	// FeedName is the name of the feed in the config
+	FeedName string `json:"feed_name"`
+
+	// ItemID is the item's GUID, or a hash of its link and title
+	ItemID string `json:"item_id,omitempty"`
+
+	// Link is the item's link
+	Link string `json:"link,omitempty"`
+
+	// Title is the item's title
+	Title string `json:"title,omitempty"`
+
+	// StatusID is the ID of the item's status
+	StatusID string `json:"status_id,omitempty"`
+
+	// ThreadIDs are the IDs of the follow-up statuses if the item was
+	// posted as a thread
+	ThreadIDs []string `json:"thread_ids,omitempty"`
+
+	// ScheduledID is the ID of the item's scheduled status
+	ScheduledID string `json:"scheduled_id,omitempty"`
+
+	// ScheduledAt is when the item's scheduled status is published
+	ScheduledAt *time.Time `json:"scheduled_at,omitempty"`
+
+	// StatusURL is the web address of the item's status
+	StatusURL string `json:"status_url,omitempty"`
+
+	// Instance is the URL of the Mastodon instance
+	Instance string `json:"instance"`
+
+	// Published is when the item was published
+	Published *time.Time `json:"published,omitempty"`
+
+	// Time is when the record was made
+	Time time.Time `json:"time"`
+
+	// Outcome is what happened: OUTCOME_POSTED, OUTCOME_FAILED, ...
+	Outcome string `json:"outcome"`
+
+	// Error is why the item couldn't be posted
+	Error string `json:"error,omitempty"`
+
+	// PrevLastUpdated is the feed's last updated time before the run that
+	// posted the item, used to rewind the feed's state on undo
+	PrevLastUpdated *time.Time `json:"prev_lastupdated,omitempty"`
+
+	// PrevLastPublished is the feed's last published time before the run
+	// that posted the item, used to rewind the feed's state on undo
+	PrevLastPublished *time.Time `json:"prev_lastpublished,omitempty"`
+}
+
+// Query selects records from a Store. Unset fields match every record.
+type Query struct {
+	// FeedName matches records for the feed
+	FeedName string
+
+	// RunID matches records made by the run
+	RunID string
+
+	// Since matches records made at or after the time
+	Since *time.Time
+
+	// Until matches records made before the time
+	Until *time.Time
+
+	// Search matches records with the text in their title or link, ignoring case
+	Search string
+
+	// Limit keeps only the newest records. Zero keeps them all.
+	Limit int
+}
+
+// Store keeps the history
+type Store interface {
+	// Add appends records to the history
+	Add(records []Record) error
+
+	// Query returns the records matching the query, oldest first
+	Query(q *Query) ([]Record, error)
+}
+
+// NewRunID returns an ID for a run starting at the given time
+func NewRunID(t time.Time) string {
+	return t.UTC().Format(RUN_ID_FORMAT)
+}
+
+// ParseTime reads a time given as RFC 3339 or as a date such as 2022-12-31
+func ParseTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q. use RFC 3339 or YYYY-MM-DD", s)
+	}
+	return t, nil
+}
+
+// Matches reports whether the record matches the query
+func (q *Query) Matches(r *Record) bool {
+	if q.FeedName != "" && r.FeedName != q.FeedName {
+		return false
+	}
+	if q.RunID != "" && r.RunID != q.RunID {
+		return false
+	}
+	if q.Since != nil && r.Time.Before(*q.Since) {
+		return false
+	}
+	if q.Until != nil && !r.Time.Before(*q.Until) {
+		return false
+	}
+	if q.Search != "" {
+		search := strings.ToLower(q.Search)
+		if !strings.Contains(strings.ToLower(r.Title), search) && !strings.Contains(strings.ToLower(r.Link), search) {
+			return false
+		}
+	}
+	return true
+}
+
+// filter returns the records matching the query, keeping the newest q.Limit
+func filter(records []Record, q *Query) []Record {
+	var matched []Record
+	for i := range records {
+		if q == nil || q.Matches(&records[i]) {
+			matched = append(matched, records[i])
+		}
+	}
+	if q != nil && q.Limit > 0 && len(matched) > q.Limit {
+		matched = matched[len(matched)-q.Limit:]
+	}
+	return matched
+}
+
+// WriteJSON writes the records as a JSON array
+func WriteJSON(w io.Writer, records []Record) error {
+	if records == nil {
+		records = []Record{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(records)
+}
+
+// WriteTable writes the records as a table
+func WriteTable(w io.Writer, records []Record) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tRUN\tFEED\tOUTCOME\tSTATUS\tTITLE")
+	for _, r := range records {
+		status := r.StatusURL
+		if status == "" {
+			status = r.StatusID
+		}
+		if status == "" && r.ScheduledAt != nil {
+			status = "at " + r.ScheduledAt.Local().Format("2006-01-02 15:04")
+		}
+		title := r.Title
+		if title == "" {
+			title = r.Link
+		}
+		if r.Error != "" {
+			title += " (" + r.Error + ")"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			r.Time.Local().Format("2006-01-02 15:04:05"), r.RunID, r.FeedName, r.Outcome, status, title)
+	}
+	return tw.Flush()
+}
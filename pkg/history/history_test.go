@@ -0,0 +1,97 @@
+package history
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testRecords returns records from two runs of two feeds
+func testRecords() []Record {
+	base := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	return []Record{
+		{RunID: "run1", FeedName: "news", Title: "Budget day", Link: "https://example.com/budget", Time: base, Outcome: OUTCOME_POSTED, StatusID: "1"},
+		{RunID: "run1", FeedName: "news", Title: "Weather", Link: "https://example.com/weather", Time: base.Add(time.Minute), Outcome: OUTCOME_FAILED, Error: "rate limited"},
+		{RunID: "run2", FeedName: "sport", Title: "Cup final", Link: "https://example.com/cup", Time: base.Add(time.Hour), Outcome: OUTCOME_POSTED, StatusID: "2"},
+		{RunID: "run3", FeedName: "news", Title: "Budget reaction", Link: "https://example.com/reaction", Time: base.Add(2 * time.Hour), Outcome: OUTCOME_POSTED, StatusID: "3"},
+	}
+}
+
+func TestQuery(t *testing.T) {
+	base := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	since := base.Add(30 * time.Minute)
+	until := base.Add(90 * time.Minute)
+
+	tests := []struct {
+		name  string
+		query *Query
+		want  []string
+	}{
+		{"all", &Query{}, []string{"Budget day", "Weather", "Cup final", "Budget reaction"}},
+		{"feed", &Query{FeedName: "news"}, []string{"Budget day", "Weather", "Budget reaction"}},
+		{"run", &Query{RunID: "run1"}, []string{"Budget day", "Weather"}},
+		{"since", &Query{Since: &since}, []string{"Cup final", "Budget reaction"}},
+		{"until", &Query{Until: &until}, []string{"Budget day", "Weather", "Cup final"}},
+		{"search", &Query{Search: "BUDGET"}, []string{"Budget day", "Budget reaction"}},
+		{"search link", &Query{Search: "/cup"}, []string{"Cup final"}},
+		{"limit", &Query{FeedName: "news", Limit: 2}, []string{"Weather", "Budget reaction"}},
+	}
+	for _, tt := range tests {
+		var titles []string
+		for _, record := range filter(testRecords(), tt.query) {
+			titles = append(titles, record.Title)
+		}
+		if strings.Join(titles, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("%s: got %v, want %v", tt.name, titles, tt.want)
+		}
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	tests := []struct {
+		s    string
+		want time.Time
+	}{
+		{"2024-01-02", time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{"2024-01-02T15:04:05Z", time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		got, err := ParseTime(tt.s)
+		if err != nil || !got.Equal(tt.want) {
+			t.Errorf("ParseTime(%q) = %v, %v; want %v", tt.s, got, err, tt.want)
+		}
+	}
+	if _, err := ParseTime("yesterday"); err == nil {
+		t.Error("ParseTime accepted \"yesterday\"")
+	}
+}
+
+func TestWrite(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteJSON(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(buf.String()) != "[]" {
+		t.Errorf("WriteJSON(nil) = %q, want []", buf.String())
+	}
+
+	buf.Reset()
+	if err := WriteTable(&buf, testRecords()); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 5 {
+		t.Fatalf("table has %d lines, want a header and 4 records", len(lines))
+	}
+	if !strings.Contains(lines[2], "Weather (rate limited)") {
+		t.Errorf("failed record shown as %q", lines[2])
+	}
+}
+
+func TestNewRunID(t *testing.T) {
+	at := time.Date(2024, 1, 2, 15, 4, 5, 0, time.FixedZone("EST", -5*60*60))
+	if got := NewRunID(at); got != "20240102T200405Z" {
+		t.Errorf("NewRunID() = %q", got)
+	}
+}
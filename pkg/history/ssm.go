@@ -0,0 +1,88 @@
+package history
+
+import (
+	"encoding/json"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/rmrfslashbin/mastopost/pkg/ssmparams"
+)
+
+// SSM keeps a feed's recent history in an SSM parameter, for the Lambda
+// function. The oldest records are dropped to fit the parameter.
+type SSM struct {
+	params *ssmparams.SSMParamsConfig
+	name   string
+}
+
+// NewSSM creates a history store in the runtime/history parameter under
+// the feed's parameter path, e.g. /mastopost/feedname/
+func NewSSM(params *ssmparams.SSMParamsConfig, path string) (*SSM, error) {
+	if params == nil || path == "" {
+		return nil, &FilenameRequired{}
+	}
+	return &SSM{params: params, name: path + "runtime/history"}, nil
+}
+
+// Add appends records to the history parameter
+func (s *SSM) Add(records []Record) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	existing, err := s.load()
+	if err != nil {
+		return err
+	}
+	all := append(existing, records...)
+
+	for {
+		value, err := json.Marshal(all)
+		if err != nil {
+			return &WriteError{Err: err, Filename: s.name}
+		}
+		if len(value) <= ssmparams.MAX_PARAM_SIZE || len(all) <= 1 {
+			if _, err := s.params.PutParam(&ssm.PutParameterInput{
+				Name:      aws.String(s.name),
+				Value:     aws.String(string(value)),
+				Type:      types.ParameterTypeString,
+				Tier:      types.ParameterTierIntelligentTiering,
+				Overwrite: aws.Bool(true),
+			}); err != nil {
+				return &WriteError{Err: err, Filename: s.name}
+			}
+			return nil
+		}
+		all = all[1:]
+	}
+}
+
+// Query returns the records in the history parameter matching the query,
+// oldest first
+func (s *SSM) Query(q *Query) ([]Record, error) {
+	records, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	return filter(records, q), nil
+}
+
+// load reads the records in the history parameter. A missing parameter is
+// an empty history.
+func (s *SSM) load() ([]Record, error) {
+	out, err := s.params.GetParams([]string{s.name})
+	if err != nil {
+		return nil, &ReadError{Err: err, Filename: s.name}
+	}
+	value, ok := out.Params[s.name].(string)
+	if !ok {
+		return nil, nil
+	}
+
+	var records []Record
+	if err := json.Unmarshal([]byte(value), &records); err != nil {
+		return nil, &ReadError{Err: err, Filename: s.name}
+	}
+	return records, nil
+}
@@ -0,0 +1,103 @@
+package mastoclient
+
+import (
+	"net/http"
+)
+
+const (
+	// DEFAULT_MAX_CHARACTERS is Mastodon's stock status length limit
+	DEFAULT_MAX_CHARACTERS = 500
+
+	// DEFAULT_CHARACTERS_PER_URL is how many characters Mastodon counts for any URL
+	DEFAULT_CHARACTERS_PER_URL = 23
+
+	// DEFAULT_MAX_ATTACHMENTS is how many media attachments Mastodon allows per status
+	DEFAULT_MAX_ATTACHMENTS = 4
+
+	// DEFAULT_IMAGE_SIZE_LIMIT is the largest image Mastodon accepts, in bytes
+	DEFAULT_IMAGE_SIZE_LIMIT = 10 * 1024 * 1024
+)
+
+// DEFAULT_IMAGE_TYPES are the image types every Mastodon instance accepts
+var DEFAULT_IMAGE_TYPES = []string{"image/jpeg", "image/png", "image/gif", "image/webp"}
+
+// InstanceLimits are the limits an instance places on new statuses
+type InstanceLimits struct {
+	// MaxCharacters is the longest status the instance accepts
+	MaxCharacters int
+
+	// CharactersPerURL is how many characters each URL counts as
+	CharactersPerURL int
+
+	// MaxAttachments is how many media attachments a status can have
+	MaxAttachments int
+
+	// ImageSizeLimit is the largest image the instance accepts, in bytes
+	ImageSizeLimit int64
+
+	// MediaTypes are the MIME types the instance accepts for media
+	MediaTypes []string
+}
+
+// instanceInfo is the part of /api/v1/instance we care about
+type instanceInfo struct {
+	// Mastodon 3.5+
+	Configuration struct {
+		Statuses struct {
+			MaxCharacters            int `json:"max_characters"`
+			CharactersReservedPerURL int `json:"characters_reserved_per_url"`
+			MaxMediaAttachments      int `json:"max_media_attachments"`
+		} `json:"statuses"`
+		MediaAttachments struct {
+			SupportedMimeTypes []string `json:"supported_mime_types"`
+			ImageSizeLimit     int64    `json:"image_size_limit"`
+		} `json:"media_attachments"`
+	} `json:"configuration"`
+
+	// Pleroma and Akkoma
+	MaxTootChars int `json:"max_toot_chars"`
+}
+
+// GetLimits fetches the instance's status limits. Anything the instance
+// doesn't report falls back to Mastodon's defaults.
+func (c *Config) GetLimits() (*InstanceLimits, error) {
+	limits := &InstanceLimits{
+		MaxCharacters:    DEFAULT_MAX_CHARACTERS,
+		CharactersPerURL: DEFAULT_CHARACTERS_PER_URL,
+		MaxAttachments:   DEFAULT_MAX_ATTACHMENTS,
+		ImageSizeLimit:   DEFAULT_IMAGE_SIZE_LIMIT,
+		MediaTypes:       DEFAULT_IMAGE_TYPES,
+	}
+
+	info := &instanceInfo{}
+	if err := c.doAPI(http.MethodGet, "/api/v1/instance", nil, info); err != nil {
+		return limits, err
+	}
+
+	if max := info.Configuration.Statuses.MaxCharacters; max > 0 {
+		limits.MaxCharacters = max
+	} else if info.MaxTootChars > 0 {
+		limits.MaxCharacters = info.MaxTootChars
+	}
+	if perURL := info.Configuration.Statuses.CharactersReservedPerURL; perURL > 0 {
+		limits.CharactersPerURL = perURL
+	}
+	if max := info.Configuration.Statuses.MaxMediaAttachments; max > 0 {
+		limits.MaxAttachments = max
+	}
+	if size := info.Configuration.MediaAttachments.ImageSizeLimit; size > 0 {
+		limits.ImageSizeLimit = size
+	}
+	if types := info.Configuration.MediaAttachments.SupportedMimeTypes; len(types) > 0 {
+		limits.MediaTypes = types
+	}
+
+	c.log.Debug().
+		Int("maxCharacters", limits.MaxCharacters).
+		Int("charactersPerURL", limits.CharactersPerURL).
+		Int("maxAttachments", limits.MaxAttachments).
+		Int64("imageSizeLimit", limits.ImageSizeLimit).
+		Msg("instance limits")
+
+	return limits, nil
+}
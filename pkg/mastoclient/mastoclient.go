@@ -2,8 +2,15 @@ package mastoclient
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
 	"net/url"
+	"os"
+	"strings"
+	"time"
 
 	"github.com/mattn/go-mastodon"
 	"github.com/rs/zerolog"
@@ -93,16 +100,150 @@ func (e *PostFailed) Error() string {
 	return e.Msg
 }
 
+// EditFailed is returned when a status can't be edited
+type EditFailed struct {
+	Err error
+	Msg string
+	ID  string
+}
+
+// Error returns the error message
+func (e *EditFailed) Error() string {
+	msg := e.Msg
+	if msg == "" {
+		msg = "edit failed"
+	}
+	if e.ID != "" {
+		msg += ": status " + e.ID
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+// DeleteFailed is returned when a status can't be deleted
+type DeleteFailed struct {
+	Err error
+	Msg string
+	ID  string
+}
+
+// Error returns the error message
+func (e *DeleteFailed) Error() string {
+	msg := e.Msg
+	if msg == "" {
+		msg = "delete failed"
+	}
+	if e.ID != "" {
+		msg += ": status " + e.ID
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+// Unwrap returns the underlying error
+func (e *DeleteFailed) Unwrap() error {
+	return e.Err
+}
+
+// CancelFailed is returned when a scheduled status can't be cancelled
+type CancelFailed struct {
+	Err error
+	Msg string
+	ID  string
+}
+
+// Error returns the error message
+func (e *CancelFailed) Error() string {
+	msg := e.Msg
+	if msg == "" {
+		msg = "cancel failed"
+	}
+	if e.ID != "" {
+		msg += ": scheduled status " + e.ID
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+// Unwrap returns the underlying error
+func (e *CancelFailed) Unwrap() error {
+	return e.Err
+}
+
+// UploadFailed is returned when a media attachment can't be uploaded
+type UploadFailed struct {
+	Err      error
+	Msg      string
+	Filename string
+}
+
+// Error returns the error message
+func (e *UploadFailed) Error() string {
+	msg := e.Msg
+	if msg == "" {
+		msg = "media upload failed"
+	}
+	if e.Filename != "" {
+		msg += ": " + e.Filename
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+// APIError is returned when a call to the Mastodon API fails
+type APIError struct {
+	Err        error
+	Msg        string
+	Path       string
+	StatusCode int
+}
+
+// Error returns the error message
+func (e *APIError) Error() string {
+	msg := e.Msg
+	if msg == "" {
+		msg = "mastodon API call failed"
+	}
+	if e.Path != "" {
+		msg += " (" + e.Path + ")"
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+// IsNotFound reports whether err is an API call failing because what it
+// refers to, such as a status, doesn't exist
+func IsNotFound(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+// DEFAULT_TIMEOUT is how long a request to the instance may take, including
+// reading the response, unless WithHTTPClient sets another client
+const DEFAULT_TIMEOUT = 60 * time.Second
+
 // Options for the weather query
 type Option func(c *Config)
 
 // Config for the weather query
 type Config struct {
-	log       *zerolog.Logger
-	instance  *url.URL
-	clientid  string
-	clientsec string
-	token     string
+	log        *zerolog.Logger
+	instance   *url.URL
+	clientid   string
+	clientsec  string
+	token      string
+	accountID  mastodon.ID
+	httpClient *http.Client
 }
 
 // NewConfig creates a new Config
@@ -114,6 +255,17 @@ func New(opts ...Option) (*Config, error) {
 		opt(c)
 	}
 
+	// Set up the default logger if not set
+	if c.log == nil {
+		log := zerolog.New(os.Stderr).With().Timestamp().Logger()
+		c.log = &log
+	}
+
+	// Set up the default HTTP client if not set
+	if c.httpClient == nil {
+		c.httpClient = &http.Client{Timeout: DEFAULT_TIMEOUT}
+	}
+
 	return c, nil
 }
 
@@ -152,7 +304,15 @@ func WithLogger(log *zerolog.Logger) Option {
 	}
 }
 
-func (c *Config) Post(toot *mastodon.Toot) (*mastodon.ID, error) {
+// WithHTTPClient sets the HTTP client used to call the instance
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Config) {
+		c.httpClient = httpClient
+	}
+}
+
+// client checks the set up and returns a Mastodon client
+func (c *Config) client() (*mastodon.Client, error) {
 	// Check set up
 	if c.instance == nil {
 		return nil, &NoInstance{}
@@ -177,12 +337,90 @@ func (c *Config) Post(toot *mastodon.Toot) (*mastodon.ID, error) {
 		ClientSecret: c.clientsec,
 		AccessToken:  c.token,
 	})
+	client.Client = *c.httpClient
+	return client, nil
+}
+
+// doAPI calls an API endpoint go-mastodon doesn't cover, decoding the JSON response into res
+func (c *Config) doAPI(method string, path string, params url.Values, res interface{}) error {
+	var body io.Reader
+	contentType := ""
+	if params != nil && method != http.MethodGet {
+		body = strings.NewReader(params.Encode())
+		contentType = "application/x-www-form-urlencoded"
+	}
+	return c.send(method, path, params, body, contentType, res)
+}
+
+// send makes an authenticated request to the instance, decoding the JSON response into res
+func (c *Config) send(method string, path string, query url.Values, body io.Reader, contentType string, res interface{}) error {
+	if _, err := c.client(); err != nil {
+		return err
+	}
+
+	u := *c.instance
+	u.Path = strings.TrimSuffix(u.Path, "/") + path
+	if query != nil && method == http.MethodGet {
+		u.RawQuery = query.Encode()
+	}
+
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return &APIError{Err: err, Path: path}
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return &APIError{Err: err, Path: path}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return &APIError{Err: fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg))), Path: path, StatusCode: resp.StatusCode}
+	}
+
+	if res == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(res); err != nil {
+		return &APIError{Err: err, Path: path, StatusCode: resp.StatusCode}
+	}
+	return nil
+}
+
+// Post posts a status and returns it
+func (c *Config) Post(toot *mastodon.Toot) (*mastodon.Status, error) {
+	client, err := c.client()
+	if err != nil {
+		return nil, err
+	}
 
 	// Post the toot
 	if status, err := client.PostStatus(context.Background(), toot); err != nil {
-		fmt.Println(err)
-		return nil, err
+		return nil, &PostFailed{Err: err}
 	} else {
-		return &status.ID, nil
+		return status, nil
+	}
+}
+
+// PostThread posts the toots as a thread, each one a reply to the one before.
+// On error it returns the statuses posted so far.
+func (c *Config) PostThread(toots []*mastodon.Toot) ([]*mastodon.Status, error) {
+	var statuses []*mastodon.Status
+	for _, toot := range toots {
+		if len(statuses) > 0 {
+			toot.InReplyToID = statuses[len(statuses)-1].ID
+		}
+		status, err := c.Post(toot)
+		if err != nil {
+			return statuses, err
+		}
+		statuses = append(statuses, status)
 	}
+	return statuses, nil
 }
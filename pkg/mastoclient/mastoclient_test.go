@@ -0,0 +1,123 @@
+package mastoclient
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// newTestClient returns a client for the instance at the server's URL
+func newTestClient(t *testing.T, server *httptest.Server, opts ...Option) *Config {
+	t.Helper()
+	instance, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := zerolog.Nop()
+	c, err := New(append([]Option{
+		WithLogger(&log),
+		WithInstance(instance),
+		WithClientID("client"),
+		WithClientSecret("secret"),
+		WithToken("token"),
+	}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestGetLimits(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want InstanceLimits
+	}{
+		{
+			name: "mastodon",
+			body: `{"configuration":{"statuses":{"max_characters":1000,"characters_reserved_per_url":30,"max_media_attachments":8}}}`,
+			want: InstanceLimits{MaxCharacters: 1000, CharactersPerURL: 30, MaxAttachments: 8},
+		},
+		{
+			name: "pleroma",
+			body: `{"max_toot_chars":5000}`,
+			want: InstanceLimits{MaxCharacters: 5000, CharactersPerURL: DEFAULT_CHARACTERS_PER_URL, MaxAttachments: DEFAULT_MAX_ATTACHMENTS},
+		},
+		{
+			name: "old",
+			body: `{}`,
+			want: InstanceLimits{MaxCharacters: DEFAULT_MAX_CHARACTERS, CharactersPerURL: DEFAULT_CHARACTERS_PER_URL, MaxAttachments: DEFAULT_MAX_ATTACHMENTS},
+		},
+	}
+	for _, tt := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/api/v1/instance" {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write([]byte(tt.body))
+		}))
+
+		limits, err := newTestClient(t, server).GetLimits()
+		server.Close()
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if limits.MaxCharacters != tt.want.MaxCharacters || limits.CharactersPerURL != tt.want.CharactersPerURL || limits.MaxAttachments != tt.want.MaxAttachments {
+			t.Errorf("%s: got %+v, want %+v", tt.name, *limits, tt.want)
+		}
+	}
+}
+
+func TestGetLimitsFallback(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down for maintenance", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	limits, err := newTestClient(t, server).GetLimits()
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("GetLimits returned %v, want *APIError", err)
+	}
+	if limits.MaxCharacters != DEFAULT_MAX_CHARACTERS {
+		t.Errorf("MaxCharacters = %d, want the default", limits.MaxCharacters)
+	}
+}
+
+func TestHTTPClientTimeout(t *testing.T) {
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer server.Close()
+	defer close(done)
+
+	c := newTestClient(t, server, WithHTTPClient(&http.Client{Timeout: 50 * time.Millisecond}))
+	start := time.Now()
+	if _, err := c.GetLimits(); err == nil {
+		t.Fatal("GetLimits didn't time out")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("GetLimits took %v", elapsed)
+	}
+}
+
+func TestDefaultHTTPClient(t *testing.T) {
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.httpClient == nil || c.httpClient.Timeout != DEFAULT_TIMEOUT {
+		t.Errorf("default HTTP client has no %v timeout", DEFAULT_TIMEOUT)
+	}
+}
@@ -0,0 +1,78 @@
+package mastoclient
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+
+	"github.com/mattn/go-mastodon"
+)
+
+// Media is a file to attach to a status
+type Media struct {
+	// Data is the file's content
+	Data []byte
+
+	// Filename is the name the file is uploaded as
+	Filename string
+
+	// Type is the file's MIME type
+	Type string
+
+	// Description is the alt text for the file
+	Description string
+}
+
+// UploadMedia uploads a media attachment and returns its ID for Toot.MediaIDs
+func (c *Config) UploadMedia(media *Media) (*mastodon.ID, error) {
+	// go-mastodon uploads everything as application/octet-stream with no
+	// file name, so build the form here with the real type
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", `form-data; name="file"; filename="`+escapeQuotes(media.Filename)+`"`)
+	header.Set("Content-Type", media.Type)
+	file, err := form.CreatePart(header)
+	if err != nil {
+		return nil, &UploadFailed{Err: err, Filename: media.Filename}
+	}
+	if _, err := file.Write(media.Data); err != nil {
+		return nil, &UploadFailed{Err: err, Filename: media.Filename}
+	}
+
+	if media.Description != "" {
+		if err := form.WriteField("description", media.Description); err != nil {
+			return nil, &UploadFailed{Err: err, Filename: media.Filename}
+		}
+	}
+	if err := form.Close(); err != nil {
+		return nil, &UploadFailed{Err: err, Filename: media.Filename}
+	}
+
+	attachment := &mastodon.Attachment{}
+	if err := c.send(http.MethodPost, "/api/v1/media", nil, &body, form.FormDataContentType(), attachment); err != nil {
+		return nil, &UploadFailed{Err: err, Filename: media.Filename}
+	}
+
+	c.log.Debug().
+		Str("id", string(attachment.ID)).
+		Str("filename", media.Filename).
+		Str("type", media.Type).
+		Msg("uploaded media")
+
+	return &attachment.ID, nil
+}
+
+// escapeQuotes escapes a file name for a Content-Disposition header
+func escapeQuotes(s string) string {
+	var b bytes.Buffer
+	for _, r := range s {
+		if r == '"' || r == '\\' {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
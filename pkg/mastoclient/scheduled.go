@@ -0,0 +1,160 @@
+package mastoclient
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/mattn/go-mastodon"
+)
+
+// MIN_SCHEDULE_LEAD is how far ahead a status has to be scheduled. Mastodon
+// refuses scheduled statuses due in less than 5 minutes; the extra minute
+// allows for clock skew.
+const MIN_SCHEDULE_LEAD = 6 * time.Minute
+
+// ScheduledStatus is a status waiting to be published by the instance
+type ScheduledStatus struct {
+	// ID is the ID of the scheduled status, not the status it becomes
+	ID mastodon.ID `json:"id"`
+
+	// ScheduledAt is when the status is published
+	ScheduledAt time.Time `json:"scheduled_at"`
+
+	// Params are the parameters the status is posted with
+	Params ScheduledParams `json:"params"`
+
+	// MediaAttachments are the status's media
+	MediaAttachments []mastodon.Attachment `json:"media_attachments"`
+}
+
+// ScheduledParams are the parameters a scheduled status is posted with
+type ScheduledParams struct {
+	Text        string `json:"text"`
+	SpoilerText string `json:"spoiler_text"`
+	Visibility  string `json:"visibility"`
+	Language    string `json:"language"`
+}
+
+// Schedule posts a status to be published by the instance at a later time,
+// at least MIN_SCHEDULE_LEAD from now. The status gets its own ID once it's
+// published; until then it's known by the scheduled status's ID.
+func (c *Config) Schedule(toot *mastodon.Toot, at time.Time) (*ScheduledStatus, error) {
+	params := url.Values{}
+	params.Set("status", toot.Status)
+	params.Set("scheduled_at", at.UTC().Format(time.RFC3339))
+	if toot.SpoilerText != "" {
+		params.Set("spoiler_text", toot.SpoilerText)
+	}
+	if toot.Sensitive {
+		params.Set("sensitive", strconv.FormatBool(toot.Sensitive))
+	}
+	if toot.Visibility != "" {
+		params.Set("visibility", toot.Visibility)
+	}
+	if toot.Language != "" {
+		params.Set("language", toot.Language)
+	}
+	for _, mediaID := range toot.MediaIDs {
+		params.Add("media_ids[]", string(mediaID))
+	}
+
+	scheduled := &ScheduledStatus{}
+	if err := c.doAPI(http.MethodPost, "/api/v1/statuses", params, scheduled); err != nil {
+		return nil, &PostFailed{Err: err}
+	}
+
+	c.log.Debug().
+		Str("id", string(scheduled.ID)).
+		Time("scheduledAt", scheduled.ScheduledAt).
+		Msg("scheduled status")
+
+	return scheduled, nil
+}
+
+// ScheduledStatuses returns the account's scheduled statuses, soonest first
+func (c *Config) ScheduledStatuses() ([]*ScheduledStatus, error) {
+	var scheduled []*ScheduledStatus
+	params := url.Values{}
+	params.Set("limit", "40")
+	for {
+		var page []*ScheduledStatus
+		if err := c.doAPI(http.MethodGet, "/api/v1/scheduled_statuses", params, &page); err != nil {
+			return nil, err
+		}
+		if len(page) == 0 {
+			break
+		}
+		scheduled = append(scheduled, page...)
+		params.Set("max_id", string(page[len(page)-1].ID))
+	}
+
+	sort.SliceStable(scheduled, func(i, j int) bool {
+		return scheduled[i].ScheduledAt.Before(scheduled[j].ScheduledAt)
+	})
+	return scheduled, nil
+}
+
+// CancelScheduled cancels a scheduled status. One that's already published
+// or cancelled fails with an error IsNotFound recognises.
+func (c *Config) CancelScheduled(id mastodon.ID) error {
+	if err := c.doAPI(http.MethodDelete, "/api/v1/scheduled_statuses/"+url.PathEscape(string(id)), nil, nil); err != nil {
+		return &CancelFailed{Err: err, ID: string(id)}
+	}
+
+	c.log.Debug().
+		Str("id", string(id)).
+		Msg("cancelled scheduled status")
+
+	return nil
+}
+
+// PUBLISH_WINDOW is how long after its scheduled time the instance may take
+// to publish a scheduled status
+const PUBLISH_WINDOW = 10 * time.Minute
+
+// PublishedStatus returns the status a scheduled status due at the given time
+// was published as, or nil if there's none, such as when it was deleted.
+// Mastodon doesn't link the two, so it's taken to be the account's first
+// status that isn't a reply or a boost created within PUBLISH_WINDOW of the
+// time, leaving out the statuses in skip. It pages back from the newest
+// status and stops at the time, or after MAX_SCAN_PAGES pages.
+func (c *Config) PublishedStatus(at time.Time, skip map[mastodon.ID]bool) (*mastodon.Status, error) {
+	accountID, err := c.AccountID()
+	if err != nil {
+		return nil, err
+	}
+
+	var published *mastodon.Status
+	params := url.Values{}
+	params.Set("limit", "40")
+	params.Set("exclude_reblogs", "true")
+	params.Set("exclude_replies", "true")
+	for pages := 0; pages < MAX_SCAN_PAGES; pages++ {
+		var page []*mastodon.Status
+		if err := c.doAPI(http.MethodGet, "/api/v1/accounts/"+url.PathEscape(string(accountID))+"/statuses", params, &page); err != nil {
+			return nil, err
+		}
+		if len(page) == 0 {
+			return published, nil
+		}
+
+		// Pages come newest first, so the last match is the earliest
+		for _, status := range page {
+			if status.CreatedAt.Before(at) {
+				return published, nil
+			}
+			if status.CreatedAt.Sub(at) <= PUBLISH_WINDOW && !skip[status.ID] {
+				published = status
+			}
+		}
+		params.Set("max_id", string(page[len(page)-1].ID))
+	}
+
+	c.log.Debug().
+		Int("pages", MAX_SCAN_PAGES).
+		Msg("stopped scanning statuses at the page limit")
+	return published, nil
+}
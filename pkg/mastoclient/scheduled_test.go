@@ -0,0 +1,194 @@
+package mastoclient
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/mattn/go-mastodon"
+)
+
+// scheduledServer serves count scheduled statuses in pages of 40, newest ID
+// first, each due an hour later than the one before but for the last, which
+// is due soonest. It keeps the form of each status scheduled.
+func scheduledServer(t *testing.T, count int, forms *[]url.Values) *httptest.Server {
+	t.Helper()
+	first := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/api/v1/scheduled_statuses":
+			maxID := count + 1
+			if id := r.URL.Query().Get("max_id"); id != "" {
+				maxID, _ = strconv.Atoi(id)
+			}
+			page := []map[string]interface{}{}
+			for id := maxID - 1; id >= 1 && len(page) < 40; id-- {
+				at := first.Add(time.Duration(id) * time.Hour)
+				if id == count {
+					at = first
+				}
+				page = append(page, map[string]interface{}{"id": fmt.Sprint(id), "scheduled_at": at})
+			}
+			json.NewEncoder(w).Encode(page)
+		case r.Method == http.MethodPost && r.URL.Path == "/api/v1/statuses":
+			if err := r.ParseForm(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			*forms = append(*forms, r.PostForm)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"id":           "100",
+				"scheduled_at": r.PostForm.Get("scheduled_at"),
+				"params":       map[string]interface{}{"text": r.PostForm.Get("status")},
+			})
+		case r.Method == http.MethodDelete && r.URL.Path == "/api/v1/scheduled_statuses/1":
+			w.Write([]byte(`{}`))
+		default:
+			http.Error(w, `{"error":"Record not found"}`, http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestScheduledStatuses(t *testing.T) {
+	var forms []url.Values
+	scheduled, err := newTestClient(t, scheduledServer(t, 90, &forms)).ScheduledStatuses()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scheduled) != 90 {
+		t.Fatalf("got %d scheduled statuses, want all 90", len(scheduled))
+	}
+	if scheduled[0].ID != "90" {
+		t.Errorf("soonest is %s, want 90", scheduled[0].ID)
+	}
+	for i := 1; i < len(scheduled); i++ {
+		if scheduled[i].ScheduledAt.Before(scheduled[i-1].ScheduledAt) {
+			t.Fatalf("%s is due before %s, want soonest first", scheduled[i].ID, scheduled[i-1].ID)
+		}
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	var forms []url.Values
+	client := newTestClient(t, scheduledServer(t, 0, &forms))
+	at := time.Date(2024, 1, 2, 15, 4, 0, 0, time.FixedZone("EST", -5*60*60))
+	scheduled, err := client.Schedule(&mastodon.Toot{
+		Status:     "A post",
+		Visibility: "unlisted",
+		Language:   "en",
+		MediaIDs:   []mastodon.ID{"7", "8"},
+	}, at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scheduled.ID != "100" || !scheduled.ScheduledAt.Equal(at) {
+		t.Errorf("scheduled %s at %v, want 100 at %v", scheduled.ID, scheduled.ScheduledAt, at)
+	}
+	if len(forms) != 1 {
+		t.Fatalf("sent %d statuses, want 1", len(forms))
+	}
+	form := forms[0]
+	if form.Get("scheduled_at") != "2024-01-02T20:04:00Z" {
+		t.Errorf("scheduled_at is %q, want it in UTC", form.Get("scheduled_at"))
+	}
+	if form.Get("status") != "A post" || form.Get("visibility") != "unlisted" || form.Get("language") != "en" {
+		t.Errorf("sent %v", form)
+	}
+	if ids := form["media_ids[]"]; len(ids) != 2 || ids[0] != "7" || ids[1] != "8" {
+		t.Errorf("media IDs are %v, want 7 and 8", ids)
+	}
+	if _, ok := form["spoiler_text"]; ok {
+		t.Error("empty spoiler text sent")
+	}
+}
+
+func TestCancelScheduled(t *testing.T) {
+	var forms []url.Values
+	client := newTestClient(t, scheduledServer(t, 0, &forms))
+	if err := client.CancelScheduled("1"); err != nil {
+		t.Fatal(err)
+	}
+
+	err := client.CancelScheduled("2")
+	var cancelFailed *CancelFailed
+	if !errors.As(err, &cancelFailed) {
+		t.Fatalf("CancelScheduled returned %v, want *CancelFailed", err)
+	}
+	if !IsNotFound(err) {
+		t.Errorf("IsNotFound(%v) is false for a status that's gone", err)
+	}
+}
+
+func TestPublishedStatus(t *testing.T) {
+	// 100 statuses, one a minute, the newest first
+	first := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	pages := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/accounts/verify_credentials":
+			w.Write([]byte(`{"id":"1"}`))
+		case "/api/v1/accounts/1/statuses":
+			pages++
+			maxID := 101
+			if id := r.URL.Query().Get("max_id"); id != "" {
+				maxID, _ = strconv.Atoi(id)
+			}
+			page := []map[string]interface{}{}
+			for id := maxID - 1; id >= 1 && len(page) < 40; id-- {
+				page = append(page, map[string]interface{}{
+					"id":         fmt.Sprint(id),
+					"created_at": first.Add(time.Duration(id-1) * time.Minute),
+				})
+			}
+			json.NewEncoder(w).Encode(page)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	client := newTestClient(t, server)
+
+	tests := []struct {
+		name string
+		at   time.Time
+		skip map[mastodon.ID]bool
+		want mastodon.ID
+	}{
+		{name: "on time", at: first.Add(30 * time.Minute), want: "31"},
+		{name: "late", at: first.Add(30*time.Minute - 30*time.Second), want: "31"},
+		{name: "skipped", at: first.Add(30 * time.Minute), skip: map[mastodon.ID]bool{"31": true, "32": true}, want: "33"},
+		{name: "none", at: first.Add(2 * time.Hour)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, err := client.PublishedStatus(tt.at, tt.skip)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got mastodon.ID
+			if status != nil {
+				got = status.ID
+			}
+			if got != tt.want {
+				t.Errorf("got status %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	// Paging stops once the statuses are older than the time
+	pages = 0
+	if _, err := client.PublishedStatus(first.Add(70*time.Minute), nil); err != nil {
+		t.Fatal(err)
+	}
+	if pages != 1 {
+		t.Errorf("read %d pages, want 1", pages)
+	}
+}
@@ -0,0 +1,162 @@
+package mastoclient
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/mattn/go-mastodon"
+)
+
+// EditStatus replaces the text, content warning and language of a status.
+// If the toot has no media the status keeps the media it has.
+func (c *Config) EditStatus(id mastodon.ID, toot *mastodon.Toot) error {
+	client, err := c.client()
+	if err != nil {
+		return err
+	}
+
+	// Media left out of an edit is removed from the status
+	mediaIDs := toot.MediaIDs
+	if len(mediaIDs) == 0 {
+		status, err := client.GetStatus(context.Background(), id)
+		if err != nil {
+			return &EditFailed{Err: err, ID: string(id)}
+		}
+		for _, media := range status.MediaAttachments {
+			mediaIDs = append(mediaIDs, media.ID)
+		}
+	}
+
+	params := url.Values{}
+	params.Set("status", toot.Status)
+	params.Set("spoiler_text", toot.SpoilerText)
+	params.Set("sensitive", strconv.FormatBool(toot.Sensitive))
+	if toot.Language != "" {
+		params.Set("language", toot.Language)
+	}
+	for _, mediaID := range mediaIDs {
+		params.Add("media_ids[]", string(mediaID))
+	}
+
+	if err := c.doAPI(http.MethodPut, "/api/v1/statuses/"+url.PathEscape(string(id)), params, nil); err != nil {
+		return &EditFailed{Err: err, ID: string(id)}
+	}
+
+	c.log.Debug().
+		Str("id", string(id)).
+		Msg("edited status")
+
+	return nil
+}
+
+// DeleteStatus deletes a status. A status that's already gone fails with an
+// error IsNotFound recognises.
+func (c *Config) DeleteStatus(id mastodon.ID) error {
+	if err := c.doAPI(http.MethodDelete, "/api/v1/statuses/"+url.PathEscape(string(id)), nil, nil); err != nil {
+		return &DeleteFailed{Err: err, ID: string(id)}
+	}
+
+	c.log.Debug().
+		Str("id", string(id)).
+		Msg("deleted status")
+
+	return nil
+}
+
+// DeleteThread deletes the statuses of a thread, the last reply first so no
+// reply is left without the status it answers. Statuses that are already
+// gone are skipped.
+func (c *Config) DeleteThread(ids []mastodon.ID) error {
+	for i := len(ids) - 1; i >= 0; i-- {
+		if err := c.DeleteStatus(ids[i]); err != nil && !IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// PinnedStatuses returns the IDs of the account's pinned statuses
+func (c *Config) PinnedStatuses() (map[mastodon.ID]bool, error) {
+	accountID, err := c.AccountID()
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []*mastodon.Status
+	params := url.Values{}
+	params.Set("pinned", "true")
+	if err := c.doAPI(http.MethodGet, "/api/v1/accounts/"+url.PathEscape(string(accountID))+"/statuses", params, &statuses); err != nil {
+		return nil, err
+	}
+
+	pinned := make(map[mastodon.ID]bool, len(statuses))
+	for _, status := range statuses {
+		pinned[status.ID] = true
+	}
+	return pinned, nil
+}
+
+// MAX_SCAN_PAGES is how many pages of 40 statuses StatusesBefore reads at
+// most, so a long history is worked through over several calls
+const MAX_SCAN_PAGES = 25
+
+// StatusesBefore returns the account's own statuses created before the given
+// time, oldest first. Boosts are left out. It pages forward from the oldest
+// status and stops at the first one that isn't before the given time, or
+// after MAX_SCAN_PAGES pages.
+func (c *Config) StatusesBefore(before time.Time) ([]*mastodon.Status, error) {
+	accountID, err := c.AccountID()
+	if err != nil {
+		return nil, err
+	}
+
+	var old []*mastodon.Status
+	params := url.Values{}
+	params.Set("limit", "40")
+	params.Set("exclude_reblogs", "true")
+	params.Set("min_id", "0")
+	for pages := 0; pages < MAX_SCAN_PAGES; pages++ {
+		var page []*mastodon.Status
+		if err := c.doAPI(http.MethodGet, "/api/v1/accounts/"+url.PathEscape(string(accountID))+"/statuses", params, &page); err != nil {
+			return nil, err
+		}
+		if len(page) == 0 {
+			return old, nil
+		}
+
+		// Pages come newest first
+		sort.Slice(page, func(i, j int) bool {
+			return page[i].CreatedAt.Before(page[j].CreatedAt)
+		})
+		for _, status := range page {
+			if !status.CreatedAt.Before(before) {
+				return old, nil
+			}
+			old = append(old, status)
+		}
+		params.Set("min_id", string(page[len(page)-1].ID))
+	}
+
+	c.log.Debug().
+		Int("pages", MAX_SCAN_PAGES).
+		Msg("stopped scanning statuses at the page limit")
+	return old, nil
+}
+
+// AccountID returns the ID of the account the access token belongs to
+func (c *Config) AccountID() (mastodon.ID, error) {
+	if c.accountID != "" {
+		return c.accountID, nil
+	}
+
+	account := &mastodon.Account{}
+	if err := c.doAPI(http.MethodGet, "/api/v1/accounts/verify_credentials", nil, account); err != nil {
+		return "", err
+	}
+	c.accountID = account.ID
+	return c.accountID, nil
+}
@@ -0,0 +1,80 @@
+package mastoclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// accountServer serves an account with count statuses, one an hour, the
+// newest an hour old. It counts the pages it serves.
+func accountServer(t *testing.T, count int, pages *int) *httptest.Server {
+	t.Helper()
+	newest := time.Now().UTC().Add(-time.Hour)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/accounts/verify_credentials":
+			w.Write([]byte(`{"id":"1"}`))
+		case "/api/v1/accounts/1/statuses":
+			*pages++
+			minID, _ := strconv.Atoi(r.URL.Query().Get("min_id"))
+			page := []map[string]interface{}{}
+			for id := minID + 1; id <= count && len(page) < 40; id++ {
+				status := map[string]interface{}{
+					"id":         fmt.Sprint(id),
+					"created_at": newest.Add(-time.Duration(count-id) * time.Hour),
+				}
+				page = append([]map[string]interface{}{status}, page...)
+			}
+			json.NewEncoder(w).Encode(page)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestStatusesBefore(t *testing.T) {
+	pages := 0
+	server := accountServer(t, 100, &pages)
+
+	// The oldest 60 are older than the cutoff
+	cutoff := time.Now().UTC().Add(-40*time.Hour - 30*time.Minute)
+	statuses, err := newTestClient(t, server).StatusesBefore(cutoff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 60 {
+		t.Fatalf("got %d statuses, want 60", len(statuses))
+	}
+	for i, status := range statuses {
+		if string(status.ID) != fmt.Sprint(i+1) {
+			t.Fatalf("status %d is %s, want oldest first", i, status.ID)
+		}
+	}
+	// Paging stops at the cutoff
+	if pages != 2 {
+		t.Errorf("read %d pages, want 2", pages)
+	}
+}
+
+func TestStatusesBeforePageLimit(t *testing.T) {
+	pages := 0
+	server := accountServer(t, 40*(MAX_SCAN_PAGES+5), &pages)
+
+	statuses, err := newTestClient(t, server).StatusesBefore(time.Now().UTC())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pages != MAX_SCAN_PAGES {
+		t.Errorf("read %d pages, want %d", pages, MAX_SCAN_PAGES)
+	}
+	if len(statuses) != 40*MAX_SCAN_PAGES || statuses[0].ID != "1" {
+		t.Errorf("got %d statuses from %s, want the oldest %d", len(statuses), statuses[0].ID, 40*MAX_SCAN_PAGES)
+	}
+}
@@ -0,0 +1,150 @@
+package pipeline
+
+import (
+	"fmt"
+	"strings"
+)
+
+// NoFeedConfig is returned when the feed config is required but not provided
+type NoFeedConfig struct {
+	Err error
+}
+
+// Error returns the error message
+func (e *NoFeedConfig) Error() string {
+	if e.Err == nil {
+		return "no feed config provided. use WithFeedConfig() to set the feed config"
+	}
+	return e.Err.Error()
+}
+
+// NoState is returned when the feed state is required but not provided
+type NoState struct {
+	Err error
+}
+
+// Error returns the error message
+func (e *NoState) Error() string {
+	if e.Err == nil {
+		return "no feed state provided. use WithState() to set the feed state"
+	}
+	return e.Err.Error()
+}
+
+// FeedUrlParseError is returned when the feed url cannot be parsed
+type FeedUrlParseError struct {
+	Err error
+	Msg string
+	Url string
+}
+
+// Error returns the error message
+func (e *FeedUrlParseError) Error() string {
+	if e.Msg == "" {
+		e.Msg = "error parsing feed url"
+	}
+	if e.Url != "" {
+		e.Msg += ": " + e.Url
+	}
+	if e.Err != nil {
+		e.Msg += ": " + e.Err.Error()
+	}
+	return e.Msg
+}
+
+// InstanceUrlParseError is returned when the instance url cannot be parsed
+type InstanceUrlParseError struct {
+	Err error
+	Msg string
+	Url string
+}
+
+// Error returns the error message
+func (e *InstanceUrlParseError) Error() string {
+	if e.Msg == "" {
+		e.Msg = "error parsing instance url"
+	}
+	if e.Url != "" {
+		e.Msg += ": " + e.Url
+	}
+	if e.Err != nil {
+		e.Msg += ": " + e.Err.Error()
+	}
+	return e.Msg
+}
+
+// InvalidFirstRun is returned when the first run policy is not recognised
+type InvalidFirstRun struct {
+	Err    error
+	Msg    string
+	Policy string
+}
+
+// Error returns the error message
+func (e *InvalidFirstRun) Error() string {
+	msg := e.Msg
+	if msg == "" {
+		msg = "invalid first run policy. use post-none, post-latest-N or post-all"
+	}
+	if e.Policy != "" {
+		msg += ": " + e.Policy
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+// PartialFailure is returned when items could not be posted. The feed's state
+// only covers the items that were posted, so the rest are retried next run.
+type PartialFailure struct {
+	Err    error
+	Msg    string
+	Posted int
+	Failed []string
+}
+
+// Error returns the error message
+func (e *PartialFailure) Error() string {
+	msg := e.Msg
+	if msg == "" {
+		msg = fmt.Sprintf("failed to post %d items (%d posted)", len(e.Failed), e.Posted)
+	}
+	if len(e.Failed) > 0 {
+		msg += ": " + strings.Join(e.Failed, ", ")
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+// Unwrap returns the error from the first failed post
+func (e *PartialFailure) Unwrap() error {
+	return e.Err
+}
+
+// PruneFailure is returned when expired posts could not be deleted. The rest
+// are deleted on the next prune.
+type PruneFailure struct {
+	Err     error
+	Msg     string
+	Deleted int
+}
+
+// Error returns the error message
+func (e *PruneFailure) Error() string {
+	msg := e.Msg
+	if msg == "" {
+		msg = fmt.Sprintf("failed to delete expired posts (%d deleted)", e.Deleted)
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+// Unwrap returns the error from the failed delete
+func (e *PruneFailure) Unwrap() error {
+	return e.Err
+}
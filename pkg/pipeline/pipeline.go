@@ -0,0 +1,1024 @@
+package pipeline
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-mastodon"
+	"github.com/mmcdole/gofeed"
+	"github.com/rmrfslashbin/mastopost/pkg/config"
+	"github.com/rmrfslashbin/mastopost/pkg/history"
+	"github.com/rmrfslashbin/mastopost/pkg/mastoclient"
+	"github.com/rmrfslashbin/mastopost/pkg/rssfeed"
+	"github.com/rmrfslashbin/mastopost/pkg/utils"
+	"github.com/rs/zerolog"
+)
+
+const (
+	// FIRST_RUN_NONE posts nothing on a new job's first run
+	FIRST_RUN_NONE = "post-none"
+
+	// FIRST_RUN_LATEST posts the latest N items on a new job's first run
+	FIRST_RUN_LATEST = "post-latest-"
+
+	// FIRST_RUN_ALL posts every item on a new job's first run
+	FIRST_RUN_ALL = "post-all"
+
+	// DEFAULT_FIRST_RUN is the first run policy used when none is configured
+	DEFAULT_FIRST_RUN = FIRST_RUN_LATEST + "1"
+
+	// DEFAULT_EDIT_WINDOW is how long after posting an item's post is still
+	// edited when no edit window is configured
+	DEFAULT_EDIT_WINDOW = 24 * time.Hour
+
+	// DEFAULT_DELETE_WINDOW is how long after posting an item that drops out
+	// of the feed still has its post deleted when no delete window is configured
+	DEFAULT_DELETE_WINDOW = 24 * time.Hour
+)
+
+// PostResult is the outcome of posting a single item
+type PostResult struct {
+	// Item is the feed item
+	Item rssfeed.NewItems
+
+	// StatusID is the ID of the new status, if the post went out
+	StatusID *mastodon.ID
+
+	// ScheduledID is the ID of the scheduled status, if the post was scheduled
+	ScheduledID *mastodon.ID
+
+	// ScheduledAt is when the scheduled status is published
+	ScheduledAt *time.Time
+
+	// Err is the error from posting the item, if any
+	Err error
+}
+
+// Option is a function that can be used to configure the pipeline
+type Option func(c *Config)
+
+// Config is the configuration for a single run of a feed's pipeline
+type Config struct {
+	log        *zerolog.Logger
+	feedName   string
+	feedConfig *config.FeedConfig
+	state      *config.FeedLastUpdate
+	dryrun     bool
+	results    []PostResult
+	history    history.Store
+	runID      string
+	records    []history.Record
+	prevState  config.FeedLastUpdate
+}
+
+// New creates a new pipeline Config
+func New(opts ...Option) (*Config, error) {
+	c := &Config{}
+
+	// apply the list of options to Config
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	// Set up the default logger if not set
+	if c.log == nil {
+		log := zerolog.New(os.Stderr).With().Timestamp().Logger()
+		c.log = &log
+	}
+
+	if c.feedConfig == nil {
+		return nil, &NoFeedConfig{}
+	}
+
+	if c.state == nil {
+		return nil, &NoState{}
+	}
+
+	return c, nil
+}
+
+// WithDryrun sets the dryrun flag
+func WithDryrun(dryrun bool) Option {
+	return func(c *Config) {
+		c.dryrun = dryrun
+	}
+}
+
+// WithFeedConfig sets the config of the feed to run
+func WithFeedConfig(feedConfig *config.FeedConfig) Option {
+	return func(c *Config) {
+		c.feedConfig = feedConfig
+	}
+}
+
+// WithFeedName sets the feed name
+func WithFeedName(feedName string) Option {
+	return func(c *Config) {
+		c.feedName = feedName
+	}
+}
+
+// WithHistory sets the store the history of what's posted is kept in
+func WithHistory(store history.Store) Option {
+	return func(c *Config) {
+		c.history = store
+	}
+}
+
+// WithLogger sets the logger to use
+func WithLogger(log *zerolog.Logger) Option {
+	return func(c *Config) {
+		c.log = log
+	}
+}
+
+// WithState sets the feed's saved state. Run updates it in place.
+func WithState(state *config.FeedLastUpdate) Option {
+	return func(c *Config) {
+		c.state = state
+	}
+}
+
+// GetResults returns the outcome of each post attempted by the last Run
+func (c *Config) GetResults() []PostResult {
+	return c.results
+}
+
+// GetRunID returns the ID of the last Run or Prune, as kept in the history
+func (c *Config) GetRunID() string {
+	return c.runID
+}
+
+// Run fetches the feed, posts the new items to Mastodon, edits or deletes
+// the posts of changed or deleted items and updates the feed's state. In
+// dryrun mode nothing is posted, edited or deleted and the state is untouched.
+// If any post fails the state is still updated for the items that were
+// posted and a *PartialFailure is returned.
+func (c *Config) Run() error {
+	c.results = nil
+	c.records = nil
+	c.runID = history.NewRunID(time.Now())
+	c.prevState = *c.state
+
+	feedURL, err := url.Parse(c.feedConfig.FeedURL)
+	if err != nil {
+		return &FeedUrlParseError{Url: c.feedConfig.FeedURL, Err: err}
+	}
+
+	// Set up the fetch settings and saved state for the feed parser
+	feedOpts, err := utils.FeedOptions(c.feedConfig, c.state)
+	if err != nil {
+		return err
+	}
+
+	// Set up a new feed parser
+	feed, err := rssfeed.New(append(feedOpts,
+		rssfeed.WithLogger(c.log),
+		rssfeed.WithURL(feedURL),
+	)...)
+	if err != nil {
+		return err
+	}
+
+	// Get the new items
+	newItems, err := feed.Parse()
+	if err != nil {
+		return err
+	}
+
+	newItems, err = c.filterItems(feed, newItems)
+	if err != nil {
+		return err
+	}
+	newItems = c.heldItems(feed, newItems)
+
+	items, err := c.selectItems(feed, newItems)
+	if err != nil {
+		return err
+	}
+
+	edits, err := c.changedItems(feed)
+	if err != nil {
+		return err
+	}
+
+	deletions, err := c.deletedItems(feed)
+	if err != nil {
+		return err
+	}
+
+	// Log some info
+	c.log.Info().
+		Str("lastupdate", feed.GetLastUpdated().String()).
+		Str("lastpublished", feed.GetLastPublished().String()).
+		Str("feedname", c.feedName).
+		Int("posting", len(items)).
+		Int("deferred", feed.Pending()-feed.Carried()-len(items)).
+		Int("carried", feed.Carried()).
+		Int("editing", len(edits)).
+		Int("deleting", len(deletions)).
+		Msgf("Found %d new items", len(newItems))
+
+	// Are we doing a dry run?
+	if c.dryrun {
+		for _, id := range deletions {
+			c.log.Info().
+				Str("itemId", id).
+				Str("statusId", c.state.Posted[id].StatusID).
+				Msg("dryrun mode. not deleting from Mastodon")
+		}
+		for _, item := range edits {
+			c.log.Info().
+				Str("title", item.Title).
+				Str("link", item.Link).
+				Str("statusId", c.state.Posted[rssfeed.ItemID(item)].StatusID).
+				Msg("dryrun mode. not editing on Mastodon")
+		}
+		for _, item := range items {
+			c.log.Info().
+				Str("title", item.Title).
+				Str("link", item.Link).
+				Str("published", item.Published).
+				Msg("dryrun mode. not posting to Mastodon")
+		}
+		return nil
+	}
+
+	defer c.saveHistory()
+
+	if len(items) > 0 || len(edits) > 0 || len(deletions) > 0 {
+		client, err := c.newClient()
+		if err != nil {
+			return err
+		}
+
+		c.delete(client, deletions)
+
+		if len(items) > 0 || len(edits) > 0 {
+			if err := c.post(client, feed, items, edits); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Update state
+	// Prune needs the statuses of posts until they expire
+	utils.SaveFeedState(c.state, feed, c.feedConfig.ExpireAfter > 0)
+	if c.state.FeedName == "" {
+		c.state.FeedName = c.feedName
+	}
+
+	return c.failures(items)
+}
+
+// failures returns a *PartialFailure listing the items that didn't go out, or
+// nil if every item was posted
+func (c *Config) failures(items []rssfeed.NewItems) error {
+	posted := 0
+	var firstErr error
+	for _, result := range c.results {
+		if result.Err == nil {
+			posted++
+		} else if firstErr == nil {
+			firstErr = result.Err
+		}
+	}
+	if posted == len(items) {
+		return nil
+	}
+
+	var failed []string
+	for _, item := range items[posted:] {
+		name := item.Title
+		if name == "" {
+			name = item.Link
+		}
+		failed = append(failed, fmt.Sprintf("%q", name))
+	}
+	return &PartialFailure{Posted: posted, Failed: failed, Err: firstErr}
+}
+
+// filterItems applies the feed's filter rules. Items the rules skip are
+// marked as seen so they aren't checked again.
+func (c *Config) filterItems(feed *rssfeed.Config, newItems []rssfeed.NewItems) ([]rssfeed.NewItems, error) {
+	filter, err := utils.NewFilter(c.feedConfig.Filters)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []rssfeed.NewItems
+	for _, item := range newItems {
+		if ok, reason := filter.Check(item); !ok {
+			c.log.Info().
+				Str("feedname", c.feedName).
+				Str("title", item.Title).
+				Str("link", item.Link).
+				Str("reason", reason).
+				Msg("skipping filtered item")
+			feed.MarkSeen(item)
+			continue
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// heldItems adds the items held outside the posting window that aren't new
+// items any more, such as items that dropped out of the feed, to the new items
+func (c *Config) heldItems(feed *rssfeed.Config, newItems []rssfeed.NewItems) []rssfeed.NewItems {
+	if len(c.state.Held) == 0 {
+		return newItems
+	}
+
+	ids := make(map[string]bool, len(newItems))
+	for _, item := range newItems {
+		ids[rssfeed.ItemID(item)] = true
+	}
+	seen := feed.GetSeen()
+
+	var held []string
+	for id := range c.state.Held {
+		if _, ok := seen[id]; !ok && !ids[id] {
+			held = append(held, id)
+		}
+	}
+	sort.Strings(held)
+
+	items := newItems
+	for _, id := range held {
+		feed.AddPending(c.state.Held[id])
+		items = append(items, c.state.Held[id])
+	}
+	return items
+}
+
+// hold keeps the items in the feed's state until the posting window opens
+func (c *Config) hold(items []rssfeed.NewItems) {
+	if c.dryrun {
+		return
+	}
+	if c.state.Held == nil {
+		c.state.Held = make(map[string]*gofeed.Item)
+	}
+	for _, item := range items {
+		c.state.Held[rssfeed.ItemID(item)] = item
+	}
+}
+
+// selectItems orders the new items oldest first, applies the first run
+// policy and caps the items to post this run. Items skipped by the first run
+// policy are marked as seen; items over the cap are left for the next run.
+// Outside the posting window every item is held for a later run.
+func (c *Config) selectItems(feed *rssfeed.Config, newItems []rssfeed.NewItems) ([]rssfeed.NewItems, error) {
+	items := make([]rssfeed.NewItems, len(newItems))
+	copy(items, newItems)
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].PublishedParsed.Before(*items[j].PublishedParsed)
+	})
+
+	if feed.IsFirstRun() {
+		latest, err := firstRunLatest(c.feedConfig.FirstRun, len(items))
+		if err != nil {
+			return nil, err
+		}
+		if skip := len(items) - latest; skip > 0 {
+			for _, item := range items[:skip] {
+				feed.MarkSeen(item)
+			}
+			c.log.Info().
+				Str("feedname", c.feedName).
+				Str("policy", c.feedConfig.FirstRun).
+				Msgf("first run: skipping %d older items", skip)
+			items = items[skip:]
+		}
+	}
+
+	window, err := utils.NewWindow(c.feedConfig.PostingWindow)
+	if err != nil {
+		return nil, err
+	}
+	if len(items) > 0 && !window.Open(time.Now()) {
+		c.hold(items)
+		c.log.Info().
+			Str("feedname", c.feedName).
+			Str("opens", window.Next(time.Now()).Format(time.RFC3339)).
+			Msgf("outside the posting window: holding %d items", len(items))
+		return nil, nil
+	}
+
+	if max := c.feedConfig.MaxPostsPerRun; max > 0 && len(items) > max {
+		c.log.Info().
+			Str("feedname", c.feedName).
+			Msgf("deferring %d items over the cap of %d posts per run", len(items)-max, max)
+		items = items[:max]
+	}
+
+	return items, nil
+}
+
+// changedItems returns the posted items whose content changed since they
+// were posted or last edited, if edits are on. Only items posted within the
+// edit window are returned.
+func (c *Config) changedItems(feed *rssfeed.Config) ([]rssfeed.NewItems, error) {
+	if !c.feedConfig.EditUpdates || len(c.state.Posted) == 0 {
+		return nil, nil
+	}
+
+	window, err := c.editWindow()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	var items []rssfeed.NewItems
+	for _, item := range feed.GetFeed().Items {
+		if item == nil {
+			continue
+		}
+		// Scheduled posts have no status to edit until they're published
+		posted, ok := c.state.Posted[rssfeed.ItemID(item)]
+		if !ok || posted.StatusID == "" || now.Sub(posted.PostedAt) > window {
+			continue
+		}
+		// An update date that hasn't moved means the item hasn't changed
+		if item.UpdatedParsed != nil && posted.Updated != nil && !item.UpdatedParsed.After(*posted.Updated) {
+			continue
+		}
+		if rssfeed.ItemHash(item) == posted.Hash {
+			continue
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// deletedItems returns the IDs of the posted items whose posts are to be
+// deleted: items the feed marked with tombstones, if those are handled, and
+// items that dropped out of the feed within the delete window of being
+// posted, if those are handled
+func (c *Config) deletedItems(feed *rssfeed.Config) ([]string, error) {
+	if len(c.state.Posted) == 0 {
+		return nil, nil
+	}
+
+	var ids []string
+	if c.feedConfig.DeleteTombstones {
+		for _, id := range feed.GetDeleted() {
+			if _, ok := c.state.Posted[id]; ok {
+				ids = append(ids, id)
+			}
+		}
+	}
+
+	// An empty feed is more likely broken than emptied on purpose
+	if c.feedConfig.DeleteRemoved && len(feed.GetFeed().Items) > 0 {
+		window, err := c.deleteWindow()
+		if err != nil {
+			return nil, err
+		}
+
+		now := time.Now().UTC()
+		var removed []string
+		for id, posted := range c.state.Posted {
+			if !feed.InFeed(id) && now.Sub(posted.PostedAt) <= window && !utils.Contains(ids, id) {
+				removed = append(removed, id)
+			}
+		}
+		sort.Strings(removed)
+		ids = append(ids, removed...)
+	}
+
+	return ids, nil
+}
+
+// firstRunLatest returns how many of the latest items the first run policy allows
+func firstRunLatest(policy string, count int) (int, error) {
+	if policy == "" {
+		policy = DEFAULT_FIRST_RUN
+	}
+
+	switch {
+	case policy == FIRST_RUN_NONE:
+		return 0, nil
+	case policy == FIRST_RUN_ALL:
+		return count, nil
+	case strings.HasPrefix(policy, FIRST_RUN_LATEST):
+		latest, err := strconv.Atoi(strings.TrimPrefix(policy, FIRST_RUN_LATEST))
+		if err != nil || latest < 1 {
+			return 0, &InvalidFirstRun{Policy: policy, Err: err}
+		}
+		if latest > count {
+			latest = count
+		}
+		return latest, nil
+	}
+	return 0, &InvalidFirstRun{Policy: policy}
+}
+
+// newClient sets up the Mastodon client for the feed's account
+func (c *Config) newClient() (*mastoclient.Config, error) {
+	instanceUrl, err := url.Parse(c.feedConfig.Instance)
+	if err != nil {
+		return nil, &InstanceUrlParseError{Url: c.feedConfig.Instance, Err: err}
+	}
+
+	return mastoclient.New(
+		mastoclient.WithLogger(c.log),
+		mastoclient.WithInstance(instanceUrl),
+		mastoclient.WithClientID(c.feedConfig.ClientId),
+		mastoclient.WithClientSecret(c.feedConfig.ClientSecret),
+		mastoclient.WithToken(c.feedConfig.AccessToken),
+	)
+}
+
+// delete deletes the posts of the deleted items, or cancels them if they're
+// still scheduled. A scheduled post the instance has already published is
+// looked up and deleted. Posts that can't be deleted are logged and tried
+// again on the next run.
+func (c *Config) delete(client *mastoclient.Config, ids []string) {
+	for _, id := range ids {
+		posted := c.state.Posted[id]
+		if posted.StatusID == "" {
+			err := client.CancelScheduled(mastodon.ID(posted.ScheduledID))
+			if err == nil {
+				delete(c.state.Posted, id)
+				c.addRecord(history.Record{ItemID: id, ScheduledID: posted.ScheduledID, ScheduledAt: posted.ScheduledAt, Outcome: history.OUTCOME_DELETED})
+				c.log.Info().
+					Str("feedname", c.feedName).
+					Str("itemId", id).
+					Str("scheduledId", posted.ScheduledID).
+					Msg("cancelled scheduled post")
+				continue
+			}
+			if !mastoclient.IsNotFound(err) {
+				c.log.Error().
+					Err(err).
+					Str("itemId", id).
+					Str("scheduledId", posted.ScheduledID).
+					Msg("error cancelling scheduled post")
+				continue
+			}
+
+			// Gone from the scheduled statuses, so most likely published
+			status, err := c.publishedStatus(client, posted, c.state.Posted)
+			if err != nil {
+				c.log.Error().
+					Err(err).
+					Str("itemId", id).
+					Str("scheduledId", posted.ScheduledID).
+					Msg("error looking up published scheduled post")
+				continue
+			}
+			if status == nil {
+				c.log.Warn().
+					Str("feedname", c.feedName).
+					Str("itemId", id).
+					Str("scheduledId", posted.ScheduledID).
+					Msg("scheduled post is gone but its published status wasn't found. keeping it")
+				continue
+			}
+			posted.StatusID = string(status.ID)
+			posted.PostedAt = status.CreatedAt.UTC()
+			c.state.Posted[id] = posted
+		}
+
+		if err := client.DeleteThread(statusIDs(posted)); err != nil {
+			c.log.Error().
+				Err(err).
+				Str("itemId", id).
+				Str("statusId", posted.StatusID).
+				Msg("error deleting from Mastodon")
+			continue
+		}
+
+		// The item stays seen so it isn't posted again if it comes back
+		delete(c.state.Posted, id)
+		c.addRecord(history.Record{ItemID: id, StatusID: posted.StatusID, ThreadIDs: posted.ThreadIDs, Outcome: history.OUTCOME_DELETED})
+		c.log.Info().
+			Str("feedname", c.feedName).
+			Str("itemId", id).
+			Str("statusId", posted.StatusID).
+			Msg("deleted from Mastodon")
+	}
+}
+
+// publishedStatus looks up the status a scheduled item was published as,
+// leaving out the statuses of the posts already known. It returns nil if
+// there's none.
+func (c *Config) publishedStatus(client *mastoclient.Config, posted config.PostedItem, posts map[string]config.PostedItem) (*mastodon.Status, error) {
+	if posted.ScheduledAt == nil {
+		return nil, nil
+	}
+
+	skip := make(map[mastodon.ID]bool)
+	for _, other := range posts {
+		if other.StatusID != "" {
+			for _, id := range statusIDs(other) {
+				skip[id] = true
+			}
+		}
+	}
+	return client.PublishedStatus(*posted.ScheduledAt, skip)
+}
+
+// post edits the statuses of the changed items, then posts the new items to
+// Mastodon in order, marking each one as seen. It stops at the first failure.
+func (c *Config) post(client *mastoclient.Config, feed *rssfeed.Config, items []rssfeed.NewItems, edits []rssfeed.NewItems) error {
+	delay, err := c.postDelay()
+	if err != nil {
+		return err
+	}
+
+	cardTimeout, err := c.cardTimeout()
+	if err != nil {
+		return err
+	}
+
+	// Fit posts to the instance's limits
+	limits, err := client.GetLimits()
+	if err != nil {
+		c.log.Warn().
+			Err(err).
+			Int("maxCharacters", limits.MaxCharacters).
+			Msg("unable to get instance limits. using defaults")
+	}
+
+	builder, err := utils.NewPostBuilder(
+		utils.WithTemplate(c.feedConfig.Template),
+		utils.WithFeed(feed.GetFeed()),
+		utils.WithFeedName(c.feedName),
+		utils.WithLimits(limits.MaxCharacters, limits.CharactersPerURL),
+		utils.WithExcerpt(c.feedConfig.Excerpt, c.feedConfig.ExcerptLength),
+		utils.WithContentWarnings(c.feedConfig.ContentWarnings),
+		utils.WithHashtags(c.feedConfig.HashtagMap, c.feedConfig.Hashtags, c.feedConfig.MaxHashtags),
+		utils.WithVisibility(c.feedConfig.Visibility),
+		utils.WithLanguage(c.feedConfig.Language),
+		utils.WithThread(c.feedConfig.MaxThreadPosts, c.feedConfig.ThreadUnlisted),
+		utils.WithHTTPClient(&http.Client{Timeout: cardTimeout}),
+	)
+	if err != nil {
+		return err
+	}
+
+	c.edit(client, builder, edits)
+
+	slots, err := c.newSchedule(client, len(items))
+	if err != nil {
+		return err
+	}
+
+	// Post oldest first, one at a time, so toots land in order
+	posted := 0
+	for i, item := range items {
+		// create a new post/toot, or a thread of them
+		var statuses []*mastodon.Status
+		var scheduled *mastoclient.ScheduledStatus
+		toots, err := c.makePosts(builder, item)
+		if err == nil {
+			if c.feedConfig.Media || c.feedConfig.CardImage {
+				toots[0].MediaIDs = c.attachMedia(client, limits, item, cardTimeout)
+			}
+
+			// Replies can't be scheduled, as the status they reply to has
+			// no ID until it's published, so threads go out straight away
+			if at := slots.next(len(toots)); at != nil {
+				scheduled, err = client.Schedule(toots[0], *at)
+			} else {
+				if posted > 0 && delay > 0 {
+					time.Sleep(delay)
+				}
+				posted++
+				statuses, err = client.PostThread(toots)
+			}
+		}
+		if err == nil && scheduled != nil {
+			c.scheduled(feed, item, scheduled)
+			continue
+		}
+		if err != nil && len(statuses) == 0 {
+			record := itemRecord(item, history.OUTCOME_FAILED)
+			record.Error = err.Error()
+			c.addRecord(record)
+			c.results = append(c.results, PostResult{Item: item, Err: err})
+			// Stop here so the state only covers what went out. This item and
+			// the rest are reported as failed and tried again on the next run.
+			c.log.Error().
+				Err(err).
+				Str("title", item.Title).
+				Str("link", item.Link).
+				Int("remaining", len(items)-i).
+				Msg("error posting to Mastodon")
+			return nil
+		}
+
+		// Once the first status is out the item counts as posted, even if
+		// the rest of its thread isn't, so it isn't posted twice
+		status := statuses[0]
+		ids := make([]string, len(statuses))
+		for i, s := range statuses {
+			ids[i] = string(s.ID)
+		}
+		c.results = append(c.results, PostResult{Item: item, StatusID: &status.ID})
+		record := itemRecord(item, history.OUTCOME_POSTED)
+		record.StatusID = ids[0]
+		record.ThreadIDs = ids[1:]
+		record.StatusURL = status.URL
+		record.PrevLastUpdated = c.prevState.LastUpdated
+		record.PrevLastPublished = c.prevState.LastPublished
+		if err != nil {
+			record.Error = err.Error()
+			c.log.Error().
+				Err(err).
+				Str("title", item.Title).
+				Str("id", ids[0]).
+				Int("posted", len(statuses)).
+				Int("thread", len(toots)).
+				Msg("error posting thread to Mastodon")
+		}
+		c.addRecord(record)
+
+		feed.MarkSeen(item)
+		c.record(item, ids, time.Now().UTC())
+		c.log.Info().
+			Str("id", ids[0]).
+			Int("statuses", len(ids)).
+			Str("toInstance", c.feedConfig.Instance).
+			Msg("posted to Mastodon")
+	}
+
+	return nil
+}
+
+// scheduled remembers an item scheduled to be published by the instance
+// later, and marks it as seen
+func (c *Config) scheduled(feed *rssfeed.Config, item rssfeed.NewItems, scheduled *mastoclient.ScheduledStatus) {
+	at := scheduled.ScheduledAt.UTC()
+	c.results = append(c.results, PostResult{Item: item, ScheduledID: &scheduled.ID, ScheduledAt: &at})
+	record := itemRecord(item, history.OUTCOME_SCHEDULED)
+	record.ScheduledID = string(scheduled.ID)
+	record.ScheduledAt = &at
+	record.PrevLastUpdated = c.prevState.LastUpdated
+	record.PrevLastPublished = c.prevState.LastPublished
+	c.addRecord(record)
+
+	feed.MarkSeen(item)
+	if c.state.Posted == nil {
+		c.state.Posted = make(map[string]config.PostedItem)
+	}
+	c.state.Posted[rssfeed.ItemID(item)] = config.PostedItem{
+		PostedAt:    time.Now().UTC(),
+		Updated:     item.UpdatedParsed,
+		Hash:        rssfeed.ItemHash(item),
+		ScheduledID: string(scheduled.ID),
+		ScheduledAt: &at,
+	}
+	c.log.Info().
+		Str("scheduledId", string(scheduled.ID)).
+		Time("scheduledAt", at).
+		Str("toInstance", c.feedConfig.Instance).
+		Msg("scheduled on Mastodon")
+}
+
+// makePosts formats an item as a single post, or as a thread if threads are on
+func (c *Config) makePosts(builder *utils.PostBuilder, item rssfeed.NewItems) ([]*mastodon.Toot, error) {
+	if c.feedConfig.Thread {
+		return builder.MakeThread(item)
+	}
+	toot, err := builder.MakePost(item)
+	if err != nil {
+		return nil, err
+	}
+	return []*mastodon.Toot{toot}, nil
+}
+
+// edit formats the changed items again and edits their statuses. Items that
+// can't be edited are logged and tried again on the next run. A thread is
+// only edited if the item still splits into as many statuses; otherwise the
+// change is logged and left as is, since statuses can't be added to the
+// middle of a thread.
+func (c *Config) edit(client *mastoclient.Config, builder *utils.PostBuilder, items []rssfeed.NewItems) {
+	for _, item := range items {
+		posted := c.state.Posted[rssfeed.ItemID(item)]
+		ids := posted.StatusIDs()
+
+		toots, err := c.makePosts(builder, item)
+		if err == nil && len(toots) != len(ids) {
+			c.log.Warn().
+				Str("title", item.Title).
+				Str("id", posted.StatusID).
+				Int("statuses", len(ids)).
+				Int("thread", len(toots)).
+				Msg("changed item no longer fits its thread. not editing")
+			c.record(item, ids, posted.PostedAt)
+			continue
+		}
+		for i := 0; err == nil && i < len(toots); i++ {
+			err = client.EditStatus(mastodon.ID(ids[i]), toots[i])
+		}
+		if err != nil {
+			c.log.Error().
+				Err(err).
+				Str("title", item.Title).
+				Str("link", item.Link).
+				Str("id", posted.StatusID).
+				Msg("error editing on Mastodon")
+			continue
+		}
+
+		c.record(item, ids, posted.PostedAt)
+		record := itemRecord(item, history.OUTCOME_EDITED)
+		record.StatusID = posted.StatusID
+		record.ThreadIDs = posted.ThreadIDs
+		c.addRecord(record)
+		c.log.Info().
+			Str("id", posted.StatusID).
+			Str("title", item.Title).
+			Msg("edited on Mastodon")
+	}
+}
+
+// record remembers the statuses an item was posted as, the first status
+// followed by the rest of its thread, along with the item's update date and
+// content hash to tell when it changes
+func (c *Config) record(item rssfeed.NewItems, ids []string, postedAt time.Time) {
+	if c.state.Posted == nil {
+		c.state.Posted = make(map[string]config.PostedItem)
+	}
+	posted := config.PostedItem{
+		StatusID: ids[0],
+		PostedAt: postedAt,
+		Updated:  item.UpdatedParsed,
+		Hash:     rssfeed.ItemHash(item),
+	}
+	if len(ids) > 1 {
+		posted.ThreadIDs = ids[1:]
+	}
+	c.state.Posted[rssfeed.ItemID(item)] = posted
+}
+
+// statusIDs returns the IDs of a posted item's statuses
+func statusIDs(posted config.PostedItem) []mastodon.ID {
+	var ids []mastodon.ID
+	for _, id := range posted.StatusIDs() {
+		ids = append(ids, mastodon.ID(id))
+	}
+	return ids
+}
+
+// itemRecord returns a history record of an outcome for an item
+func itemRecord(item rssfeed.NewItems, outcome string) history.Record {
+	return history.Record{
+		ItemID:    rssfeed.ItemID(item),
+		Link:      item.Link,
+		Title:     item.Title,
+		Published: item.PublishedParsed,
+		Outcome:   outcome,
+	}
+}
+
+// addRecord adds a record to the run's history, filling in the run, feed,
+// instance and time
+func (c *Config) addRecord(record history.Record) {
+	record.RunID = c.runID
+	record.FeedName = c.feedName
+	record.Instance = c.feedConfig.Instance
+	record.Time = time.Now().UTC()
+	c.records = append(c.records, record)
+}
+
+// saveHistory adds the run's records to the history store, if there is one.
+// The posts are already out, so a failure is only logged.
+func (c *Config) saveHistory() {
+	if c.history == nil || len(c.records) == 0 {
+		return
+	}
+	if err := c.history.Add(c.records); err != nil {
+		c.log.Warn().
+			Err(err).
+			Str("feedname", c.feedName).
+			Str("runId", c.runID).
+			Msg("unable to save history")
+	}
+}
+
+// attachMedia uploads the item's images and returns their IDs. If the item
+// has none and card images are on, the linked article's card image is used.
+// Images that can't be downloaded or uploaded are logged and left off the post.
+func (c *Config) attachMedia(client *mastoclient.Config, limits *mastoclient.InstanceLimits, item rssfeed.NewItems, cardTimeout time.Duration) []mastodon.ID {
+	max := limits.MaxAttachments
+	if c.feedConfig.MaxAttachments > 0 && c.feedConfig.MaxAttachments < max {
+		max = c.feedConfig.MaxAttachments
+	}
+
+	var ids []mastodon.ID
+	if c.feedConfig.Media {
+		for _, ref := range utils.FindMedia(item) {
+			if len(ids) >= max {
+				break
+			}
+			if id := c.uploadMedia(client, nil, ref, limits.ImageSizeLimit, limits.MediaTypes, item); id != nil {
+				ids = append(ids, *id)
+			}
+		}
+	}
+
+	if len(ids) == 0 && c.feedConfig.CardImage && item.Link != "" {
+		httpClient := &http.Client{Timeout: cardTimeout}
+		ref, err := utils.FindCardImage(httpClient, item.Link, item.Title)
+		if err != nil {
+			c.log.Warn().
+				Err(err).
+				Str("title", item.Title).
+				Msg("unable to get card image")
+		} else if ref != nil {
+			maxSize := limits.ImageSizeLimit
+			if c.feedConfig.CardImageMaxSize > 0 && c.feedConfig.CardImageMaxSize < maxSize {
+				maxSize = c.feedConfig.CardImageMaxSize
+			}
+			if id := c.uploadMedia(client, httpClient, *ref, maxSize, limits.MediaTypes, item); id != nil {
+				ids = append(ids, *id)
+			}
+		}
+	}
+
+	return ids
+}
+
+// uploadMedia downloads an image and uploads it to the instance, returning
+// its ID or nil if that failed
+func (c *Config) uploadMedia(client *mastoclient.Config, httpClient *http.Client, ref utils.MediaRef, maxSize int64, types []string, item rssfeed.NewItems) *mastodon.ID {
+	media, err := utils.DownloadMedia(httpClient, ref, maxSize, types)
+	if err != nil {
+		c.log.Warn().
+			Err(err).
+			Str("title", item.Title).
+			Msg("skipping media")
+		return nil
+	}
+
+	id, err := client.UploadMedia(media)
+	if err != nil {
+		c.log.Warn().
+			Err(err).
+			Str("title", item.Title).
+			Str("url", ref.URL).
+			Msg("skipping media")
+		return nil
+	}
+	return id
+}
+
+// cardTimeout returns the configured time limit for fetching card images
+func (c *Config) cardTimeout() (time.Duration, error) {
+	if c.feedConfig.CardTimeout == "" {
+		return utils.DEFAULT_CARD_TIMEOUT, nil
+	}
+	timeout, err := time.ParseDuration(c.feedConfig.CardTimeout)
+	if err != nil {
+		return 0, &utils.InvalidSetting{Setting: "cardtimeout", Err: err}
+	}
+	return timeout, nil
+}
+
+// editWindow returns how long after posting an item's post is still edited
+func (c *Config) editWindow() (time.Duration, error) {
+	if c.feedConfig.EditWindow == "" {
+		return DEFAULT_EDIT_WINDOW, nil
+	}
+	window, err := time.ParseDuration(c.feedConfig.EditWindow)
+	if err != nil {
+		return 0, &utils.InvalidSetting{Setting: "editwindow", Err: err}
+	}
+	return window, nil
+}
+
+// deleteWindow returns how long after posting an item that drops out of the
+// feed still has its post deleted
+func (c *Config) deleteWindow() (time.Duration, error) {
+	if c.feedConfig.DeleteWindow == "" {
+		return DEFAULT_DELETE_WINDOW, nil
+	}
+	window, err := time.ParseDuration(c.feedConfig.DeleteWindow)
+	if err != nil {
+		return 0, &utils.InvalidSetting{Setting: "deletewindow", Err: err}
+	}
+	return window, nil
+}
+
+// postDelay returns the configured pause between posts
+func (c *Config) postDelay() (time.Duration, error) {
+	if c.feedConfig.PostDelay == "" {
+		return 0, nil
+	}
+	delay, err := time.ParseDuration(c.feedConfig.PostDelay)
+	if err != nil {
+		return 0, &utils.InvalidSetting{Setting: "postdelay", Err: err}
+	}
+	return delay, nil
+}
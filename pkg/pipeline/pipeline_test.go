@@ -0,0 +1,778 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+	"github.com/rmrfslashbin/mastopost/pkg/config"
+	"github.com/rmrfslashbin/mastopost/pkg/history"
+	"github.com/rmrfslashbin/mastopost/pkg/rssfeed"
+	"github.com/rmrfslashbin/mastopost/pkg/utils"
+	"github.com/rs/zerolog"
+)
+
+const testFeed = `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0">
+<channel>
+<title>Test feed</title>
+<link>https://example.com/</link>
+<item>
+<title>Third post</title>
+<link>https://example.com/3</link>
+<guid>https://example.com/3</guid>
+<pubDate>Wed, 03 Jan 2024 10:00:00 +0000</pubDate>
+</item>
+<item>
+<title>First post</title>
+<link>https://example.com/1</link>
+<guid>https://example.com/1</guid>
+<pubDate>Mon, 01 Jan 2024 10:00:00 +0000</pubDate>
+</item>
+<item>
+<title>Second post</title>
+<link>https://example.com/2</link>
+<guid>https://example.com/2</guid>
+<pubDate>Tue, 02 Jan 2024 10:00:00 +0000</pubDate>
+</item>
+</channel>
+</rss>`
+
+// fakeMastodon is a Mastodon instance that keeps what it's sent
+type fakeMastodon struct {
+	server *httptest.Server
+
+	mu sync.Mutex
+	// posted is the form of each status posted
+	posted []url.Values
+	// failAt is the post that fails, counting from 1. Zero fails none.
+	failAt int
+	// edited is the form of each status edit, by status ID
+	edited map[string]url.Values
+	// deleted are the IDs of the statuses deleted
+	deleted []string
+	// gone are the IDs of statuses that are already deleted
+	gone map[string]bool
+	// account are the account's statuses, oldest first
+	account []accountStatus
+	// pinned are the IDs of the account's pinned statuses
+	pinned map[string]bool
+	// scheduled are the account's scheduled statuses
+	scheduled []scheduledStatus
+	// cancelled are the IDs of the scheduled statuses cancelled
+	cancelled []string
+}
+
+// scheduledStatus is a status scheduled on the fake instance
+type scheduledStatus struct {
+	ID          string    `json:"id"`
+	ScheduledAt time.Time `json:"scheduled_at"`
+}
+
+// accountStatus is a status on the fake instance's account
+type accountStatus struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// newFakeMastodon starts a fake Mastodon instance
+func newFakeMastodon(t *testing.T) *fakeMastodon {
+	t.Helper()
+	m := &fakeMastodon{}
+	m.server = httptest.NewServer(http.HandlerFunc(m.serve))
+	t.Cleanup(m.server.Close)
+	return m
+}
+
+// serve answers the API calls the pipeline makes
+func (m *fakeMastodon) serve(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/api/v1/instance":
+		writeJSON(w, map[string]interface{}{})
+	case r.Method == http.MethodPost && r.URL.Path == "/api/v1/statuses":
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if m.failAt == len(m.posted)+1 {
+			m.failAt = 0
+			http.Error(w, `{"error":"unavailable"}`, http.StatusServiceUnavailable)
+			return
+		}
+		if at := r.PostForm.Get("scheduled_at"); at != "" {
+			scheduledAt, err := time.Parse(time.RFC3339, at)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+				return
+			}
+			status := scheduledStatus{ID: fmt.Sprintf("s%d", len(m.scheduled)+1), ScheduledAt: scheduledAt}
+			m.scheduled = append(m.scheduled, status)
+			writeJSON(w, status)
+			return
+		}
+		m.posted = append(m.posted, r.PostForm)
+		id := fmt.Sprint(len(m.posted))
+		writeJSON(w, map[string]interface{}{
+			"id":         id,
+			"url":        m.server.URL + "/@test/" + id,
+			"created_at": time.Now().UTC().Format(time.RFC3339),
+		})
+	case r.Method == http.MethodGet && r.URL.Path == "/api/v1/accounts/verify_credentials":
+		writeJSON(w, map[string]interface{}{"id": "1"})
+	case r.Method == http.MethodGet && r.URL.Path == "/api/v1/accounts/1/statuses":
+		m.serveAccount(w, r)
+	case r.Method == http.MethodGet && r.URL.Path == "/api/v1/scheduled_statuses":
+		// Everything fits on the first page
+		page := []scheduledStatus{}
+		if r.URL.Query().Get("max_id") == "" {
+			page = append(page, m.scheduled...)
+		}
+		writeJSON(w, page)
+	case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/api/v1/scheduled_statuses/"):
+		id := strings.TrimPrefix(r.URL.Path, "/api/v1/scheduled_statuses/")
+		for i, status := range m.scheduled {
+			if status.ID == id {
+				m.scheduled = append(m.scheduled[:i], m.scheduled[i+1:]...)
+				m.cancelled = append(m.cancelled, id)
+				writeJSON(w, map[string]interface{}{})
+				return
+			}
+		}
+		http.Error(w, `{"error":"Record not found"}`, http.StatusNotFound)
+	case strings.HasPrefix(r.URL.Path, "/api/v1/statuses/"):
+		id := strings.TrimPrefix(r.URL.Path, "/api/v1/statuses/")
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, map[string]interface{}{"id": id, "media_attachments": []interface{}{}})
+		case http.MethodPut:
+			if err := r.ParseForm(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if m.edited == nil {
+				m.edited = make(map[string]url.Values)
+			}
+			m.edited[id] = r.PostForm
+			writeJSON(w, map[string]interface{}{"id": id})
+		case http.MethodDelete:
+			if m.gone[id] {
+				http.Error(w, `{"error":"Record not found"}`, http.StatusNotFound)
+				return
+			}
+			m.deleted = append(m.deleted, id)
+			for i, status := range m.account {
+				if status.ID == id {
+					m.account = append(m.account[:i], m.account[i+1:]...)
+					break
+				}
+			}
+			writeJSON(w, map[string]interface{}{"id": id})
+		default:
+			http.NotFound(w, r)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// serveAccount lists the account's statuses: the pinned ones, or a page of
+// up to 40 newer than min_id, or else the newest 40 older than max_id, newest
+// first
+func (m *fakeMastodon) serveAccount(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	page := []accountStatus{}
+	if query.Get("pinned") == "true" {
+		for _, status := range m.account {
+			if m.pinned[status.ID] {
+				page = append(page, status)
+			}
+		}
+		writeJSON(w, page)
+		return
+	}
+
+	if query.Get("min_id") != "" {
+		minID, _ := strconv.Atoi(query.Get("min_id"))
+		for _, status := range m.account {
+			if id, _ := strconv.Atoi(status.ID); id > minID && len(page) < 40 {
+				page = append([]accountStatus{status}, page...)
+			}
+		}
+		writeJSON(w, page)
+		return
+	}
+
+	maxID, err := strconv.Atoi(query.Get("max_id"))
+	for i := len(m.account) - 1; i >= 0 && len(page) < 40; i-- {
+		if id, _ := strconv.Atoi(m.account[i].ID); err != nil || id < maxID {
+			page = append(page, m.account[i])
+		}
+	}
+	writeJSON(w, page)
+}
+
+// statuses returns the text of each status posted
+func (m *fakeMastodon) statuses() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var statuses []string
+	for _, form := range m.posted {
+		statuses = append(statuses, form.Get("status"))
+	}
+	return statuses
+}
+
+// writeJSON writes v as a JSON response
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// serveFeed starts a server answering every request with the feed
+func serveFeed(t *testing.T, body string) *httptest.Server {
+	return serveFeeds(t, body)
+}
+
+// serveFeeds starts a server answering each request with the next of the
+// feeds, and then the last one. It sends an ETag but ignores If-None-Match.
+func serveFeeds(t *testing.T, bodies ...string) *httptest.Server {
+	t.Helper()
+	var mu sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		body := bodies[0]
+		if len(bodies) > 1 {
+			bodies = bodies[1:]
+		}
+		mu.Unlock()
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Content-Type", "application/rss+xml")
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// runConfig returns the config of a feed posting everything to the instance
+func runConfig(feed *httptest.Server, instance *fakeMastodon) *config.FeedConfig {
+	return &config.FeedConfig{
+		FeedURL:      feed.URL,
+		Instance:     instance.server.URL,
+		ClientId:     "client",
+		ClientSecret: "secret",
+		AccessToken:  "token",
+		FirstRun:     FIRST_RUN_ALL,
+	}
+}
+
+// newRunPipeline returns a pipeline that posts, with the given state
+func newRunPipeline(t *testing.T, feedConfig *config.FeedConfig, state *config.FeedLastUpdate) *Config {
+	t.Helper()
+	log := zerolog.Nop()
+	c, err := New(
+		WithLogger(&log),
+		WithFeedName("test"),
+		WithFeedConfig(feedConfig),
+		WithState(state),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// newTestPipeline returns a pipeline for the feed config with empty state
+func newTestPipeline(t *testing.T, feedConfig *config.FeedConfig) *Config {
+	t.Helper()
+	log := zerolog.Nop()
+	c, err := New(
+		WithLogger(&log),
+		WithFeedName("test"),
+		WithFeedConfig(feedConfig),
+		WithState(&config.FeedLastUpdate{}),
+		WithDryrun(true),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// newTestFeed returns a feed parser with nothing seen yet
+func newTestFeed(t *testing.T) *rssfeed.Config {
+	t.Helper()
+	log := zerolog.Nop()
+	feed, err := rssfeed.New(rssfeed.WithLogger(&log), rssfeed.WithSeen(map[string]time.Time{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return feed
+}
+
+// newParsedFeed returns a feed parser that has parsed a feed of the items
+func newParsedFeed(t *testing.T, items ...rssfeed.NewItems) *rssfeed.Config {
+	t.Helper()
+	var body strings.Builder
+	body.WriteString(`<?xml version="1.0" encoding="UTF-8"?><rss version="2.0"><channel><title>Test feed</title>`)
+	for _, item := range items {
+		fmt.Fprintf(&body, "<item><title>%s</title><guid>%s</guid><pubDate>%s</pubDate></item>", item.Title, item.GUID, item.PublishedParsed.Format(time.RFC1123Z))
+	}
+	body.WriteString("</channel></rss>")
+
+	u, err := url.Parse(serveFeed(t, body.String()).URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := zerolog.Nop()
+	feed, err := rssfeed.New(rssfeed.WithLogger(&log), rssfeed.WithURL(u))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := feed.Parse(); err != nil {
+		t.Fatal(err)
+	}
+	return feed
+}
+
+// testItem returns an item published the given number of hours into 2024
+func testItem(guid string, hours int) rssfeed.NewItems {
+	published := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).Add(time.Duration(hours) * time.Hour)
+	return &gofeed.Item{GUID: guid, Title: guid, PublishedParsed: &published}
+}
+
+func TestFirstRunLatest(t *testing.T) {
+	tests := []struct {
+		policy string
+		count  int
+		want   int
+	}{
+		{"", 5, 1},
+		{FIRST_RUN_NONE, 5, 0},
+		{FIRST_RUN_ALL, 5, 5},
+		{"post-latest-3", 5, 3},
+		{"post-latest-10", 5, 5},
+	}
+	for _, tt := range tests {
+		got, err := firstRunLatest(tt.policy, tt.count)
+		if err != nil {
+			t.Errorf("%q: %v", tt.policy, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%q: got %d, want %d", tt.policy, got, tt.want)
+		}
+	}
+
+	for _, policy := range []string{"post-latest-0", "post-latest-x", "post-some"} {
+		_, err := firstRunLatest(policy, 5)
+		var invalid *InvalidFirstRun
+		if !errors.As(err, &invalid) {
+			t.Errorf("%q: got %v, want *InvalidFirstRun", policy, err)
+		}
+	}
+}
+
+func TestSelectItemsFirstRun(t *testing.T) {
+	c := newTestPipeline(t, &config.FeedConfig{FirstRun: "post-latest-2", MaxPostsPerRun: 1})
+	feed := newTestFeed(t)
+
+	newItems := []rssfeed.NewItems{testItem("c", 3), testItem("a", 1), testItem("b", 2)}
+	items, err := c.selectItems(feed, newItems)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The latest two, capped to the oldest of them
+	if len(items) != 1 || items[0].GUID != "b" {
+		t.Fatalf("selected %v, want only b", items)
+	}
+	// The item skipped by the policy is seen; the one over the cap isn't
+	seen := feed.GetSeen()
+	if _, ok := seen["a"]; !ok {
+		t.Error("item skipped by the first run policy not marked as seen")
+	}
+	if _, ok := seen["c"]; ok {
+		t.Error("item over the cap marked as seen")
+	}
+}
+
+func TestRunPostsOldestFirst(t *testing.T) {
+	instance := newFakeMastodon(t)
+	feedConfig := runConfig(serveFeed(t, testFeed), instance)
+	feedConfig.PostDelay = "1ms"
+
+	c := newRunPipeline(t, feedConfig, &config.FeedLastUpdate{})
+	if err := c.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	statuses := instance.statuses()
+	if len(statuses) != 3 {
+		t.Fatalf("posted %d statuses, want 3", len(statuses))
+	}
+	for i, title := range []string{"First post", "Second post", "Third post"} {
+		if !strings.HasPrefix(statuses[i], title) {
+			t.Errorf("status %d is %q, want %s", i+1, statuses[i], title)
+		}
+	}
+}
+
+func TestRunInvalidPostDelay(t *testing.T) {
+	instance := newFakeMastodon(t)
+	feedConfig := runConfig(serveFeed(t, testFeed), instance)
+	feedConfig.PostDelay = "a while"
+
+	c := newRunPipeline(t, feedConfig, &config.FeedLastUpdate{})
+	var invalid *utils.InvalidSetting
+	if err := c.Run(); !errors.As(err, &invalid) {
+		t.Fatalf("Run returned %v, want *utils.InvalidSetting", err)
+	}
+	if len(instance.statuses()) != 0 {
+		t.Error("posted with an invalid post delay")
+	}
+}
+
+func TestRunPartialFailure(t *testing.T) {
+	instance := newFakeMastodon(t)
+	instance.failAt = 2
+	feedConfig := runConfig(serveFeed(t, testFeed), instance)
+	state := &config.FeedLastUpdate{}
+
+	err := newRunPipeline(t, feedConfig, state).Run()
+	var partial *PartialFailure
+	if !errors.As(err, &partial) {
+		t.Fatalf("Run returned %v, want *PartialFailure", err)
+	}
+	if partial.Posted != 1 || len(partial.Failed) != 2 {
+		t.Errorf("posted %d and failed %d, want 1 and 2", partial.Posted, len(partial.Failed))
+	}
+
+	// The state only covers the item that went out
+	if _, ok := state.Seen["https://example.com/1"]; !ok {
+		t.Error("posted item not seen")
+	}
+	for _, id := range []string{"https://example.com/2", "https://example.com/3"} {
+		if _, ok := state.Seen[id]; ok {
+			t.Errorf("%s seen, but it wasn't posted", id)
+		}
+	}
+	want := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	if state.LastPublished == nil || !state.LastPublished.Equal(want) {
+		t.Errorf("last published is %v, want %v", state.LastPublished, want)
+	}
+
+	// The next run posts the rest
+	if err := newRunPipeline(t, feedConfig, state).Run(); err != nil {
+		t.Fatal(err)
+	}
+	statuses := instance.statuses()
+	if len(statuses) != 3 {
+		t.Fatalf("posted %d statuses in all, want 3", len(statuses))
+	}
+	if !strings.HasPrefix(statuses[1], "Second post") || !strings.HasPrefix(statuses[2], "Third post") {
+		t.Errorf("retried %q and %q, want the second and third posts", statuses[1], statuses[2])
+	}
+}
+
+func TestRunSavesHistory(t *testing.T) {
+	instance := newFakeMastodon(t)
+	instance.failAt = 2
+	feedConfig := runConfig(serveFeed(t, testFeed), instance)
+	store, err := history.NewFile(filepath.Join(t.TempDir(), "history.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := zerolog.Nop()
+	c, err := New(
+		WithLogger(&log),
+		WithFeedName("test"),
+		WithFeedConfig(feedConfig),
+		WithState(&config.FeedLastUpdate{}),
+		WithHistory(store),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Run(); !errors.As(err, new(*PartialFailure)) {
+		t.Fatalf("Run returned %v, want *PartialFailure", err)
+	}
+
+	records, err := store.Query(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var outcomes []string
+	for _, record := range records {
+		outcomes = append(outcomes, record.Outcome+" "+record.Link)
+		if record.RunID == "" || record.RunID != records[0].RunID {
+			t.Errorf("record %+v isn't from the run", record)
+		}
+		if record.FeedName != "test" || record.Instance != feedConfig.Instance {
+			t.Errorf("record %+v has the wrong feed or instance", record)
+		}
+	}
+	want := []string{
+		history.OUTCOME_POSTED + " https://example.com/1",
+		history.OUTCOME_FAILED + " https://example.com/2",
+	}
+	if strings.Join(outcomes, ",") != strings.Join(want, ",") {
+		t.Errorf("history is %v, want %v", outcomes, want)
+	}
+	if records[0].StatusID == "" {
+		t.Error("posted record has no status ID")
+	}
+	if records[1].Error == "" {
+		t.Error("failed record has no error")
+	}
+}
+
+func TestRunPostsThread(t *testing.T) {
+	instance := newFakeMastodon(t)
+	body := `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0">
+<channel>
+<title>Test feed</title>
+<item>
+<title>` + strings.Repeat("Some more words to post. ", 60) + `</title>
+<link>https://example.com/1</link>
+<guid>https://example.com/1</guid>
+<pubDate>Mon, 01 Jan 2024 10:00:00 +0000</pubDate>
+</item>
+</channel>
+</rss>`
+	feedConfig := runConfig(serveFeed(t, body), instance)
+	feedConfig.Template = "{{.Title}}"
+	feedConfig.Thread = true
+	feedConfig.ThreadUnlisted = true
+	feedConfig.Visibility = "public"
+	state := &config.FeedLastUpdate{}
+
+	if err := newRunPipeline(t, feedConfig, state).Run(); err != nil {
+		t.Fatal(err)
+	}
+	if len(instance.posted) < 2 {
+		t.Fatalf("posted %d statuses, want a thread", len(instance.posted))
+	}
+
+	// Each follow-up replies to the status before it
+	for i, form := range instance.posted {
+		wantReply, wantVisibility := strconv.Itoa(i), "unlisted"
+		if i == 0 {
+			wantReply, wantVisibility = "", "public"
+		}
+		if got := form.Get("in_reply_to_id"); got != wantReply {
+			t.Errorf("status %d replies to %q, want %q", i+1, got, wantReply)
+		}
+		if got := form.Get("visibility"); got != wantVisibility {
+			t.Errorf("status %d is %q, want %q", i+1, got, wantVisibility)
+		}
+	}
+
+	// The thread is recorded as one item
+	posted, ok := state.Posted["https://example.com/1"]
+	if !ok {
+		t.Fatal("thread not recorded as posted")
+	}
+	if posted.StatusID != "1" || len(posted.ThreadIDs) != len(instance.posted)-1 {
+		t.Errorf("recorded %s and %v for %d statuses", posted.StatusID, posted.ThreadIDs, len(instance.posted))
+	}
+}
+
+func TestRunCarriesYoungItems(t *testing.T) {
+	young := time.Now().UTC().Add(-10 * time.Minute).Format(time.RFC1123Z)
+	body := strings.Replace(testFeed, "<item>", `<item>
+<title>Fourth post</title>
+<link>https://example.com/4</link>
+<guid>https://example.com/4</guid>
+<pubDate>`+young+`</pubDate>
+</item>
+<item>`, 1)
+	instance := newFakeMastodon(t)
+	feedConfig := runConfig(serveFeed(t, body), instance)
+	feedConfig.MinAge = 60
+	state := &config.FeedLastUpdate{}
+
+	if err := newRunPipeline(t, feedConfig, state).Run(); err != nil {
+		t.Fatal(err)
+	}
+	if statuses := instance.statuses(); len(statuses) != 3 {
+		t.Fatalf("posted %d statuses, want the 3 old enough", len(statuses))
+	}
+	if _, ok := state.Seen["https://example.com/4"]; ok {
+		t.Error("young item seen before it was posted")
+	}
+	if state.ETag != "" {
+		t.Error("ETag kept while an item is carried")
+	}
+
+	// It goes out once it's old enough
+	feedConfig.MinAge = 5
+	if err := newRunPipeline(t, feedConfig, state).Run(); err != nil {
+		t.Fatal(err)
+	}
+	statuses := instance.statuses()
+	if len(statuses) != 4 || !strings.HasPrefix(statuses[3], "Fourth post") {
+		t.Fatalf("posted %q, want the fourth post last", statuses)
+	}
+}
+
+func TestRunFilterKeepsDeferredItems(t *testing.T) {
+	instance := newFakeMastodon(t)
+	feedConfig := runConfig(serveFeed(t, testFeed), instance)
+	feedConfig.FilterPublished = true
+	feedConfig.MaxPostsPerRun = 1
+	feedConfig.Filters = &config.Filters{Exclude: []config.FilterRule{{Field: "title", Contains: "Third"}}}
+	state := &config.FeedLastUpdate{}
+
+	// The filtered item is the newest, but the watermark stays behind the
+	// item deferred by the cap
+	for run := 1; run <= 3; run++ {
+		if err := newRunPipeline(t, feedConfig, state).Run(); err != nil {
+			var noUpdates *rssfeed.NoUpdates
+			if !errors.As(err, &noUpdates) {
+				t.Fatalf("run %d: %v", run, err)
+			}
+		}
+	}
+	statuses := instance.statuses()
+	if len(statuses) != 2 || !strings.HasPrefix(statuses[0], "First post") || !strings.HasPrefix(statuses[1], "Second post") {
+		t.Fatalf("posted %q, want the first and second posts", statuses)
+	}
+}
+
+func TestRunEditsChangedItems(t *testing.T) {
+	changed := strings.Replace(testFeed, "<title>First post</title>", "<title>First post, corrected</title>", 1)
+	instance := newFakeMastodon(t)
+	feedConfig := runConfig(serveFeeds(t, testFeed, changed, changed), instance)
+	feedConfig.EditUpdates = true
+	state := &config.FeedLastUpdate{}
+
+	if err := newRunPipeline(t, feedConfig, state).Run(); err != nil {
+		t.Fatal(err)
+	}
+	first := state.Posted["https://example.com/1"]
+	if first.StatusID == "" || first.Hash == "" {
+		t.Fatalf("first item's status not remembered: %+v", first)
+	}
+
+	// The changed item is edited, not posted again
+	if err := newRunPipeline(t, feedConfig, state).Run(); err != nil {
+		t.Fatal(err)
+	}
+	if len(instance.statuses()) != 3 {
+		t.Errorf("posted %d statuses, want 3", len(instance.statuses()))
+	}
+	edit, ok := instance.edited[first.StatusID]
+	if !ok || len(instance.edited) != 1 {
+		t.Fatalf("edited %v, want only status %s", instance.edited, first.StatusID)
+	}
+	if !strings.HasPrefix(edit.Get("status"), "First post, corrected") {
+		t.Errorf("edited to %q", edit.Get("status"))
+	}
+	if state.Posted["https://example.com/1"].Hash == first.Hash {
+		t.Error("hash not updated after the edit")
+	}
+
+	// Once edited it's left alone
+	instance.edited = nil
+	if err := newRunPipeline(t, feedConfig, state).Run(); err != nil {
+		t.Fatal(err)
+	}
+	if len(instance.edited) != 0 {
+		t.Errorf("edited %v again", instance.edited)
+	}
+}
+
+func TestChangedItemsEditWindow(t *testing.T) {
+	item := testItem("a", 1)
+	c := newTestPipeline(t, &config.FeedConfig{EditUpdates: true, EditWindow: "1h"})
+	c.state.Posted = map[string]config.PostedItem{
+		"a": {StatusID: "1", PostedAt: time.Now().UTC().Add(-2 * time.Hour), Hash: "old"},
+	}
+
+	feed := newParsedFeed(t, item)
+	items, err := c.changedItems(feed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 0 {
+		t.Errorf("changed items outside the edit window: %v", items)
+	}
+
+	c.feedConfig.EditWindow = "3h"
+	if items, err = c.changedItems(feed); err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 {
+		t.Errorf("got %d changed items inside the edit window, want 1", len(items))
+	}
+}
+
+func TestRunDeletesRemovedItems(t *testing.T) {
+	removed := strings.Replace(testFeed, `<item>
+<title>Second post</title>
+<link>https://example.com/2</link>
+<guid>https://example.com/2</guid>
+<pubDate>Tue, 02 Jan 2024 10:00:00 +0000</pubDate>
+</item>`, "", 1)
+	instance := newFakeMastodon(t)
+	feedConfig := runConfig(serveFeeds(t, testFeed, removed), instance)
+	feedConfig.DeleteRemoved = true
+	state := &config.FeedLastUpdate{}
+
+	if err := newRunPipeline(t, feedConfig, state).Run(); err != nil {
+		t.Fatal(err)
+	}
+	second := state.Posted["https://example.com/2"].StatusID
+
+	if err := newRunPipeline(t, feedConfig, state).Run(); err != nil {
+		t.Fatal(err)
+	}
+	if len(instance.deleted) != 1 || instance.deleted[0] != second {
+		t.Fatalf("deleted %v, want only status %s", instance.deleted, second)
+	}
+	if _, ok := state.Posted["https://example.com/2"]; ok {
+		t.Error("removed item's status still remembered")
+	}
+	// It stays seen, so it isn't posted again if it comes back
+	if _, ok := state.Seen["https://example.com/2"]; !ok {
+		t.Error("removed item no longer seen")
+	}
+}
+
+func TestDeleteAlreadyGone(t *testing.T) {
+	instance := newFakeMastodon(t)
+	instance.gone = map[string]bool{"7": true}
+	feedConfig := runConfig(serveFeed(t, testFeed), instance)
+	c := newRunPipeline(t, feedConfig, &config.FeedLastUpdate{
+		Posted: map[string]config.PostedItem{
+			"gone":  {StatusID: "7", PostedAt: time.Now().UTC()},
+			"there": {StatusID: "8", PostedAt: time.Now().UTC()},
+		},
+	})
+	client, err := c.newClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.delete(client, []string{"gone", "there"})
+	if len(c.state.Posted) != 0 {
+		t.Errorf("still remembered %v", c.state.Posted)
+	}
+	if len(c.records) != 2 {
+		t.Errorf("recorded %d deletions, want 2", len(c.records))
+	}
+}
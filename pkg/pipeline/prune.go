@@ -0,0 +1,197 @@
+package pipeline
+
+import (
+	"sort"
+	"time"
+
+	"github.com/mattn/go-mastodon"
+	"github.com/rmrfslashbin/mastopost/pkg/config"
+	"github.com/rmrfslashbin/mastopost/pkg/history"
+	"github.com/rmrfslashbin/mastopost/pkg/mastoclient"
+)
+
+// Prune deletes the feed's posts older than its expireafter setting, leaving
+// pinned posts alone. The posts remembered in the feed's state are deleted,
+// including scheduled posts once they're published, and with expirescan on,
+// every old status on the account. It stops at the first post that can't be
+// deleted, such as when the instance's rate limit is hit, and returns a
+// *PruneFailure. In dryrun mode nothing is deleted and the state is
+// untouched.
+func (c *Config) Prune() error {
+	c.records = nil
+	c.runID = history.NewRunID(time.Now())
+	if c.feedConfig.ExpireAfter <= 0 {
+		c.log.Info().
+			Str("feedname", c.feedName).
+			Msg("expireafter not set. nothing to prune")
+		return nil
+	}
+	cutoff := time.Now().UTC().AddDate(0, 0, -c.feedConfig.ExpireAfter)
+
+	client, err := c.newClient()
+	if err != nil {
+		return err
+	}
+
+	if !c.dryrun {
+		defer c.saveHistory()
+	}
+
+	pinned, err := client.PinnedStatuses()
+	if err != nil {
+		return err
+	}
+
+	posts, err := c.publishedPosts(client)
+	if err != nil {
+		return err
+	}
+
+	// Oldest first, so an interrupted prune leaves the newest posts.
+	// Scheduled posts still to be published are left out.
+	var ids []string
+	for id, posted := range posts {
+		if posted.StatusID != "" && posted.PostedAt.Before(cutoff) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return posts[ids[i]].PostedAt.Before(posts[ids[j]].PostedAt)
+	})
+
+	// The statuses already dealt with, so the scan doesn't count them twice
+	handled := make(map[mastodon.ID]bool)
+
+	deleted := 0
+	for _, id := range ids {
+		statuses := statusIDs(posts[id])
+		for _, status := range statuses {
+			handled[status] = true
+		}
+		ok, err := c.expire(client, id, statuses, pinned)
+		if err != nil {
+			return &PruneFailure{Deleted: deleted, Err: err}
+		}
+		if ok {
+			deleted++
+			if !c.dryrun {
+				delete(c.state.Posted, id)
+			}
+		}
+	}
+
+	if c.feedConfig.ExpireScan {
+		statuses, err := client.StatusesBefore(cutoff)
+		if err != nil {
+			return &PruneFailure{Deleted: deleted, Err: err}
+		}
+		for _, status := range statuses {
+			if handled[status.ID] {
+				continue
+			}
+			ok, err := c.expire(client, "", []mastodon.ID{status.ID}, pinned)
+			if err != nil {
+				return &PruneFailure{Deleted: deleted, Err: err}
+			}
+			if ok {
+				deleted++
+			}
+		}
+	}
+
+	c.log.Info().
+		Str("feedname", c.feedName).
+		Int("expireafter", c.feedConfig.ExpireAfter).
+		Msgf("pruned %d expired posts", deleted)
+
+	return nil
+}
+
+// publishedPosts returns the feed's posts with the statuses of the scheduled
+// posts whose time has passed looked up, so they expire like the rest. Those
+// not found once the instance has had PUBLISH_WINDOW to publish them were
+// cancelled or deleted, and are left out. Outside dryrun mode the state is
+// updated to match.
+func (c *Config) publishedPosts(client *mastoclient.Config) (map[string]config.PostedItem, error) {
+	now := time.Now().UTC()
+	posts := make(map[string]config.PostedItem, len(c.state.Posted))
+	var due []string
+	for id, posted := range c.state.Posted {
+		posts[id] = posted
+		if posted.StatusID == "" && posted.ScheduledAt != nil && posted.ScheduledAt.Before(now) {
+			due = append(due, id)
+		}
+	}
+	if len(due) == 0 {
+		return posts, nil
+	}
+
+	// Soonest first, so each one takes the earliest status left
+	sort.Slice(due, func(i, j int) bool {
+		return posts[due[i]].ScheduledAt.Before(*posts[due[j]].ScheduledAt)
+	})
+	for _, id := range due {
+		posted := posts[id]
+		status, err := c.publishedStatus(client, posted, posts)
+		if err != nil {
+			return nil, err
+		}
+		if status == nil {
+			if now.Sub(*posted.ScheduledAt) > mastoclient.PUBLISH_WINDOW {
+				delete(posts, id)
+				c.log.Info().
+					Str("feedname", c.feedName).
+					Str("itemId", id).
+					Str("scheduledId", posted.ScheduledID).
+					Msg("forgetting scheduled post that wasn't published")
+			}
+			continue
+		}
+		posted.StatusID = string(status.ID)
+		posted.PostedAt = status.CreatedAt.UTC()
+		posts[id] = posted
+	}
+
+	if !c.dryrun {
+		c.state.Posted = posts
+	}
+	return posts, nil
+}
+
+// expire deletes an expired status and the rest of its thread unless the
+// first status is pinned, reporting whether it was deleted. A status that's
+// already gone counts as deleted. itemID is the ID of the status's item, if
+// known.
+func (c *Config) expire(client *mastoclient.Config, itemID string, ids []mastodon.ID, pinned map[mastodon.ID]bool) (bool, error) {
+	id := ids[0]
+	if pinned[id] {
+		c.log.Info().
+			Str("feedname", c.feedName).
+			Str("statusId", string(id)).
+			Msg("skipping pinned post")
+		return false, nil
+	}
+
+	if c.dryrun {
+		c.log.Info().
+			Str("feedname", c.feedName).
+			Str("statusId", string(id)).
+			Msg("dryrun mode. not deleting expired post")
+		return true, nil
+	}
+
+	if err := client.DeleteThread(ids); err != nil {
+		return false, err
+	}
+
+	record := history.Record{ItemID: itemID, StatusID: string(id), Outcome: history.OUTCOME_EXPIRED}
+	for _, reply := range ids[1:] {
+		record.ThreadIDs = append(record.ThreadIDs, string(reply))
+	}
+	c.addRecord(record)
+	c.log.Info().
+		Str("feedname", c.feedName).
+		Str("statusId", string(id)).
+		Msg("deleted expired post")
+	return true, nil
+}
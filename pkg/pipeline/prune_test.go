@@ -0,0 +1,177 @@
+package pipeline
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rmrfslashbin/mastopost/pkg/config"
+	"github.com/rs/zerolog"
+)
+
+// pruneSetup returns a feed config that expires posts after a day, on an
+// instance whose account has statuses posted the given number of days ago
+func pruneSetup(t *testing.T, ages ...int) (*fakeMastodon, *config.FeedConfig) {
+	t.Helper()
+	instance := newFakeMastodon(t)
+	now := time.Now().UTC()
+	for i, age := range ages {
+		instance.account = append(instance.account, accountStatus{
+			ID:        fmt.Sprint(i + 1),
+			CreatedAt: now.AddDate(0, 0, -age),
+		})
+	}
+	feedConfig := runConfig(serveFeed(t, testFeed), instance)
+	feedConfig.ExpireAfter = 1
+	return instance, feedConfig
+}
+
+func TestPrune(t *testing.T) {
+	instance, feedConfig := pruneSetup(t, 5, 4, 3, 0)
+	instance.pinned = map[string]bool{"2": true}
+	now := time.Now().UTC()
+	state := &config.FeedLastUpdate{
+		Posted: map[string]config.PostedItem{
+			"old":    {StatusID: "1", PostedAt: now.AddDate(0, 0, -5)},
+			"pinned": {StatusID: "2", PostedAt: now.AddDate(0, 0, -4)},
+			"thread": {StatusID: "3", ThreadIDs: []string{"9"}, PostedAt: now.AddDate(0, 0, -3)},
+			"new":    {StatusID: "4", PostedAt: now},
+		},
+	}
+
+	if err := newRunPipeline(t, feedConfig, state).Prune(); err != nil {
+		t.Fatal(err)
+	}
+	// The thread's last reply goes first
+	if got := strings.Join(instance.deleted, ","); got != "1,9,3" {
+		t.Errorf("deleted %s, want 1,9,3", got)
+	}
+	for _, id := range []string{"pinned", "new"} {
+		if _, ok := state.Posted[id]; !ok {
+			t.Errorf("forgot %s", id)
+		}
+	}
+	if len(state.Posted) != 2 {
+		t.Errorf("remembered %d posts, want 2", len(state.Posted))
+	}
+}
+
+func TestPruneScan(t *testing.T) {
+	instance, feedConfig := pruneSetup(t, 5, 4, 3, 0)
+	feedConfig.ExpireScan = true
+	state := &config.FeedLastUpdate{
+		Posted: map[string]config.PostedItem{
+			"known": {StatusID: "2", PostedAt: time.Now().UTC().AddDate(0, 0, -4)},
+		},
+	}
+
+	if err := newRunPipeline(t, feedConfig, state).Prune(); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(instance.deleted, ","); got != "2,1,3" {
+		t.Errorf("deleted %s, want 2,1,3", got)
+	}
+}
+
+func TestPruneScheduled(t *testing.T) {
+	instance, feedConfig := pruneSetup(t)
+	now := time.Now().UTC()
+	at := func(d time.Duration) *time.Time {
+		scheduledAt := now.Add(d)
+		return &scheduledAt
+	}
+	instance.account = []accountStatus{
+		{ID: "1", CreatedAt: now.AddDate(0, 0, -5).Add(time.Minute)},
+		{ID: "2", CreatedAt: now.Add(-time.Hour)},
+	}
+	state := &config.FeedLastUpdate{
+		Posted: map[string]config.PostedItem{
+			"expired":   {ScheduledID: "s1", ScheduledAt: at(-5 * 24 * time.Hour), PostedAt: now.AddDate(0, 0, -6)},
+			"published": {ScheduledID: "s2", ScheduledAt: at(-time.Hour), PostedAt: now.AddDate(0, 0, -1)},
+			"cancelled": {ScheduledID: "s3", ScheduledAt: at(-3 * 24 * time.Hour), PostedAt: now.AddDate(0, 0, -4)},
+			"late":      {ScheduledID: "s4", ScheduledAt: at(-2 * time.Minute), PostedAt: now.AddDate(0, 0, -1)},
+			"pending":   {ScheduledID: "s5", ScheduledAt: at(time.Hour), PostedAt: now},
+		},
+	}
+
+	if err := newRunPipeline(t, feedConfig, state).Prune(); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(instance.deleted, ","); got != "1" {
+		t.Errorf("deleted %s, want 1", got)
+	}
+	for _, id := range []string{"expired", "cancelled"} {
+		if _, ok := state.Posted[id]; ok {
+			t.Errorf("still remembers %s", id)
+		}
+	}
+	if posted := state.Posted["published"]; posted.StatusID != "2" {
+		t.Errorf("published post has status %q, want 2", posted.StatusID)
+	}
+	for _, id := range []string{"late", "pending"} {
+		if posted, ok := state.Posted[id]; !ok || posted.StatusID != "" {
+			t.Errorf("%s is %+v, want it still scheduled", id, posted)
+		}
+	}
+}
+
+func TestPruneDryrunCountsOnce(t *testing.T) {
+	_, feedConfig := pruneSetup(t, 5, 4, 0)
+	feedConfig.ExpireScan = true
+	now := time.Now().UTC()
+	state := &config.FeedLastUpdate{
+		Posted: map[string]config.PostedItem{
+			"a": {StatusID: "1", PostedAt: now.AddDate(0, 0, -5)},
+			"b": {StatusID: "2", PostedAt: now.AddDate(0, 0, -4)},
+		},
+	}
+
+	var logs bytes.Buffer
+	log := zerolog.New(&logs)
+	c, err := New(WithLogger(&log), WithFeedConfig(feedConfig), WithState(state), WithDryrun(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Prune(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(logs.String(), "pruned 2 expired posts") {
+		t.Errorf("dryrun didn't count 2 posts: %s", logs.String())
+	}
+	if len(state.Posted) != 2 {
+		t.Error("dryrun changed the state")
+	}
+}
+
+func TestPruneNotSet(t *testing.T) {
+	instance, feedConfig := pruneSetup(t, 5)
+	feedConfig.ExpireAfter = 0
+	feedConfig.ExpireScan = true
+
+	if err := newRunPipeline(t, feedConfig, &config.FeedLastUpdate{}).Prune(); err != nil {
+		t.Fatal(err)
+	}
+	if len(instance.deleted) != 0 {
+		t.Errorf("deleted %v without expireafter", instance.deleted)
+	}
+}
+
+func TestRunKeepsPostedUntilPruned(t *testing.T) {
+	instance := newFakeMastodon(t)
+	feedConfig := runConfig(serveFeed(t, testFeed), instance)
+	feedConfig.ExpireAfter = 30
+	state := &config.FeedLastUpdate{
+		Posted: map[string]config.PostedItem{
+			"https://example.com/gone": {StatusID: "99", PostedAt: time.Now().UTC()},
+		},
+	}
+
+	if err := newRunPipeline(t, feedConfig, state).Run(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := state.Posted["https://example.com/gone"]; !ok {
+		t.Error("forgot an unseen item's status before it was pruned")
+	}
+}
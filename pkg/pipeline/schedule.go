@@ -0,0 +1,81 @@
+package pipeline
+
+import (
+	"time"
+
+	"github.com/rmrfslashbin/mastopost/pkg/mastoclient"
+	"github.com/rmrfslashbin/mastopost/pkg/utils"
+)
+
+// schedule hands out the times a run's items are scheduled at
+type schedule struct {
+	spacing time.Duration
+	at      time.Time
+	window  *utils.Window
+}
+
+// newSchedule sets up the schedule for a run's items. Without a schedule
+// spacing it's nil and every item is posted straight away. Otherwise the
+// first item follows the account's last scheduled status by the spacing, or
+// goes out straight away if nothing is scheduled. Items are only scheduled
+// inside the posting window.
+func (c *Config) newSchedule(client *mastoclient.Config, count int) (*schedule, error) {
+	spacing, err := c.scheduleSpacing()
+	if err != nil {
+		return nil, err
+	}
+	if spacing <= 0 || count == 0 {
+		return nil, nil
+	}
+
+	pending, err := client.ScheduledStatuses()
+	if err != nil {
+		return nil, err
+	}
+
+	window, err := utils.NewWindow(c.feedConfig.PostingWindow)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &schedule{spacing: spacing, window: window}
+	if len(pending) > 0 {
+		s.at = pending[len(pending)-1].ScheduledAt.Add(spacing)
+	}
+	return s, nil
+}
+
+// next returns the time to schedule an item made of this many statuses at,
+// or nil to post it straight away. Only single statuses are scheduled.
+func (s *schedule) next(statuses int) *time.Time {
+	if s == nil || statuses != 1 {
+		return nil
+	}
+
+	now := time.Now()
+	if !s.at.After(now) {
+		s.at = now.Add(s.spacing)
+		return nil
+	}
+
+	// Mastodon won't schedule a status that's due too soon
+	at := s.at
+	if lead := now.Add(mastoclient.MIN_SCHEDULE_LEAD); at.Before(lead) {
+		at = lead
+	}
+	at = s.window.Next(at)
+	s.at = at.Add(s.spacing)
+	return &at
+}
+
+// scheduleSpacing returns the configured time between scheduled posts
+func (c *Config) scheduleSpacing() (time.Duration, error) {
+	if c.feedConfig.ScheduleSpacing == "" {
+		return 0, nil
+	}
+	spacing, err := time.ParseDuration(c.feedConfig.ScheduleSpacing)
+	if err != nil {
+		return 0, &utils.InvalidSetting{Setting: "schedulespacing", Err: err}
+	}
+	return spacing, nil
+}
@@ -0,0 +1,237 @@
+package pipeline
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rmrfslashbin/mastopost/pkg/config"
+	"github.com/rmrfslashbin/mastopost/pkg/mastoclient"
+	"github.com/rmrfslashbin/mastopost/pkg/utils"
+)
+
+func TestScheduleNext(t *testing.T) {
+	var none *schedule
+	if at := none.next(1); at != nil {
+		t.Errorf("no schedule scheduled an item at %v", at)
+	}
+
+	// Nothing pending: the first item goes out now, the rest are spaced
+	// out after it
+	start := time.Now()
+	s := &schedule{spacing: time.Hour}
+	if at := s.next(1); at != nil {
+		t.Fatalf("first item scheduled at %v, want it posted now", at)
+	}
+	for i := 1; i <= 2; i++ {
+		at := s.next(1)
+		want := start.Add(time.Duration(i) * time.Hour)
+		if at == nil || at.Sub(want) < 0 || at.Sub(want) > time.Minute {
+			t.Errorf("item %d scheduled at %v, want %v", i+1, at, want)
+		}
+	}
+
+	// Threads go out straight away and don't take a slot
+	before := s.at
+	if at := s.next(3); at != nil || !s.at.Equal(before) {
+		t.Errorf("thread scheduled at %v", at)
+	}
+}
+
+func TestScheduleNextLead(t *testing.T) {
+	// A slot due too soon is moved back to the earliest Mastodon allows
+	s := &schedule{spacing: time.Minute, at: time.Now().Add(time.Minute)}
+	at := s.next(1)
+	if at == nil {
+		t.Fatal("item posted now, want it scheduled")
+	}
+	if lead := time.Until(*at); lead < mastoclient.MIN_SCHEDULE_LEAD-time.Minute || lead > mastoclient.MIN_SCHEDULE_LEAD {
+		t.Errorf("scheduled %v ahead, want %v", lead, mastoclient.MIN_SCHEDULE_LEAD)
+	}
+	if !s.at.Equal(at.Add(time.Minute)) {
+		t.Errorf("next slot is %v, want a spacing after %v", s.at, at)
+	}
+}
+
+func TestScheduleNextWindow(t *testing.T) {
+	// Slots are moved to when the window next opens
+	now := time.Now().UTC()
+	opens := time.Date(now.Year(), now.Month(), now.Day()+3, 0, 0, 0, 0, time.UTC)
+	window, err := utils.NewWindow(&config.PostingWindow{Days: []string{strings.ToLower(opens.Weekday().String()[:3])}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &schedule{spacing: time.Hour, at: now.Add(time.Hour), window: window}
+	if at := s.next(1); at == nil || !at.Equal(opens) {
+		t.Errorf("scheduled at %v, want %v", at, opens)
+	}
+	if want := opens.Add(time.Hour); !s.at.Equal(want) {
+		t.Errorf("next slot is %v, want %v", s.at, want)
+	}
+}
+
+func TestScheduleSpacingInvalid(t *testing.T) {
+	c := newTestPipeline(t, &config.FeedConfig{ScheduleSpacing: "soon"})
+	_, err := c.scheduleSpacing()
+	var invalid *utils.InvalidSetting
+	if !errors.As(err, &invalid) || invalid.Setting != "schedulespacing" {
+		t.Errorf("scheduleSpacing returned %v, want an invalid schedulespacing", err)
+	}
+}
+
+func TestRunSchedulesItems(t *testing.T) {
+	instance := newFakeMastodon(t)
+	last := time.Now().UTC().Add(2 * time.Hour).Truncate(time.Second)
+	instance.scheduled = []scheduledStatus{{ID: "s0", ScheduledAt: last}}
+	feedConfig := runConfig(serveFeed(t, testFeed), instance)
+	feedConfig.ScheduleSpacing = "1h"
+	state := &config.FeedLastUpdate{}
+
+	if err := newRunPipeline(t, feedConfig, state).Run(); err != nil {
+		t.Fatal(err)
+	}
+	if len(instance.posted) != 0 {
+		t.Errorf("posted %d statuses straight away, want them all scheduled", len(instance.posted))
+	}
+
+	// Oldest first, each an hour after the last scheduled status
+	for i, id := range []string{"https://example.com/1", "https://example.com/2", "https://example.com/3"} {
+		posted, ok := state.Posted[id]
+		if !ok {
+			t.Errorf("%s not recorded", id)
+			continue
+		}
+		want := last.Add(time.Duration(i+1) * time.Hour)
+		if posted.StatusID != "" || posted.ScheduledID == "" || posted.ScheduledAt == nil || !posted.ScheduledAt.Equal(want) {
+			t.Errorf("%s recorded as %+v, want scheduled at %v", id, posted, want)
+		}
+		if _, ok := state.Seen[id]; !ok {
+			t.Errorf("%s scheduled but not seen", id)
+		}
+	}
+}
+
+func TestRunCancelsRemovedItems(t *testing.T) {
+	removed := `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0">
+<channel>
+<title>Test feed</title>
+<item>
+<title>Third post</title>
+<link>https://example.com/3</link>
+<guid>https://example.com/3</guid>
+<pubDate>Wed, 03 Jan 2024 10:00:00 +0000</pubDate>
+</item>
+</channel>
+</rss>`
+
+	// A scheduled status that's already gone was published, and its status
+	// is deleted instead, or if that can't be found it's kept
+	for _, gone := range []string{"", "published", "missing"} {
+		instance := newFakeMastodon(t)
+		feedConfig := runConfig(serveFeeds(t, testFeed, removed), instance)
+		feedConfig.ScheduleSpacing = "1h"
+		feedConfig.DeleteRemoved = true
+		state := &config.FeedLastUpdate{}
+
+		// The first item goes out now and the other two are scheduled
+		if err := newRunPipeline(t, feedConfig, state).Run(); err != nil {
+			t.Fatal(err)
+		}
+		if len(instance.posted) != 1 || len(instance.scheduled) != 2 {
+			t.Fatalf("posted %d and scheduled %d, want 1 and 2", len(instance.posted), len(instance.scheduled))
+		}
+		first := state.Posted["https://example.com/1"].StatusID
+		second := state.Posted["https://example.com/2"].ScheduledID
+		wantCancelled := []string{second}
+		wantDeleted := []string{first}
+		if gone != "" {
+			published := instance.scheduled[0]
+			instance.scheduled = instance.scheduled[1:]
+			wantCancelled = nil
+			if gone == "published" {
+				instance.account = append(instance.account,
+					accountStatus{ID: "8", CreatedAt: published.ScheduledAt.Add(-time.Hour)},
+					accountStatus{ID: "9", CreatedAt: published.ScheduledAt.Add(30 * time.Second)},
+				)
+				wantDeleted = append(wantDeleted, "9")
+			}
+		}
+
+		if err := newRunPipeline(t, feedConfig, state).Run(); err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(instance.cancelled, ",") != strings.Join(wantCancelled, ",") {
+			t.Errorf("gone %q: cancelled %v, want %v", gone, instance.cancelled, wantCancelled)
+		}
+		if strings.Join(instance.deleted, ",") != strings.Join(wantDeleted, ",") {
+			t.Errorf("gone %q: deleted %v, want %v", gone, instance.deleted, wantDeleted)
+		}
+		if _, ok := state.Posted["https://example.com/1"]; ok {
+			t.Errorf("gone %q: deleted post still remembered", gone)
+		}
+		if _, ok := state.Posted["https://example.com/2"]; ok != (gone == "missing") {
+			t.Errorf("gone %q: scheduled post remembered %v, want %v", gone, ok, gone == "missing")
+		}
+		if _, ok := state.Posted["https://example.com/3"]; !ok {
+			t.Errorf("gone %q: item still in the feed forgotten", gone)
+		}
+	}
+}
+
+func TestRunHoldsItemsOutsideWindow(t *testing.T) {
+	third := `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0">
+<channel>
+<title>Test feed</title>
+<item>
+<title>Third post</title>
+<link>https://example.com/3</link>
+<guid>https://example.com/3</guid>
+<pubDate>Wed, 03 Jan 2024 10:00:00 +0000</pubDate>
+</item>
+</channel>
+</rss>`
+	instance := newFakeMastodon(t)
+	feedConfig := runConfig(serveFeeds(t, testFeed, third), instance)
+	// Closed all day today, even if midnight passes during the test
+	closed := strings.ToLower(time.Now().UTC().AddDate(0, 0, 3).Weekday().String()[:3])
+	feedConfig.PostingWindow = &config.PostingWindow{Days: []string{closed}}
+	state := &config.FeedLastUpdate{}
+
+	if err := newRunPipeline(t, feedConfig, state).Run(); err != nil {
+		t.Fatal(err)
+	}
+	if len(instance.posted) != 0 {
+		t.Fatalf("posted %d statuses outside the window", len(instance.posted))
+	}
+	if len(state.Held) != 3 {
+		t.Errorf("held %d items, want 3", len(state.Held))
+	}
+	if len(state.Seen) != 0 {
+		t.Errorf("%d held items seen", len(state.Seen))
+	}
+	if state.ETag != "" || state.LastModified != "" {
+		t.Error("fetch validators kept while items are held")
+	}
+
+	// Once the window opens, the held items go out, including those that
+	// dropped out of the feed
+	feedConfig.PostingWindow = nil
+	if err := newRunPipeline(t, feedConfig, state).Run(); err != nil {
+		t.Fatal(err)
+	}
+	statuses := instance.statuses()
+	if len(statuses) != 3 {
+		t.Fatalf("posted %d statuses, want the 3 held", len(statuses))
+	}
+	for i, want := range []string{"First post", "Second post", "Third post"} {
+		if !strings.HasPrefix(statuses[i], want) {
+			t.Errorf("status %d is %q, want %q", i+1, statuses[i], want)
+		}
+	}
+	if len(state.Held) != 0 {
+		t.Errorf("%d items still held after they were posted", len(state.Held))
+	}
+}
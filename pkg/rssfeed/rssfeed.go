@@ -1,7 +1,16 @@
 package rssfeed
 
 import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
 	"net/url"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/mmcdole/gofeed"
@@ -27,6 +36,13 @@ type ConfigError struct {
 	SetWith string
 }
 
+// ItemError is returned for a feed item that can't be identified or posted
+type ItemError struct {
+	Err   error
+	Msg   string
+	Index int
+}
+
 func (e *NoUpdates) Error() string {
 	if e.Msg == "" {
 		e.Msg = "no updates"
@@ -63,17 +79,70 @@ func (e *ConfigError) Error() string {
 	return e.Msg
 }
 
+// Error returns the error message
+func (e *ItemError) Error() string {
+	msg := e.Msg
+	if msg == "" {
+		msg = "unusable feed item"
+	}
+	msg += fmt.Sprintf(" (item %d)", e.Index)
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
 type NewItems *gofeed.Item
 
+const (
+	// DEFAULT_SEEN_RETENTION is how long an item is remembered after it was last seen in the feed
+	DEFAULT_SEEN_RETENTION = 30 * 24 * time.Hour
+
+	// DEFAULT_SEEN_MAX is the maximum number of items remembered in the seen set
+	DEFAULT_SEEN_MAX = 1000
+
+	// DEFAULT_TIMEOUT is the default time limit for fetching the feed
+	DEFAULT_TIMEOUT = 30 * time.Second
+
+	// DEFAULT_USER_AGENT is the default User-Agent sent when fetching the feed
+	DEFAULT_USER_AGENT = "mastopost (+https://github.com/rmrfslashbin/mastopost)"
+
+	// XML_LANG is the key in an item's Custom map holding its xml:lang
+	XML_LANG = "xml:lang"
+
+	// TOMBSTONES_NS is the namespace of Atom deleted-entry tombstones (RFC 6721)
+	TOMBSTONES_NS = "http://purl.org/atompub/tombstones/1.0"
+)
+
 // Options for the weather query
 type Option func(c *Config)
 
 // Config for the weather query
 type Config struct {
-	log           *zerolog.Logger
-	url           *url.URL
-	lastUpdated   *time.Time
-	lastPublished *time.Time
+	log             *zerolog.Logger
+	url             *url.URL
+	lastUpdated     *time.Time
+	lastPublished   *time.Time
+	etag            string
+	lastModified    string
+	seen            map[string]time.Time
+	seenRetention   time.Duration
+	seenMax         int
+	filterPublished bool
+	minAge          time.Duration
+	firstSeen       map[string]time.Time
+	timeout         time.Duration
+	userAgent       string
+	headers         map[string]string
+	username        string
+	password        string
+	proxy           *url.URL
+	firstRun        bool
+	pending         map[string]time.Time
+	carried         map[string]bool
+	current         map[string]bool
+	deleted         []string
+	feed            *gofeed.Feed
 }
 
 // NewConfig creates a new Config
@@ -85,14 +154,34 @@ func New(opts ...Option) (*Config, error) {
 		opt(c)
 	}
 
+	epoch := time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
 	if c.lastUpdated == nil {
-		epoch := time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
 		c.lastUpdated = &epoch
 	}
 
 	if c.lastPublished == nil {
-		epoch := time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
-		c.lastPublished = &epoch
+		lastPublished := epoch
+		c.lastPublished = &lastPublished
+	}
+
+	if c.seenRetention == 0 {
+		c.seenRetention = DEFAULT_SEEN_RETENTION
+	}
+
+	if c.seenMax == 0 {
+		c.seenMax = DEFAULT_SEEN_MAX
+	}
+
+	// Nothing has been seen or published yet, so this is the job's first run
+	c.firstRun = len(c.seen) == 0 && !c.lastPublished.After(epoch)
+
+	if c.timeout == 0 {
+		c.timeout = DEFAULT_TIMEOUT
+	}
+
+	if c.userAgent == "" {
+		c.userAgent = DEFAULT_USER_AGENT
 	}
 
 	return c, nil
@@ -126,6 +215,99 @@ func WithLastPublished(t *time.Time) Option {
 	}
 }
 
+// WithETag sets the ETag validator from the previous fetch of the RSS feed
+func WithETag(etag string) Option {
+	return func(c *Config) {
+		c.etag = etag
+	}
+}
+
+// WithLastModified sets the Last-Modified validator from the previous fetch of the RSS feed
+func WithLastModified(lastModified string) Option {
+	return func(c *Config) {
+		c.lastModified = lastModified
+	}
+}
+
+// WithSeen sets the seen set from the previous run, keyed by ItemID with the time the item was last seen
+func WithSeen(seen map[string]time.Time) Option {
+	return func(c *Config) {
+		c.seen = seen
+	}
+}
+
+// WithFirstSeen sets the times undated items were first seen on a previous run
+func WithFirstSeen(firstSeen map[string]time.Time) Option {
+	return func(c *Config) {
+		c.firstSeen = firstSeen
+	}
+}
+
+// WithSeenRetention sets how long an item is remembered after it drops out of the feed
+func WithSeenRetention(d time.Duration) Option {
+	return func(c *Config) {
+		c.seenRetention = d
+	}
+}
+
+// WithSeenMax sets the maximum number of items remembered in the seen set
+func WithSeenMax(max int) Option {
+	return func(c *Config) {
+		c.seenMax = max
+	}
+}
+
+// WithPublishedFilter also requires new items to be published after the last published time
+func WithPublishedFilter(filter bool) Option {
+	return func(c *Config) {
+		c.filterPublished = filter
+	}
+}
+
+// WithMinAge holds back new items until they were published at least this
+// long ago. Younger items are carried forward to a later Parse.
+func WithMinAge(minAge time.Duration) Option {
+	return func(c *Config) {
+		c.minAge = minAge
+	}
+}
+
+// WithTimeout sets the time limit for fetching the RSS feed
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Config) {
+		c.timeout = timeout
+	}
+}
+
+// WithUserAgent sets the User-Agent sent when fetching the RSS feed
+func WithUserAgent(userAgent string) Option {
+	return func(c *Config) {
+		c.userAgent = userAgent
+	}
+}
+
+// WithHeaders sets extra HTTP headers sent when fetching the RSS feed
+func WithHeaders(headers map[string]string) Option {
+	return func(c *Config) {
+		c.headers = headers
+	}
+}
+
+// WithBasicAuth sets the HTTP basic auth credentials for the RSS feed
+func WithBasicAuth(username string, password string) Option {
+	return func(c *Config) {
+		c.username = username
+		c.password = password
+	}
+}
+
+// WithProxy sets the HTTP proxy used to fetch the RSS feed
+func WithProxy(proxy *url.URL) Option {
+	return func(c *Config) {
+		c.proxy = proxy
+	}
+}
+
 // GetURL returns the URL for the RSS feed
 func (c *Config) GetURL() *url.URL {
 	return c.url
@@ -141,6 +323,101 @@ func (c *Config) GetLastPublished() *time.Time {
 	return c.lastPublished
 }
 
+// GetETag returns the ETag validator sent by the server on the last fetch
+func (c *Config) GetETag() string {
+	return c.etag
+}
+
+// GetLastModified returns the Last-Modified validator sent by the server on the last fetch
+func (c *Config) GetLastModified() string {
+	return c.lastModified
+}
+
+// GetSeen returns the seen set, keyed by ItemID with the time the item was last seen
+func (c *Config) GetSeen() map[string]time.Time {
+	return c.seen
+}
+
+// GetFirstSeen returns the times undated items still in the feed were first seen
+func (c *Config) GetFirstSeen() map[string]time.Time {
+	return c.firstSeen
+}
+
+// GetFeed returns the feed fetched by the last Parse
+func (c *Config) GetFeed() *gofeed.Feed {
+	return c.feed
+}
+
+// GetDeleted returns the IDs of the entries the feed marked as deleted with
+// at:deleted-entry tombstones on the last Parse
+func (c *Config) GetDeleted() []string {
+	return c.deleted
+}
+
+// InFeed reports whether the item with the given ID was in the feed on the last Parse
+func (c *Config) InFeed(id string) bool {
+	return c.current[id]
+}
+
+// GetCurrent returns the IDs of the items in the feed on the last Parse
+func (c *Config) GetCurrent() map[string]bool {
+	return c.current
+}
+
+// IsFirstRun reports whether nothing has been seen or published for the feed yet
+func (c *Config) IsFirstRun() bool {
+	return c.firstRun
+}
+
+// Pending returns the number of new items from the last Parse not yet
+// marked as seen, including the items carried forward for being too young
+func (c *Config) Pending() int {
+	return len(c.pending)
+}
+
+// Carried returns the number of new items from the last Parse carried
+// forward for being younger than the minimum age
+func (c *Config) Carried() int {
+	return len(c.carried)
+}
+
+// AddPending adds an item that's no longer in the feed, such as one held
+// over from an earlier run, to the new items not yet marked as seen
+func (c *Config) AddPending(item NewItems) {
+	if c.pending == nil {
+		c.pending = make(map[string]time.Time)
+	}
+	var published time.Time
+	if item.PublishedParsed != nil {
+		published = *item.PublishedParsed
+	}
+	c.pending[ItemID(item)] = published
+}
+
+// MarkSeen records an item returned by Parse as handled so it's not returned
+// again, and moves the last published time up to the item's published time.
+// The last published time never reaches an item still pending, such as one
+// deferred, failed, held or carried forward, so it's still new on the next
+// Parse even with the published filter on.
+func (c *Config) MarkSeen(item NewItems) {
+	id := ItemID(item)
+	c.seen[id] = time.Now().UTC()
+	delete(c.pending, id)
+
+	if item.PublishedParsed == nil {
+		return
+	}
+	for _, pending := range c.pending {
+		if !item.PublishedParsed.Before(pending) {
+			return
+		}
+	}
+	if item.PublishedParsed.After(*c.lastPublished) {
+		published := *item.PublishedParsed
+		c.lastPublished = &published
+	}
+}
+
 // SetLastUpdated sets the last updated time for the RSS feed
 func (c *Config) SetLastUpdated(timestamp *time.Time) {
 	c.lastUpdated = timestamp
@@ -151,56 +428,369 @@ func (c *Config) SetLastPublished(timestamp *time.Time) {
 	c.lastPublished = timestamp
 }
 
-// Parse the RSS feed
+// Parse fetches the RSS feed and returns the items that haven't been seen.
+// They stay new until they're marked with MarkSeen.
 func (c *Config) Parse() ([]NewItems, error) {
 	// ensure we have a URL
 	if c.url == nil {
 		return nil, &ConfigError{Item: "url", SetWith: "WithURL"}
 	}
 
+	// Build a conditional request using the validators from the last fetch
+	req, err := http.NewRequest(http.MethodGet, c.url.String(), nil)
+	if err != nil {
+		return nil, &ParserError{Err: err, Url: c.url}
+	}
+	req.Header.Set("User-Agent", c.userAgent)
+	for name, value := range c.headers {
+		req.Header.Set(name, value)
+	}
+	if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+	if c.etag != "" {
+		req.Header.Set("If-None-Match", c.etag)
+	}
+	if c.lastModified != "" {
+		req.Header.Set("If-Modified-Since", c.lastModified)
+	}
+
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, &ParserError{Err: err, Url: c.url}
+	}
+	defer resp.Body.Close()
+
+	// The feed hasn't changed since the last fetch
+	if resp.StatusCode == http.StatusNotModified {
+		c.log.Info().
+			Str("etag", c.etag).
+			Str("lastModified", c.lastModified).
+			Msg("feed not modified")
+		return nil, &NoUpdates{Url: c.url}
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, &ParserError{Err: fmt.Errorf("unexpected HTTP status %s", resp.Status), Url: c.url}
+	}
+
+	// Keep the validators for the next fetch
+	c.etag = resp.Header.Get("ETag")
+	c.lastModified = resp.Header.Get("Last-Modified")
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, &ParserError{Err: err, Url: c.url}
+	}
+
 	// Set up the RSS parser
 	fp := gofeed.NewParser()
 	// Parse the RSS feed
-	feed, err := fp.ParseURL(c.url.String())
+	feed, err := fp.Parse(bytes.NewReader(body))
 	if err != nil {
 		return nil, &ParserError{Err: err, Url: c.url}
 	}
+	setItemLanguages(feed, body)
+
+	c.feed = feed
+	c.deleted = findTombstones(body)
+
+	// Fall back to the publish date or the newest item when the feed has no update date
+	feedUpdated := feedTime(feed)
 
 	// Log info about the feed
-	c.log.Info().
+	event := c.log.Info().
 		Str("title", feed.Title).
 		Str("link", feed.Link).
-		Str("updatedParsed", feed.UpdatedParsed.String()).
 		Int("items", len(feed.Items)).
 		Str("lastUpdated", c.lastUpdated.String()).
-		Str("lastPublished", c.lastPublished.String()).
-		Msg("parsed RSS feed")
+		Str("lastPublished", c.lastPublished.String())
+	if feedUpdated != nil {
+		event = event.Str("updatedParsed", feedUpdated.String())
+	}
+	event.Msg("parsed RSS feed")
 
-	if c.lastUpdated.After(*feed.UpdatedParsed) {
-		c.log.Info().Msg("No updates")
-		return nil, &NoUpdates{Url: c.url}
+	if feedUpdated != nil {
+		if c.lastUpdated.After(*feedUpdated) {
+			c.log.Info().Msg("No updates")
+			return nil, &NoUpdates{Url: c.url}
+		}
+		c.lastUpdated = feedUpdated
+	}
+
+	// Without a seen set (new job or state from an older release) the
+	// published watermark is the only thing stopping a full re-post
+	filterPublished := c.filterPublished || len(c.seen) == 0
+	if c.seen == nil {
+		c.seen = make(map[string]time.Time)
 	}
-	c.lastUpdated = feed.UpdatedParsed
+
+	now := time.Now().UTC()
+	current := make(map[string]bool, len(feed.Items))
+	firstSeen := make(map[string]time.Time)
 
 	var newItems []NewItems
 
-	var lastPublished time.Time
-	for _, item := range feed.Items {
-		if item.PublishedParsed.After(*c.lastPublished) {
+	c.pending = make(map[string]time.Time)
+	c.carried = make(map[string]bool)
+	for i, item := range feed.Items {
+		if err := checkItem(item); err != nil {
+			c.log.Warn().Err(&ItemError{Err: err, Index: i}).Msg("skipping item")
+			continue
+		}
+
+		id := ItemID(item)
+		current[id] = true
+
+		// Give undated items a date: the feed's, or the time they were first seen
+		if item.PublishedParsed == nil {
+			switch {
+			case item.UpdatedParsed != nil:
+				item.PublishedParsed = item.UpdatedParsed
+			case feedUpdated != nil:
+				item.PublishedParsed = feedUpdated
+			default:
+				t, ok := c.firstSeen[id]
+				if !ok {
+					t = now
+				}
+				firstSeen[id] = t
+				item.PublishedParsed = &t
+			}
+			item.Published = item.PublishedParsed.Format(time.RFC1123Z)
+		}
+
+		if _, seen := c.seen[id]; seen {
+			c.seen[id] = now
+			continue
+		}
+
+		if filterPublished && !item.PublishedParsed.After(*c.lastPublished) {
 			c.log.Debug().
+				Str("id", id).
 				Str("title", item.Title).
-				Str("link", item.Link).
 				Str("publishedParsed", item.PublishedParsed.String()).
-				Msg("New item")
+				Msg("skipping item published before the watermark")
+			c.seen[id] = now
+			continue
+		}
 
-			newItems = append(newItems, item)
-			if item.PublishedParsed.After(lastPublished) {
-				lastPublished = *item.PublishedParsed
+		// Too young to post yet. It stays pending and unseen, and holds
+		// the published watermark back, so it's new again next time.
+		if c.minAge > 0 && item.PublishedParsed.After(now.Add(-c.minAge)) {
+			c.log.Debug().
+				Str("id", id).
+				Str("title", item.Title).
+				Str("publishedParsed", item.PublishedParsed.String()).
+				Msg("carrying item younger than the minimum age")
+			c.pending[id] = *item.PublishedParsed
+			c.carried[id] = true
+			continue
+		}
+
+		c.log.Debug().
+			Str("id", id).
+			Str("title", item.Title).
+			Str("link", item.Link).
+			Str("published", item.Published).
+			Msg("New item")
+
+		newItems = append(newItems, item)
+		c.pending[id] = *item.PublishedParsed
+	}
+
+	c.firstSeen = firstSeen
+	c.current = current
+	c.pruneSeen(now, current)
+
+	return newItems, nil
+}
+
+// httpClient returns an HTTP client using the configured timeout and proxy
+func (c *Config) httpClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if c.proxy != nil {
+		transport.Proxy = http.ProxyURL(c.proxy)
+	}
+	return &http.Client{
+		Timeout:   c.timeout,
+		Transport: transport,
+	}
+}
+
+// feedTime returns the feed's update date, falling back to its publish date
+// and then the newest dated item. It returns nil if nothing is dated.
+func feedTime(feed *gofeed.Feed) *time.Time {
+	if feed.UpdatedParsed != nil {
+		return feed.UpdatedParsed
+	}
+	if feed.PublishedParsed != nil {
+		return feed.PublishedParsed
+	}
+
+	var newest *time.Time
+	for _, item := range feed.Items {
+		for _, t := range []*time.Time{item.UpdatedParsed, item.PublishedParsed} {
+			if t != nil && (newest == nil || t.After(*newest)) {
+				newest = t
 			}
 		}
 	}
-	if !lastPublished.IsZero() {
-		c.lastPublished = &lastPublished
+	return newest
+}
+
+// checkItem reports why an item can't be tracked or posted
+func checkItem(item *gofeed.Item) error {
+	if item == nil {
+		return fmt.Errorf("item is empty")
 	}
-	return newItems, nil
+	if item.GUID == "" && item.Link == "" && item.Title == "" {
+		return fmt.Errorf("item has no guid, link or title")
+	}
+	if item.Link == "" && item.Title == "" && item.Description == "" {
+		return fmt.Errorf("item %s has nothing to post", item.GUID)
+	}
+	return nil
+}
+
+// pruneSeen drops items that left the feed longer ago than the retention
+// period, then the oldest remaining ones until the seen set fits seenMax.
+// Items still in the feed are never dropped.
+func (c *Config) pruneSeen(now time.Time, current map[string]bool) {
+	var stale []string
+	for id, lastSeen := range c.seen {
+		if current[id] {
+			continue
+		}
+		if now.Sub(lastSeen) > c.seenRetention {
+			delete(c.seen, id)
+			continue
+		}
+		stale = append(stale, id)
+	}
+
+	if len(c.seen) <= c.seenMax {
+		return
+	}
+
+	sort.Slice(stale, func(i, j int) bool {
+		return c.seen[stale[i]].Before(c.seen[stale[j]])
+	})
+	for _, id := range stale {
+		if len(c.seen) <= c.seenMax {
+			break
+		}
+		delete(c.seen, id)
+	}
+}
+
+// ItemLanguage returns the language of an item from its xml:lang or
+// dc:language, or "" if it doesn't have one
+func ItemLanguage(item *gofeed.Item) string {
+	if lang := item.Custom[XML_LANG]; lang != "" {
+		return lang
+	}
+	if item.DublinCoreExt != nil && len(item.DublinCoreExt.Language) > 0 {
+		return item.DublinCoreExt.Language[0]
+	}
+	return ""
+}
+
+// setItemLanguages records each item's xml:lang in its Custom map, as
+// gofeed doesn't keep it
+func setItemLanguages(feed *gofeed.Feed, body []byte) {
+	if feed.FeedType != "rss" && feed.FeedType != "atom" {
+		return
+	}
+
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	decoder.Strict = false
+	decoder.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		// Only the markup matters here, not the text
+		return input, nil
+	}
+
+	var langs []string
+	stack := []string{""}
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			break
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			lang := stack[len(stack)-1]
+			for _, attr := range t.Attr {
+				if attr.Name.Local == "lang" && (attr.Name.Space == "xml" || attr.Name.Space == "http://www.w3.org/XML/1998/namespace") {
+					lang = strings.TrimSpace(attr.Value)
+				}
+			}
+			stack = append(stack, lang)
+			if t.Name.Local == "item" || t.Name.Local == "entry" {
+				langs = append(langs, lang)
+			}
+		case xml.EndElement:
+			if len(stack) > 1 {
+				stack = stack[:len(stack)-1]
+			}
+		}
+	}
+
+	// Items are matched up by position, so give up if the count is off
+	if len(langs) != len(feed.Items) {
+		return
+	}
+	for i, item := range feed.Items {
+		if langs[i] == "" {
+			continue
+		}
+		if item.Custom == nil {
+			item.Custom = make(map[string]string)
+		}
+		item.Custom[XML_LANG] = langs[i]
+	}
+}
+
+// findTombstones returns the refs of the at:deleted-entry tombstones in the
+// feed, which are the IDs of the entries deleted from it
+func findTombstones(body []byte) []string {
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	decoder.Strict = false
+	decoder.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		// Only the markup matters here, not the text
+		return input, nil
+	}
+
+	var refs []string
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			break
+		}
+		t, ok := token.(xml.StartElement)
+		if !ok || t.Name.Local != "deleted-entry" || (t.Name.Space != TOMBSTONES_NS && t.Name.Space != "at") {
+			continue
+		}
+		for _, attr := range t.Attr {
+			if attr.Name.Local == "ref" && strings.TrimSpace(attr.Value) != "" {
+				refs = append(refs, strings.TrimSpace(attr.Value))
+			}
+		}
+	}
+	return refs
+}
+
+// ItemID returns the key used to track an item: its GUID, or a hash of its link and title
+func ItemID(item *gofeed.Item) string {
+	if item.GUID != "" {
+		return item.GUID
+	}
+	sum := sha1.Sum([]byte(item.Link + "\n" + item.Title))
+	return "sha1:" + hex.EncodeToString(sum[:])
+}
+
+// ItemHash returns a hash of an item's content, used to tell when it changes
+func ItemHash(item *gofeed.Item) string {
+	sum := sha1.Sum([]byte(item.Title + "\n" + item.Link + "\n" + item.Description + "\n" + item.Content))
+	return hex.EncodeToString(sum[:])
 }
@@ -0,0 +1,480 @@
+package rssfeed
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+	"github.com/rs/zerolog"
+)
+
+const testFeed = `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0">
+<channel>
+<title>Test feed</title>
+<link>https://example.com/</link>
+<item>
+<title>Second post</title>
+<link>https://example.com/2</link>
+<guid>https://example.com/2</guid>
+<pubDate>Tue, 02 Jan 2024 10:00:00 +0000</pubDate>
+</item>
+<item>
+<title>First post</title>
+<link>https://example.com/1</link>
+<guid>https://example.com/1</guid>
+<pubDate>Mon, 01 Jan 2024 10:00:00 +0000</pubDate>
+</item>
+</channel>
+</rss>`
+
+// newTestFeed returns a Config reading the feed at the server's URL
+func newTestFeed(t *testing.T, server *httptest.Server, opts ...Option) *Config {
+	t.Helper()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := zerolog.Nop()
+	feed, err := New(append([]Option{WithURL(u), WithLogger(&log)}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return feed
+}
+
+// serveFeed returns a server answering every request with the given body
+func serveFeed(t *testing.T, body string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/rss+xml")
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestParseConditional(t *testing.T) {
+	const etag = `"v1"`
+	const lastModified = "Tue, 02 Jan 2024 10:00:00 GMT"
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == etag && r.Header.Get("If-Modified-Since") == lastModified {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Last-Modified", lastModified)
+		w.Write([]byte(testFeed))
+	}))
+	defer server.Close()
+
+	feed := newTestFeed(t, server)
+	items, err := feed.Parse()
+	if err != nil {
+		t.Fatalf("first fetch: %v", err)
+	}
+	if len(items) != 2 {
+		t.Fatalf("first fetch returned %d items, want 2", len(items))
+	}
+	if feed.GetETag() != etag || feed.GetLastModified() != lastModified {
+		t.Fatalf("validators = %q, %q; want %q, %q", feed.GetETag(), feed.GetLastModified(), etag, lastModified)
+	}
+
+	// A new run starts from the stored validators
+	feed = newTestFeed(t, server, WithETag(feed.GetETag()), WithLastModified(feed.GetLastModified()))
+	_, err = feed.Parse()
+	var noUpdates *NoUpdates
+	if !errors.As(err, &noUpdates) {
+		t.Fatalf("conditional fetch returned %v, want *NoUpdates", err)
+	}
+	if feed.GetETag() != etag || feed.GetLastModified() != lastModified {
+		t.Errorf("validators changed on 304: %q, %q", feed.GetETag(), feed.GetLastModified())
+	}
+}
+
+func TestParseHTTPError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "gone", http.StatusGone)
+	}))
+	defer server.Close()
+
+	_, err := newTestFeed(t, server).Parse()
+	var parserError *ParserError
+	if !errors.As(err, &parserError) {
+		t.Fatalf("Parse returned %v, want *ParserError", err)
+	}
+}
+
+func TestParseSkipsSeen(t *testing.T) {
+	server := serveFeed(t, testFeed)
+
+	feed := newTestFeed(t, server)
+	items, err := feed.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 {
+		t.Fatalf("returned %d items, want 2", len(items))
+	}
+	// Only the older item is handled; the other stays new
+	feed.MarkSeen(items[1])
+	if feed.Pending() != 1 {
+		t.Errorf("Pending() = %d, want 1", feed.Pending())
+	}
+
+	feed = newTestFeed(t, server, WithSeen(feed.GetSeen()), WithLastPublished(feed.GetLastPublished()))
+	items, err = feed.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || ItemID(items[0]) != "https://example.com/2" {
+		t.Fatalf("returned %v, want only the unhandled item", items)
+	}
+}
+
+func TestParseNewItemOlderThanWatermark(t *testing.T) {
+	server := serveFeed(t, testFeed)
+
+	// An item published before the newest one seen is still new
+	lastPublished := time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC)
+	seen := map[string]time.Time{"https://example.com/2": time.Now().UTC()}
+	feed := newTestFeed(t, server, WithSeen(seen), WithLastPublished(&lastPublished))
+	items, err := feed.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || ItemID(items[0]) != "https://example.com/1" {
+		t.Fatalf("returned %v, want the unseen item", items)
+	}
+
+	// Unless the watermark is used as well
+	feed = newTestFeed(t, server, WithSeen(seen), WithLastPublished(&lastPublished), WithPublishedFilter(true))
+	items, err = feed.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 0 {
+		t.Fatalf("returned %d items with the published filter, want 0", len(items))
+	}
+}
+
+func TestPruneSeen(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	c := &Config{
+		seenRetention: 24 * time.Hour,
+		seenMax:       3,
+		seen: map[string]time.Time{
+			"expired":  now.Add(-48 * time.Hour),
+			"old":      now.Add(-3 * time.Hour),
+			"newer":    now.Add(-2 * time.Hour),
+			"newest":   now.Add(-1 * time.Hour),
+			"current":  now.Add(-72 * time.Hour),
+			"current2": now.Add(-96 * time.Hour),
+		},
+	}
+	c.pruneSeen(now, map[string]bool{"current": true, "current2": true})
+
+	for _, id := range []string{"current", "current2", "newest"} {
+		if _, ok := c.seen[id]; !ok {
+			t.Errorf("dropped %s", id)
+		}
+	}
+	for _, id := range []string{"expired", "old", "newer"} {
+		if _, ok := c.seen[id]; ok {
+			t.Errorf("kept %s", id)
+		}
+	}
+}
+
+const undatedFeed = `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0">
+<channel>
+<title>Undated feed</title>
+<link>https://example.com/</link>
+<item>
+<title>Undated post</title>
+<link>https://example.com/undated</link>
+</item>
+</channel>
+</rss>`
+
+func TestParseUndated(t *testing.T) {
+	server := serveFeed(t, undatedFeed)
+
+	feed := newTestFeed(t, server)
+	items, err := feed.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 {
+		t.Fatalf("returned %d items, want 1", len(items))
+	}
+	if items[0].PublishedParsed == nil {
+		t.Fatal("undated item has no published time")
+	}
+	firstSeen := feed.GetFirstSeen()
+	seenAt, ok := firstSeen[ItemID(items[0])]
+	if !ok {
+		t.Fatal("undated item not in the first-seen set")
+	}
+
+	// The item keeps the time it was first seen on later runs
+	feed = newTestFeed(t, server, WithFirstSeen(firstSeen))
+	items, err = feed.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || !items[0].PublishedParsed.Equal(seenAt) {
+		t.Fatalf("published time changed from %v", seenAt)
+	}
+}
+
+func TestParseUsesFeedDate(t *testing.T) {
+	server := serveFeed(t, `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0">
+<channel>
+<title>Dated feed</title>
+<link>https://example.com/</link>
+<lastBuildDate>Wed, 03 Jan 2024 10:00:00 +0000</lastBuildDate>
+<item>
+<title>Undated post</title>
+<link>https://example.com/undated</link>
+</item>
+</channel>
+</rss>`)
+
+	items, err := newTestFeed(t, server).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := time.Date(2024, 1, 3, 10, 0, 0, 0, time.UTC)
+	if len(items) != 1 || !items[0].PublishedParsed.Equal(want) {
+		t.Fatalf("published time is not the feed's date %v", want)
+	}
+}
+
+func TestCheckItem(t *testing.T) {
+	tests := []struct {
+		name  string
+		item  *gofeed.Item
+		valid bool
+	}{
+		{"nil", nil, false},
+		{"empty", &gofeed.Item{}, false},
+		{"guid only", &gofeed.Item{GUID: "x"}, false},
+		{"title", &gofeed.Item{Title: "A post"}, true},
+		{"link", &gofeed.Item{Link: "https://example.com/"}, true},
+		{"guid and description", &gofeed.Item{GUID: "x", Description: "text"}, true},
+	}
+	for _, tt := range tests {
+		if err := checkItem(tt.item); (err == nil) != tt.valid {
+			t.Errorf("%s: checkItem() = %v, want valid %v", tt.name, err, tt.valid)
+		}
+	}
+}
+
+func TestParseRequestSettings(t *testing.T) {
+	var req *http.Request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req = r
+		w.Write([]byte(testFeed))
+	}))
+	defer server.Close()
+
+	feed := newTestFeed(t, server,
+		WithUserAgent("test-agent/1.0"),
+		WithHeaders(map[string]string{"X-Api-Key": "secret"}),
+		WithBasicAuth("user", "pass"),
+	)
+	if _, err := feed.Parse(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := req.Header.Get("User-Agent"); got != "test-agent/1.0" {
+		t.Errorf("User-Agent = %q", got)
+	}
+	if got := req.Header.Get("X-Api-Key"); got != "secret" {
+		t.Errorf("X-Api-Key = %q", got)
+	}
+	if user, pass, ok := req.BasicAuth(); !ok || user != "user" || pass != "pass" {
+		t.Errorf("basic auth = %q, %q, %v", user, pass, ok)
+	}
+}
+
+func TestParseDefaultUserAgent(t *testing.T) {
+	var userAgent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgent = r.Header.Get("User-Agent")
+		w.Write([]byte(testFeed))
+	}))
+	defer server.Close()
+
+	if _, err := newTestFeed(t, server).Parse(); err != nil {
+		t.Fatal(err)
+	}
+	if userAgent != DEFAULT_USER_AGENT {
+		t.Errorf("User-Agent = %q, want %q", userAgent, DEFAULT_USER_AGENT)
+	}
+}
+
+func TestParseTimeout(t *testing.T) {
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer server.Close()
+	defer close(done)
+
+	_, err := newTestFeed(t, server, WithTimeout(50*time.Millisecond)).Parse()
+	var parserError *ParserError
+	if !errors.As(err, &parserError) {
+		t.Fatalf("Parse returned %v, want *ParserError", err)
+	}
+}
+
+func TestParseItemLanguages(t *testing.T) {
+	server := serveFeed(t, `<?xml version="1.0" encoding="UTF-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom" xml:lang="en">
+<title>Atom feed</title>
+<updated>2024-01-03T10:00:00Z</updated>
+<entry xml:lang="fr">
+<title>Bonjour</title>
+<id>urn:1</id>
+<link href="https://example.com/1"/>
+<updated>2024-01-01T10:00:00Z</updated>
+</entry>
+<entry>
+<title>Hello</title>
+<id>urn:2</id>
+<link href="https://example.com/2"/>
+<updated>2024-01-02T10:00:00Z</updated>
+</entry>
+</feed>`)
+
+	items, err := newTestFeed(t, server).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	languages := make(map[string]string)
+	for _, item := range items {
+		languages[ItemID(item)] = ItemLanguage(item)
+	}
+	if languages["urn:1"] != "fr" || languages["urn:2"] != "en" {
+		t.Errorf("item languages = %v, want fr and the feed's en", languages)
+	}
+}
+
+func TestParseTombstones(t *testing.T) {
+	server := serveFeed(t, `<?xml version="1.0" encoding="UTF-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom" xmlns:at="http://purl.org/atompub/tombstones/1.0">
+<title>Atom feed</title>
+<updated>2024-01-03T10:00:00Z</updated>
+<at:deleted-entry ref="urn:gone" when="2024-01-03T09:00:00Z"/>
+<entry>
+<title>Still here</title>
+<id>urn:here</id>
+<link href="https://example.com/here"/>
+<updated>2024-01-02T10:00:00Z</updated>
+</entry>
+</feed>`)
+
+	feed := newTestFeed(t, server)
+	if _, err := feed.Parse(); err != nil {
+		t.Fatal(err)
+	}
+	deleted := feed.GetDeleted()
+	if len(deleted) != 1 || deleted[0] != "urn:gone" {
+		t.Errorf("GetDeleted() = %v, want [urn:gone]", deleted)
+	}
+	if !feed.InFeed("urn:here") || feed.InFeed("urn:gone") {
+		t.Error("InFeed doesn't match the feed's entries")
+	}
+}
+
+func TestParseMinAge(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	item := func(id string, age time.Duration) string {
+		return `<item>
+<title>Post ` + id + `</title>
+<link>https://example.com/` + id + `</link>
+<guid>https://example.com/` + id + `</guid>
+<pubDate>` + now.Add(-age).Format(time.RFC1123Z) + `</pubDate>
+</item>
+`
+	}
+	server := serveFeed(t, `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0">
+<channel>
+<title>Test feed</title>
+`+item("3", 10*time.Minute)+item("2", 30*time.Minute)+item("1", 3*time.Hour)+`</channel>
+</rss>`)
+
+	feed := newTestFeed(t, server, WithMinAge(time.Hour), WithPublishedFilter(true))
+	items, err := feed.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || ItemID(items[0]) != "https://example.com/1" {
+		t.Fatalf("returned %v, want only the item older than an hour", items)
+	}
+	if feed.Carried() != 2 || feed.Pending() != 3 {
+		t.Errorf("Carried() = %d and Pending() = %d, want 2 and 3", feed.Carried(), feed.Pending())
+	}
+
+	// Handling the old item doesn't move the watermark past the young ones
+	feed.MarkSeen(items[0])
+	if last := feed.GetLastPublished(); !last.Before(now.Add(-30 * time.Minute)) {
+		t.Errorf("last published moved up to %v, past a carried item", last)
+	}
+	if feed.Pending() != 2 {
+		t.Errorf("Pending() = %d after handling the old item, want 2", feed.Pending())
+	}
+
+	// Once they're old enough, the carried items are new, even with the
+	// published filter on
+	feed = newTestFeed(t, server,
+		WithMinAge(5*time.Minute),
+		WithPublishedFilter(true),
+		WithSeen(feed.GetSeen()),
+		WithLastPublished(feed.GetLastPublished()),
+	)
+	items, err = feed.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 || feed.Carried() != 0 {
+		t.Fatalf("returned %d items and carried %d, want 2 and none", len(items), feed.Carried())
+	}
+}
+
+func TestMarkSeenKeepsPendingNew(t *testing.T) {
+	server := serveFeed(t, testFeed)
+	feed := newTestFeed(t, server, WithPublishedFilter(true))
+	items, err := feed.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Handling the newer item first leaves the watermark behind the older one
+	feed.MarkSeen(items[0])
+	if last := feed.GetLastPublished(); !last.Before(*items[1].PublishedParsed) {
+		t.Fatalf("last published moved up to %v, past a pending item", last)
+	}
+
+	feed = newTestFeed(t, server, WithPublishedFilter(true), WithSeen(feed.GetSeen()), WithLastPublished(feed.GetLastPublished()))
+	items, err = feed.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || ItemID(items[0]) != "https://example.com/1" {
+		t.Fatalf("returned %v, want the pending item", items)
+	}
+}
@@ -0,0 +1,617 @@
+package ssmparams
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/mmcdole/gofeed"
+	"github.com/rmrfslashbin/mastopost/pkg/config"
+)
+
+// MAX_PARAM_SIZE is the largest value an advanced tier SSM parameter can hold
+const MAX_PARAM_SIZE = 8192
+
+// MAX_POSTED_PARAMS is how many SSM parameters the statuses of posted items
+// are spread across: runtime/posted, runtime/posted-2, runtime/posted-3, ...
+const MAX_POSTED_PARAMS = 20
+
+// LoadFeed reads a feed's config and runtime state from the SSM parameters
+// under path, e.g. /mastopost/feedname/
+func (params *SSMParamsConfig) LoadFeed(path string) (*config.FeedConfig, *config.FeedLastUpdate, error) {
+	feedConfig := &config.FeedConfig{}
+	state := &config.FeedLastUpdate{}
+
+	var nextToken *string
+	for {
+		opt, err := params.ListAllParams(path, nextToken)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for _, p := range opt.Parameters {
+			/*
+				params.log.Info().
+					Str("name", *p.Name).
+					Str("value", *p.Value).
+					Str("modified", p.LastModifiedDate.String()).
+					Int64("version", p.Version).
+					Str("Arn", *p.ARN).
+					Msg("found parameter")
+			*/
+			key := strings.TrimPrefix(*p.Name, path)
+			if strings.HasPrefix(key, "runtime/") {
+				params.markStored(*p.Name, true)
+			}
+			switch key {
+			case "mastodon/instanceUrl":
+				feedConfig.Instance = *p.Value
+			case "mastodon/clientId":
+				feedConfig.ClientId = *p.Value
+			case "mastodon/clientSecret":
+				feedConfig.ClientSecret = *p.Value
+			case "mastodon/accessToken":
+				feedConfig.AccessToken = *p.Value
+			case "rss/feedUrl":
+				feedConfig.FeedURL = *p.Value
+			case "rss/filterPublished":
+				feedConfig.FilterPublished = *p.Value == "true"
+			case "rss/timeout":
+				feedConfig.Timeout = *p.Value
+			case "rss/userAgent":
+				feedConfig.UserAgent = *p.Value
+			case "rss/headers":
+				if err := json.Unmarshal([]byte(*p.Value), &feedConfig.Headers); err != nil {
+					return nil, nil, fmt.Errorf("invalid %s: %w", key, err)
+				}
+			case "rss/basicAuthUser":
+				feedConfig.BasicAuthUser = *p.Value
+			case "rss/basicAuthPassword":
+				feedConfig.BasicAuthPassword = *p.Value
+			case "rss/proxyUrl":
+				feedConfig.ProxyURL = *p.Value
+			case "post/maxPostsPerRun":
+				if max, err := strconv.Atoi(*p.Value); err != nil {
+					return nil, nil, fmt.Errorf("invalid %s: %w", key, err)
+				} else {
+					feedConfig.MaxPostsPerRun = max
+				}
+			case "post/firstRun":
+				feedConfig.FirstRun = *p.Value
+			case "post/minAge":
+				if minutes, err := strconv.Atoi(*p.Value); err != nil {
+					return nil, nil, fmt.Errorf("invalid %s: %w", key, err)
+				} else {
+					feedConfig.MinAge = minutes
+				}
+			case "post/postDelay":
+				feedConfig.PostDelay = *p.Value
+			case "post/scheduleSpacing":
+				feedConfig.ScheduleSpacing = *p.Value
+			case "post/filters":
+				feedConfig.Filters = &config.Filters{}
+				if err := json.Unmarshal([]byte(*p.Value), feedConfig.Filters); err != nil {
+					return nil, nil, fmt.Errorf("invalid %s: %w", key, err)
+				}
+			case "post/postingWindow":
+				feedConfig.PostingWindow = &config.PostingWindow{}
+				if err := json.Unmarshal([]byte(*p.Value), feedConfig.PostingWindow); err != nil {
+					return nil, nil, fmt.Errorf("invalid %s: %w", key, err)
+				}
+			case "post/contentWarnings":
+				if err := json.Unmarshal([]byte(*p.Value), &feedConfig.ContentWarnings); err != nil {
+					return nil, nil, fmt.Errorf("invalid %s: %w", key, err)
+				}
+			case "post/template":
+				template, err := DecodeTemplate(*p.Value)
+				if err != nil {
+					return nil, nil, fmt.Errorf("invalid %s: %w", key, err)
+				}
+				feedConfig.Template = template
+			case "post/hashtags":
+				if err := json.Unmarshal([]byte(*p.Value), &feedConfig.Hashtags); err != nil {
+					return nil, nil, fmt.Errorf("invalid %s: %w", key, err)
+				}
+			case "post/hashtagMap":
+				if err := json.Unmarshal([]byte(*p.Value), &feedConfig.HashtagMap); err != nil {
+					return nil, nil, fmt.Errorf("invalid %s: %w", key, err)
+				}
+			case "post/maxHashtags":
+				if max, err := strconv.Atoi(*p.Value); err != nil {
+					return nil, nil, fmt.Errorf("invalid %s: %w", key, err)
+				} else {
+					feedConfig.MaxHashtags = max
+				}
+			case "post/visibility":
+				feedConfig.Visibility = *p.Value
+			case "post/language":
+				feedConfig.Language = *p.Value
+			case "post/excerpt":
+				feedConfig.Excerpt = *p.Value
+			case "post/excerptLength":
+				if length, err := strconv.Atoi(*p.Value); err != nil {
+					return nil, nil, fmt.Errorf("invalid %s: %w", key, err)
+				} else {
+					feedConfig.ExcerptLength = length
+				}
+			case "post/media":
+				feedConfig.Media = *p.Value == "true"
+			case "post/maxAttachments":
+				if max, err := strconv.Atoi(*p.Value); err != nil {
+					return nil, nil, fmt.Errorf("invalid %s: %w", key, err)
+				} else {
+					feedConfig.MaxAttachments = max
+				}
+			case "post/cardImage":
+				feedConfig.CardImage = *p.Value == "true"
+			case "post/cardImageMaxSize":
+				if size, err := strconv.ParseInt(*p.Value, 10, 64); err != nil {
+					return nil, nil, fmt.Errorf("invalid %s: %w", key, err)
+				} else {
+					feedConfig.CardImageMaxSize = size
+				}
+			case "post/cardTimeout":
+				feedConfig.CardTimeout = *p.Value
+			case "post/editUpdates":
+				feedConfig.EditUpdates = *p.Value == "true"
+			case "post/editWindow":
+				feedConfig.EditWindow = *p.Value
+			case "post/deleteTombstones":
+				feedConfig.DeleteTombstones = *p.Value == "true"
+			case "post/deleteRemoved":
+				feedConfig.DeleteRemoved = *p.Value == "true"
+			case "post/deleteWindow":
+				feedConfig.DeleteWindow = *p.Value
+			case "post/expireAfter":
+				if days, err := strconv.Atoi(*p.Value); err != nil {
+					return nil, nil, fmt.Errorf("invalid %s: %w", key, err)
+				} else {
+					feedConfig.ExpireAfter = days
+				}
+			case "post/expireScan":
+				feedConfig.ExpireScan = *p.Value == "true"
+			case "post/thread":
+				feedConfig.Thread = *p.Value == "true"
+			case "post/threadUnlisted":
+				feedConfig.ThreadUnlisted = *p.Value == "true"
+			case "post/maxThreadPosts":
+				if max, err := strconv.Atoi(*p.Value); err != nil {
+					return nil, nil, fmt.Errorf("invalid %s: %w", key, err)
+				} else {
+					feedConfig.MaxThreadPosts = max
+				}
+			case "runtime/lastUpdated":
+				if t, err := time.Parse(time.RFC3339, *p.Value); err == nil {
+					state.LastUpdated = &t
+				}
+			case "runtime/lastPublished":
+				if t, err := time.Parse(time.RFC3339, *p.Value); err == nil {
+					state.LastPublished = &t
+				}
+			case "runtime/seen":
+				if seen, err := decodeTimes(*p.Value); err != nil {
+					params.log.Warn().Err(err).Msg("ignoring unreadable seen set")
+				} else {
+					state.Seen = seen
+				}
+			case "runtime/seenTrimmed":
+				state.SeenTrimmed = *p.Value == "true"
+			case "runtime/firstSeen":
+				if firstSeen, err := decodeTimes(*p.Value); err != nil {
+					params.log.Warn().Err(err).Msg("ignoring unreadable first seen times")
+				} else {
+					state.FirstSeen = firstSeen
+				}
+			case "runtime/held":
+				if err := json.Unmarshal([]byte(*p.Value), &state.Held); err != nil {
+					params.log.Warn().Err(err).Msg("ignoring unreadable held items")
+				}
+			case "runtime/history":
+				// read and written by the history package
+			case "runtime/etag":
+				state.ETag = *p.Value
+			case "runtime/lastModified":
+				state.LastModified = *p.Value
+			default:
+				if key != "runtime/posted" && !strings.HasPrefix(key, "runtime/posted-") {
+					params.log.Warn().Str("key", key).Msg("unknown key")
+					break
+				}
+				posted, err := decodePosted(*p.Value)
+				if err != nil {
+					params.log.Warn().Err(err).Str("key", key).Msg("ignoring unreadable posted statuses")
+					break
+				}
+				if state.Posted == nil {
+					state.Posted = make(map[string]config.PostedItem)
+				}
+				for id, item := range posted {
+					state.Posted[id] = item
+				}
+			}
+		}
+
+		nextToken = opt.NextToken
+		if nextToken == nil {
+			break
+		}
+	}
+
+	return feedConfig, state, nil
+}
+
+// SaveState writes a feed's runtime state to the SSM parameters under path.
+// Parameters no longer needed are deleted if LoadFeed found them.
+func (params *SSMParamsConfig) SaveState(path string, state *config.FeedLastUpdate) error {
+	var paramNames []*ssm.PutParameterInput
+
+	paramNames = append(paramNames, &ssm.PutParameterInput{
+		Name:      aws.String(fmt.Sprintf("%sruntime/lastUpdated", path)),
+		Value:     aws.String(state.LastUpdated.Format(time.RFC3339)),
+		Type:      types.ParameterTypeString,
+		Overwrite: aws.Bool(true),
+	})
+
+	paramNames = append(paramNames, &ssm.PutParameterInput{
+		Name:      aws.String(fmt.Sprintf("%sruntime/lastPublished", path)),
+		Value:     aws.String(state.LastPublished.Format(time.RFC3339)),
+		Type:      types.ParameterTypeString,
+		Overwrite: aws.Bool(true),
+	})
+
+	timeSets := map[string]map[string]time.Time{
+		"runtime/seen":      state.Seen,
+		"runtime/firstSeen": state.FirstSeen,
+	}
+	for key, times := range timeSets {
+		value, trimmed, err := encodeTimes(times, state.Current)
+		if err != nil {
+			return err
+		}
+		if trimmed && key == "runtime/seen" {
+			params.log.Warn().
+				Str("path", path).
+				Msg("seen set too large to store. dropped items still in the feed; the next run falls back to the last published time")
+			state.SeenTrimmed = true
+		}
+		paramNames = append(paramNames, &ssm.PutParameterInput{
+			Name:      aws.String(path + key),
+			Value:     aws.String(value),
+			Type:      types.ParameterTypeString,
+			Tier:      types.ParameterTierIntelligentTiering,
+			Overwrite: aws.Bool(true),
+		})
+	}
+
+	// Too many posted statuses to store is reported once the rest of the
+	// state is saved, so the feed's seen items aren't posted again
+	posted, postedErr := encodePosted(state.Posted)
+	if postedErr != nil {
+		posted = nil
+	}
+	var staleNames []string
+	for i := 0; i < MAX_POSTED_PARAMS; i++ {
+		name := path + postedKey(i)
+		if postedErr != nil {
+			break
+		}
+		if i >= len(posted) {
+			staleNames = append(staleNames, name)
+			continue
+		}
+		paramNames = append(paramNames, &ssm.PutParameterInput{
+			Name:      aws.String(name),
+			Value:     aws.String(posted[i]),
+			Type:      types.ParameterTypeString,
+			Tier:      types.ParameterTierIntelligentTiering,
+			Overwrite: aws.Bool(true),
+		})
+	}
+
+	held, err := encodeHeld(state.Held)
+	if err != nil {
+		return err
+	}
+	paramNames = append(paramNames, &ssm.PutParameterInput{
+		Name:      aws.String(path + "runtime/held"),
+		Value:     aws.String(held),
+		Type:      types.ParameterTypeString,
+		Tier:      types.ParameterTierIntelligentTiering,
+		Overwrite: aws.Bool(true),
+	})
+
+	seenTrimmed := ""
+	if state.SeenTrimmed {
+		seenTrimmed = "true"
+	}
+
+	// SSM doesn't allow empty values, so unset validators are deleted instead
+	validators := map[string]string{
+		"runtime/etag":         state.ETag,
+		"runtime/lastModified": state.LastModified,
+		"runtime/seenTrimmed":  seenTrimmed,
+	}
+	for key, value := range validators {
+		if value == "" {
+			staleNames = append(staleNames, path+key)
+			continue
+		}
+		paramNames = append(paramNames, &ssm.PutParameterInput{
+			Name:      aws.String(path + key),
+			Value:     aws.String(value),
+			Type:      types.ParameterTypeString,
+			Overwrite: aws.Bool(true),
+		})
+	}
+
+	for _, param := range paramNames {
+		_, err := params.PutParam(param)
+		if err != nil {
+			return err
+		}
+		params.markStored(*param.Name, true)
+	}
+
+	// Only parameters LoadFeed found or SaveState wrote are deleted, so a
+	// run that has nothing to clear up doesn't call DeleteParameters
+	var deleteNames []string
+	for _, name := range staleNames {
+		if params.stored[name] {
+			deleteNames = append(deleteNames, name)
+		}
+	}
+	if len(deleteNames) > 0 {
+		if _, err := params.DeleteParams(deleteNames); err != nil {
+			return err
+		}
+		for _, name := range deleteNames {
+			params.markStored(name, false)
+		}
+	}
+
+	return postedErr
+}
+
+// markStored records whether the named runtime parameter exists
+func (params *SSMParamsConfig) markStored(name string, stored bool) {
+	if params.stored == nil {
+		params.stored = make(map[string]bool)
+	}
+	if stored {
+		params.stored[name] = true
+	} else {
+		delete(params.stored, name)
+	}
+}
+
+// EncodeTemplate encodes a post template for storing in SSM, which rejects
+// values containing "{{"
+func EncodeTemplate(template string) string {
+	return base64.StdEncoding.EncodeToString([]byte(template))
+}
+
+// DecodeTemplate decodes a post template stored by EncodeTemplate
+func DecodeTemplate(value string) (string, error) {
+	template, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return "", err
+	}
+	return string(template), nil
+}
+
+// postedKey returns the name of the i'th parameter the statuses of posted
+// items are stored in
+func postedKey(i int) string {
+	if i == 0 {
+		return "runtime/posted"
+	}
+	return fmt.Sprintf("runtime/posted-%d", i+1)
+}
+
+// decodeTimes reads a map of item IDs to times stored by encodeTimes
+func decodeTimes(value string) (map[string]time.Time, error) {
+	var stored map[string]int64
+	if err := json.Unmarshal([]byte(value), &stored); err != nil {
+		return nil, err
+	}
+	times := make(map[string]time.Time, len(stored))
+	for id, ts := range stored {
+		times[id] = time.Unix(ts, 0).UTC()
+	}
+	return times, nil
+}
+
+// encodeTimes stores a map of item IDs to times as JSON with unix timestamps.
+// If it doesn't fit in an advanced SSM parameter, the oldest entries not in
+// current are dropped. Only if that isn't enough are entries in current
+// dropped too, oldest first, and trimmed is set.
+func encodeTimes(times map[string]time.Time, current map[string]bool) (value string, trimmed bool, err error) {
+	stored := make(map[string]int64, len(times))
+	ids := make([]string, 0, len(times))
+	for id, t := range times {
+		stored[id] = t.Unix()
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if current[ids[i]] != current[ids[j]] {
+			return !current[ids[i]]
+		}
+		if stored[ids[i]] != stored[ids[j]] {
+			return stored[ids[i]] < stored[ids[j]]
+		}
+		return ids[i] < ids[j]
+	})
+
+	for {
+		b, err := json.Marshal(stored)
+		if err != nil {
+			return "", false, err
+		}
+		if len(b) <= MAX_PARAM_SIZE || len(ids) == 0 {
+			return string(b), trimmed, nil
+		}
+		if current[ids[0]] {
+			trimmed = true
+		}
+		delete(stored, ids[0])
+		ids = ids[1:]
+	}
+}
+
+// storedPost is a config.PostedItem as stored in SSM, with short keys and
+// unix timestamps to save space
+type storedPost struct {
+	StatusID string   `json:"s"`
+	PostedAt int64    `json:"p"`
+	Updated  int64    `json:"u,omitempty"`
+	Hash     string   `json:"h"`
+	Thread   []string `json:"t,omitempty"`
+	Schedule string   `json:"c,omitempty"`
+	At       int64    `json:"a,omitempty"`
+}
+
+// decodePosted reads the statuses of posted items stored by encodePosted
+func decodePosted(value string) (map[string]config.PostedItem, error) {
+	var stored map[string]storedPost
+	if err := json.Unmarshal([]byte(value), &stored); err != nil {
+		return nil, err
+	}
+	posted := make(map[string]config.PostedItem, len(stored))
+	for id, post := range stored {
+		item := config.PostedItem{
+			StatusID:    post.StatusID,
+			PostedAt:    time.Unix(post.PostedAt, 0).UTC(),
+			Hash:        post.Hash,
+			ThreadIDs:   post.Thread,
+			ScheduledID: post.Schedule,
+		}
+		if post.At != 0 {
+			at := time.Unix(post.At, 0).UTC()
+			item.ScheduledAt = &at
+		}
+		if post.Updated != 0 {
+			updated := time.Unix(post.Updated, 0).UTC()
+			item.Updated = &updated
+		}
+		posted[id] = item
+	}
+	return posted, nil
+}
+
+// encodePosted stores the statuses of posted items as JSON, oldest first,
+// split into values that each fit in an advanced SSM parameter. Nothing is
+// dropped: if it takes more than MAX_POSTED_PARAMS values, it fails with
+// *TooManyPosted.
+func encodePosted(posted map[string]config.PostedItem) ([]string, error) {
+	stored := make(map[string]storedPost, len(posted))
+	ids := make([]string, 0, len(posted))
+	for id, item := range posted {
+		post := storedPost{
+			StatusID: item.StatusID,
+			PostedAt: item.PostedAt.Unix(),
+			Hash:     item.Hash,
+			Thread:   item.ThreadIDs,
+			Schedule: item.ScheduledID,
+		}
+		if item.ScheduledAt != nil {
+			post.At = item.ScheduledAt.Unix()
+		}
+		if item.Updated != nil {
+			post.Updated = item.Updated.Unix()
+		}
+		stored[id] = post
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return stored[ids[i]].PostedAt < stored[ids[j]].PostedAt
+	})
+
+	var values []string
+	chunk := make(map[string]storedPost)
+	last := []byte("{}")
+	for _, id := range ids {
+		chunk[id] = stored[id]
+		value, err := json.Marshal(chunk)
+		if err != nil {
+			return nil, err
+		}
+		if len(value) <= MAX_PARAM_SIZE {
+			last = value
+			continue
+		}
+		// Start the next value with the post that didn't fit
+		if len(chunk) > 1 {
+			values = append(values, string(last))
+			chunk = map[string]storedPost{id: stored[id]}
+			if value, err = json.Marshal(chunk); err != nil {
+				return nil, err
+			}
+		}
+		if len(value) > MAX_PARAM_SIZE {
+			return nil, &TooManyPosted{Msg: "posted status too large to store: " + id}
+		}
+		last = value
+	}
+	values = append(values, string(last))
+
+	if len(values) > MAX_POSTED_PARAMS {
+		return nil, &TooManyPosted{Posts: len(ids), Params: len(values)}
+	}
+	return values, nil
+}
+
+// encodeHeld stores the items held outside the posting window as JSON,
+// without their extensions. If that doesn't fit in an advanced SSM parameter
+// their content is dropped, and then the newest items, which are the most
+// likely to still be in the feed.
+func encodeHeld(held map[string]*gofeed.Item) (string, error) {
+	stored := make(map[string]*gofeed.Item, len(held))
+	ids := make([]string, 0, len(held))
+	for id, item := range held {
+		short := *item
+		short.Extensions = nil
+		short.ITunesExt = nil
+		short.DublinCoreExt = nil
+		short.Custom = nil
+		stored[id] = &short
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return published(stored[ids[i]]).After(published(stored[ids[j]]))
+	})
+
+	dropContent := true
+	for {
+		value, err := json.Marshal(stored)
+		if err != nil {
+			return "", err
+		}
+		if len(value) <= MAX_PARAM_SIZE || len(ids) == 0 {
+			return string(value), nil
+		}
+		if dropContent {
+			for _, item := range stored {
+				item.Content = ""
+			}
+			dropContent = false
+			continue
+		}
+		delete(stored, ids[0])
+		ids = ids[1:]
+	}
+}
+
+// published returns when an item was published, or the zero time
+func published(item *gofeed.Item) time.Time {
+	if item.PublishedParsed == nil {
+		return time.Time{}
+	}
+	return *item.PublishedParsed
+}
@@ -0,0 +1,362 @@
+package ssmparams
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+	ext "github.com/mmcdole/gofeed/extensions"
+	"github.com/rmrfslashbin/mastopost/pkg/config"
+)
+
+func TestEncodeTimesRoundTrip(t *testing.T) {
+	times := map[string]time.Time{
+		"a": time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC),
+		"b": time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC),
+	}
+	value, trimmed, err := encodeTimes(times, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trimmed {
+		t.Error("trimmed set for a small seen set")
+	}
+	decoded, err := decodeTimes(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded) != len(times) {
+		t.Fatalf("decoded %d times, want %d", len(decoded), len(times))
+	}
+	for id, want := range times {
+		if !decoded[id].Equal(want) {
+			t.Errorf("%s = %v, want %v", id, decoded[id], want)
+		}
+	}
+}
+
+func TestEncodeTimesKeepsCurrent(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	times := make(map[string]time.Time)
+	current := make(map[string]bool)
+	for i := 0; i < 1000; i++ {
+		id := fmt.Sprintf("https://example.com/posts/%04d", i)
+		times[id] = base.Add(time.Duration(i) * time.Minute)
+		// The oldest items are still in the feed
+		if i < 50 {
+			current[id] = true
+		}
+	}
+
+	value, trimmed, err := encodeTimes(times, current)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(value) > MAX_PARAM_SIZE {
+		t.Fatalf("value is %d bytes, over %d", len(value), MAX_PARAM_SIZE)
+	}
+	if trimmed {
+		t.Error("trimmed set, but only items no longer in the feed had to go")
+	}
+	decoded, err := decodeTimes(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id := range current {
+		if _, ok := decoded[id]; !ok {
+			t.Errorf("dropped %s, which is still in the feed", id)
+		}
+	}
+	if len(decoded) == len(times) {
+		t.Fatal("nothing dropped from an oversized seen set")
+	}
+	// What's left of the rest is the newest
+	if _, ok := decoded["https://example.com/posts/0999"]; !ok {
+		t.Error("dropped the newest item")
+	}
+	if _, ok := decoded["https://example.com/posts/0050"]; ok {
+		t.Error("kept the oldest item no longer in the feed")
+	}
+}
+
+func TestEncodeTimesTrimsCurrent(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	times := make(map[string]time.Time)
+	current := make(map[string]bool)
+	for i := 0; i < 1000; i++ {
+		id := fmt.Sprintf("https://example.com/posts/%04d", i)
+		times[id] = base.Add(time.Duration(i) * time.Minute)
+		current[id] = true
+	}
+
+	value, trimmed, err := encodeTimes(times, current)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(value) > MAX_PARAM_SIZE {
+		t.Fatalf("value is %d bytes, over %d", len(value), MAX_PARAM_SIZE)
+	}
+	if !trimmed {
+		t.Error("trimmed not set after dropping items still in the feed")
+	}
+}
+
+// testPosted returns count posted items, one a minute
+func testPosted(count int) map[string]config.PostedItem {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	posted := make(map[string]config.PostedItem, count)
+	for i := 0; i < count; i++ {
+		posted[fmt.Sprintf("https://example.com/posts/%05d", i)] = config.PostedItem{
+			StatusID: fmt.Sprint(110000000000000000 + i),
+			PostedAt: base.Add(time.Duration(i) * time.Minute),
+			Hash:     "da39a3ee5e6b4b0d3255bfef95601890afd80709",
+		}
+	}
+	return posted
+}
+
+func TestEncodePostedRoundTrip(t *testing.T) {
+	scheduledAt := time.Date(2024, 2, 1, 9, 0, 0, 0, time.UTC)
+	updated := time.Date(2024, 1, 31, 9, 0, 0, 0, time.UTC)
+	posted := map[string]config.PostedItem{
+		"thread": {StatusID: "1", ThreadIDs: []string{"2", "3"}, PostedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), Hash: "h", Updated: &updated},
+		"later":  {ScheduledID: "9", ScheduledAt: &scheduledAt, PostedAt: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), Hash: "h"},
+	}
+
+	values, err := encodePosted(posted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 1 {
+		t.Fatalf("encoded into %d values, want 1", len(values))
+	}
+	decoded, err := decodePosted(values[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	thread := decoded["thread"]
+	if thread.StatusID != "1" || len(thread.ThreadIDs) != 2 || thread.Updated == nil || !thread.Updated.Equal(updated) {
+		t.Errorf("thread decoded as %+v", thread)
+	}
+	later := decoded["later"]
+	if later.ScheduledID != "9" || later.ScheduledAt == nil || !later.ScheduledAt.Equal(scheduledAt) {
+		t.Errorf("scheduled post decoded as %+v", later)
+	}
+}
+
+func TestEncodePostedSpreads(t *testing.T) {
+	posted := testPosted(500)
+	values, err := encodePosted(posted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) < 2 {
+		t.Fatalf("encoded into %d values, want several", len(values))
+	}
+
+	// Nothing is dropped
+	all := make(map[string]config.PostedItem)
+	for _, value := range values {
+		if len(value) > MAX_PARAM_SIZE {
+			t.Errorf("value is %d bytes, over %d", len(value), MAX_PARAM_SIZE)
+		}
+		decoded, err := decodePosted(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for id, item := range decoded {
+			all[id] = item
+		}
+	}
+	if len(all) != len(posted) {
+		t.Errorf("decoded %d posts, want %d", len(all), len(posted))
+	}
+
+	// Oldest first
+	first, err := decodePosted(values[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := first["https://example.com/posts/00000"]; !ok {
+		t.Error("oldest post not in the first value")
+	}
+}
+
+func TestEncodePostedTooMany(t *testing.T) {
+	_, err := encodePosted(testPosted(MAX_POSTED_PARAMS * 100))
+	var tooMany *TooManyPosted
+	if !errors.As(err, &tooMany) {
+		t.Fatalf("encodePosted returned %v, want *TooManyPosted", err)
+	}
+}
+
+func TestPostedKey(t *testing.T) {
+	if got := postedKey(0); got != "runtime/posted" {
+		t.Errorf("postedKey(0) = %q", got)
+	}
+	if got := postedKey(1); got != "runtime/posted-2" {
+		t.Errorf("postedKey(1) = %q", got)
+	}
+}
+
+// testHeld returns count held items, published an hour apart, with content
+// of the given size
+func testHeld(count int, content int) map[string]*gofeed.Item {
+	held := make(map[string]*gofeed.Item, count)
+	for i := 0; i < count; i++ {
+		published := time.Date(2024, 1, 1, i, 0, 0, 0, time.UTC)
+		id := fmt.Sprintf("https://example.com/%d", i)
+		held[id] = &gofeed.Item{
+			Title:           fmt.Sprintf("Item %d", i),
+			Link:            id,
+			Content:         strings.Repeat("x", content),
+			PublishedParsed: &published,
+			Extensions:      ext.Extensions{"media": {"content": {{Name: "content"}}}},
+		}
+	}
+	return held
+}
+
+func TestEncodeHeldRoundTrip(t *testing.T) {
+	held := testHeld(3, 100)
+	value, err := encodeHeld(held)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded map[string]*gofeed.Item
+	if err := json.Unmarshal([]byte(value), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded) != 3 {
+		t.Fatalf("decoded %d items, want 3", len(decoded))
+	}
+	for id, item := range held {
+		got := decoded[id]
+		if got == nil || got.Title != item.Title || got.Content != item.Content || !got.PublishedParsed.Equal(*item.PublishedParsed) {
+			t.Errorf("%s decoded as %+v", id, got)
+			continue
+		}
+		if got.Extensions != nil {
+			t.Errorf("%s kept its extensions", id)
+		}
+	}
+	// The items held are left alone
+	if held["https://example.com/0"].Extensions == nil {
+		t.Error("extensions removed from the held item")
+	}
+}
+
+func TestEncodeHeldDropsContent(t *testing.T) {
+	value, err := encodeHeld(testHeld(3, MAX_PARAM_SIZE/2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(value) > MAX_PARAM_SIZE {
+		t.Fatalf("encoded %d bytes, over %d", len(value), MAX_PARAM_SIZE)
+	}
+	var decoded map[string]*gofeed.Item
+	if err := json.Unmarshal([]byte(value), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded) != 3 {
+		t.Errorf("kept %d items, want all 3 without their content", len(decoded))
+	}
+	for id, item := range decoded {
+		if item.Content != "" {
+			t.Errorf("%s kept its content", id)
+		}
+	}
+}
+
+func TestEncodeHeldDropsNewest(t *testing.T) {
+	value, err := encodeHeld(testHeld(200, 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(value) > MAX_PARAM_SIZE {
+		t.Fatalf("encoded %d bytes, over %d", len(value), MAX_PARAM_SIZE)
+	}
+	var decoded map[string]*gofeed.Item
+	if err := json.Unmarshal([]byte(value), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded) == 0 || len(decoded) == 200 {
+		t.Fatalf("kept %d of 200 items", len(decoded))
+	}
+	// The oldest are kept
+	for i := 0; i < len(decoded); i++ {
+		if _, ok := decoded[fmt.Sprintf("https://example.com/%d", i)]; !ok {
+			t.Errorf("item %d dropped, but %d items were kept", i, len(decoded))
+		}
+	}
+}
+
+func TestSaveStateDeletesOnlyStored(t *testing.T) {
+	const path = "/mastopost/test/"
+	params, fake := newTestParams(t, map[string]string{
+		path + "rss/feedUrl":          "https://example.com/feed",
+		path + "runtime/etag":         `"v1"`,
+		path + "runtime/lastModified": "Mon, 01 Jan 2024 10:00:00 GMT",
+	})
+
+	_, state, err := params.LoadFeed(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.ETag != `"v1"` {
+		t.Fatalf("loaded ETag %q", state.ETag)
+	}
+
+	// Only the validators that were there are deleted
+	now := time.Now().UTC()
+	state.LastUpdated = &now
+	state.LastPublished = &now
+	state.ETag = ""
+	state.LastModified = ""
+	if err := params.SaveState(path, state); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.deletes) != 1 {
+		t.Fatalf("deleted %v, want the two validators in one call", fake.deletes)
+	}
+	sort.Strings(fake.deletes[0])
+	if strings.Join(fake.deletes[0], ",") != path+"runtime/etag,"+path+"runtime/lastModified" {
+		t.Fatalf("deleted %v, want the two validators", fake.deletes)
+	}
+
+	// Nothing is left to delete on the next run
+	if err := params.SaveState(path, state); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.deletes) != 1 {
+		t.Errorf("deleted %v on a run with nothing to delete", fake.deletes[1:])
+	}
+}
+
+func TestLoadFeedTemplate(t *testing.T) {
+	const path = "/mastopost/test/"
+	template := "{{.Title}}\n\n{{.Link}}"
+	params, _ := newTestParams(t, map[string]string{
+		path + "post/template": EncodeTemplate(template),
+	})
+	feedConfig, _, err := params.LoadFeed(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if feedConfig.Template != template {
+		t.Errorf("loaded template %q, want %q", feedConfig.Template, template)
+	}
+	if strings.Contains(EncodeTemplate(template), "{{") {
+		t.Error("encoded template contains {{")
+	}
+
+	params, _ = newTestParams(t, map[string]string{path + "post/template": template})
+	if _, _, err := params.LoadFeed(path); err == nil {
+		t.Error("LoadFeed accepted a template that isn't encoded")
+	}
+}
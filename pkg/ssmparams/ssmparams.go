@@ -3,6 +3,7 @@ package ssmparams
 import (
 	"context"
 	"fmt"
+	"os"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
@@ -54,6 +55,27 @@ func (e *DeleteParametersError) Error() string {
 	return e.Msg
 }
 
+// TooManyPosted is returned when the statuses of posted items don't fit in
+// MAX_POSTED_PARAMS parameters. Lower the feed's expireafter, or prune more often.
+type TooManyPosted struct {
+	Err    error
+	Msg    string
+	Posts  int
+	Params int
+}
+
+// Error returns the error message
+func (e *TooManyPosted) Error() string {
+	msg := e.Msg
+	if msg == "" {
+		msg = fmt.Sprintf("%d posted statuses need %d parameters, more than the %d allowed. lower expireafter or prune more often", e.Posts, e.Params, MAX_POSTED_PARAMS)
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
 // GetParametersError is an error returned when there is an error with the GetParameters call
 type GetParametersError struct {
 	Err error
@@ -117,6 +139,10 @@ type SSMParamsConfig struct {
 	region  string
 	profile string
 	ssm     *ssm.Client
+
+	// stored are the names of the runtime parameters known to exist, so
+	// SaveState only deletes parameters that are there
+	stored map[string]bool
 }
 
 func New(opts ...func(*SSMParamsConfig)) (*SSMParamsConfig, error) {
@@ -127,6 +153,12 @@ func New(opts ...func(*SSMParamsConfig)) (*SSMParamsConfig, error) {
 		opt(cfg)
 	}
 
+	// Set up the default logger if not set
+	if cfg.log == nil {
+		log := zerolog.New(os.Stderr).With().Timestamp().Logger()
+		cfg.log = &log
+	}
+
 	if cfg.region == "" {
 		return nil, &AWSRegionRequiredError{}
 	}
@@ -204,13 +236,23 @@ func (config *SSMParamsConfig) ListAllParams(path string, nextToken *string) (*s
 	return resp, nil
 }
 
+// DeleteParams deletes the named parameters, in batches of the 10 names DeleteParameters accepts per call
 func (config *SSMParamsConfig) DeleteParams(paramNames []string) (*ssm.DeleteParametersOutput, error) {
-	resp, err := config.ssm.DeleteParameters(context.TODO(), &ssm.DeleteParametersInput{
-		Names: paramNames,
-	})
-	if err != nil {
-		return nil, &DeleteParametersError{Err: err}
+	output := &ssm.DeleteParametersOutput{}
+	for start := 0; start < len(paramNames); start += 10 {
+		end := start + 10
+		if end > len(paramNames) {
+			end = len(paramNames)
+		}
+		resp, err := config.ssm.DeleteParameters(context.TODO(), &ssm.DeleteParametersInput{
+			Names: paramNames[start:end],
+		})
+		if err != nil {
+			return output, &DeleteParametersError{Err: err}
+		}
+		output.DeletedParameters = append(output.DeletedParameters, resp.DeletedParameters...)
+		output.InvalidParameters = append(output.InvalidParameters, resp.InvalidParameters...)
 	}
 
-	return resp, nil
+	return output, nil
 }
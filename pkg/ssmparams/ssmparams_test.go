@@ -0,0 +1,82 @@
+package ssmparams
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/rs/zerolog"
+)
+
+// fakeSSM is an SSM endpoint that keeps the parameters it's sent
+type fakeSSM struct {
+	mu sync.Mutex
+	// params are the stored parameters, by name
+	params map[string]string
+	// deletes are the names sent to each DeleteParameters call
+	deletes [][]string
+}
+
+// newTestParams returns an SSMParamsConfig calling a fake SSM endpoint
+// holding the parameters
+func newTestParams(t *testing.T, params map[string]string) (*SSMParamsConfig, *fakeSSM) {
+	t.Helper()
+	fake := &fakeSSM{params: params}
+	server := httptest.NewServer(http.HandlerFunc(fake.serve))
+	t.Cleanup(server.Close)
+
+	log := zerolog.Nop()
+	return &SSMParamsConfig{
+		log: &log,
+		ssm: ssm.New(ssm.Options{
+			Region:           "us-east-1",
+			Credentials:      aws.AnonymousCredentials{},
+			EndpointResolver: ssm.EndpointResolverFromURL(server.URL),
+		}),
+	}, fake
+}
+
+// serve answers the SSM calls the feed state needs
+func (f *fakeSSM) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var input struct {
+		Name  string
+		Names []string
+		Value string
+		Path  string
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+
+	switch r.Header.Get("X-Amz-Target") {
+	case "AmazonSSM.GetParametersByPath":
+		params := []map[string]string{}
+		for name, value := range f.params {
+			if strings.HasPrefix(name, input.Path) {
+				params = append(params, map[string]string{"Name": name, "Value": value})
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"Parameters": params})
+	case "AmazonSSM.PutParameter":
+		f.params[input.Name] = input.Value
+		w.Write([]byte(`{"Version":1}`))
+	case "AmazonSSM.DeleteParameters":
+		f.deletes = append(f.deletes, input.Names)
+		for _, name := range input.Names {
+			delete(f.params, name)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"DeletedParameters": input.Names})
+	default:
+		http.Error(w, `{"__type":"UnknownOperationException"}`, http.StatusBadRequest)
+	}
+}
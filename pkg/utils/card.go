@@ -0,0 +1,109 @@
+package utils
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+const (
+	// DEFAULT_CARD_TIMEOUT is the time limit for fetching an article and its card image
+	DEFAULT_CARD_TIMEOUT = 10 * time.Second
+
+	// MAX_ARTICLE_SIZE is how much of an article is read looking for its card
+	MAX_ARTICLE_SIZE = 2 * 1024 * 1024
+)
+
+// cardImageTags are the meta tags that name a page's preview image, best first
+var cardImageTags = []string{"og:image:secure_url", "og:image", "og:image:url", "twitter:image", "twitter:image:src"}
+
+// cardAltTags are the meta tags that describe a page's preview image, best first
+var cardAltTags = []string{"og:image:alt", "twitter:image:alt"}
+
+// FindCardImage fetches the article at link and returns the image named by
+// its OpenGraph or Twitter card meta tags. It returns nil if the page has no
+// card image. Images without alt text use title.
+func FindCardImage(client *http.Client, link string, title string) (*MediaRef, error) {
+	doc, page, err := fetchArticle(client, link)
+	if err != nil {
+		return nil, err
+	}
+
+	image := metaContent(doc, cardImageTags)
+	if image == "" {
+		return nil, nil
+	}
+	imageURL, err := page.Parse(image)
+	if err != nil {
+		return nil, &MediaError{URL: image, Err: err}
+	}
+
+	alt := metaContent(doc, cardAltTags)
+	if alt == "" {
+		alt = title
+	}
+
+	return &MediaRef{
+		URL:         imageURL.String(),
+		Type:        metaContent(doc, []string{"og:image:type"}),
+		Description: truncateText(strings.TrimSpace(stripHTML(alt)), MAX_ALT_TEXT),
+	}, nil
+}
+
+// fetchArticle fetches and parses the article at link. It also returns the
+// article's URL after any redirects, for resolving relative links.
+func fetchArticle(client *http.Client, link string) (*goquery.Document, *url.URL, error) {
+	if client == nil {
+		client = &http.Client{Timeout: DEFAULT_CARD_TIMEOUT}
+	}
+
+	page, err := url.Parse(link)
+	if err != nil {
+		return nil, nil, &MediaError{URL: link, Err: err}
+	}
+
+	req, err := http.NewRequest(http.MethodGet, link, nil)
+	if err != nil {
+		return nil, nil, &MediaError{URL: link, Err: err}
+	}
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, &MediaError{URL: link, Err: err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, nil, &MediaError{URL: link, Err: fmt.Errorf("unexpected status: %s", resp.Status)}
+	}
+
+	doc, err := goquery.NewDocumentFromReader(io.LimitReader(resp.Body, MAX_ARTICLE_SIZE))
+	if err != nil {
+		return nil, nil, &MediaError{URL: link, Err: err}
+	}
+
+	if resp.Request != nil && resp.Request.URL != nil {
+		page = resp.Request.URL
+	}
+	return doc, page, nil
+}
+
+// metaContent returns the content of the first of the meta tags that's set.
+// Sites use both property and name for OpenGraph and Twitter tags.
+func metaContent(doc *goquery.Document, tags []string) string {
+	for _, tag := range tags {
+		selector := fmt.Sprintf(`meta[property=%q], meta[name=%q]`, tag, tag)
+		if content, ok := doc.Find(selector).First().Attr("content"); ok {
+			if content = strings.TrimSpace(content); content != "" {
+				return content
+			}
+		}
+	}
+	return ""
+}
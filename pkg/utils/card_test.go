@@ -0,0 +1,64 @@
+package utils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFindCardImage(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/og", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<html><head>
+<meta property="og:image" content="/images/card.png">
+<meta property="og:image:type" content="image/png">
+<meta property="og:image:alt" content="A card">
+</head><body></body></html>`))
+	})
+	mux.HandleFunc("/twitter", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<html><head>
+<meta name="twitter:image" content="https://cdn.example.com/card.jpg">
+</head><body></body></html>`))
+	})
+	mux.HandleFunc("/moved", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/articles/og", http.StatusFound)
+	})
+	mux.HandleFunc("/articles/og", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<html><head><meta property="og:image" content="card.png"></head></html>`))
+	})
+	mux.HandleFunc("/plain", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<html><head><title>No card</title></head></html>`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	tests := []struct {
+		path string
+		want *MediaRef
+	}{
+		{"/og", &MediaRef{URL: server.URL + "/images/card.png", Type: "image/png", Description: "A card"}},
+		{"/twitter", &MediaRef{URL: "https://cdn.example.com/card.jpg", Description: "The title"}},
+		// Relative images are resolved against the page after redirects
+		{"/moved", &MediaRef{URL: server.URL + "/articles/card.png", Description: "The title"}},
+		{"/plain", nil},
+	}
+	for _, tt := range tests {
+		ref, err := FindCardImage(server.Client(), server.URL+tt.path, "The title")
+		if err != nil {
+			t.Errorf("%s: %v", tt.path, err)
+			continue
+		}
+		if (ref == nil) != (tt.want == nil) || (ref != nil && *ref != *tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.path, ref, tt.want)
+		}
+	}
+}
+
+func TestFindCardImageError(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	if _, err := FindCardImage(server.Client(), server.URL+"/missing", "The title"); err == nil {
+		t.Error("FindCardImage didn't fail for a missing article")
+	}
+}
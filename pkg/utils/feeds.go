@@ -0,0 +1,112 @@
+package utils
+
+import (
+	"net/url"
+	"time"
+
+	"github.com/rmrfslashbin/mastopost/pkg/config"
+	"github.com/rmrfslashbin/mastopost/pkg/rssfeed"
+)
+
+// InvalidSetting is returned when a feed setting can't be parsed
+type InvalidSetting struct {
+	Err     error
+	Msg     string
+	Setting string
+}
+
+// Error returns the error message
+func (e *InvalidSetting) Error() string {
+	msg := e.Msg
+	if msg == "" {
+		msg = "invalid feed setting"
+	}
+	if e.Setting != "" {
+		msg += ": " + e.Setting
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+// FeedOptions returns the rssfeed options for a feed's fetch settings and saved state
+func FeedOptions(feedConfig *config.FeedConfig, state *config.FeedLastUpdate) ([]rssfeed.Option, error) {
+	opts := []rssfeed.Option{
+		rssfeed.WithLastUpdated(state.LastUpdated),
+		rssfeed.WithLastPublished(state.LastPublished),
+		rssfeed.WithETag(state.ETag),
+		rssfeed.WithLastModified(state.LastModified),
+		rssfeed.WithSeen(state.Seen),
+		rssfeed.WithFirstSeen(state.FirstSeen),
+		rssfeed.WithPublishedFilter(feedConfig.FilterPublished || state.SeenTrimmed),
+		rssfeed.WithHeaders(feedConfig.Headers),
+	}
+
+	if feedConfig.Timeout != "" {
+		timeout, err := time.ParseDuration(feedConfig.Timeout)
+		if err != nil {
+			return nil, &InvalidSetting{Setting: "timeout", Err: err}
+		}
+		opts = append(opts, rssfeed.WithTimeout(timeout))
+	}
+
+	if feedConfig.MinAge > 0 {
+		opts = append(opts, rssfeed.WithMinAge(time.Duration(feedConfig.MinAge)*time.Minute))
+	}
+
+	if feedConfig.UserAgent != "" {
+		opts = append(opts, rssfeed.WithUserAgent(feedConfig.UserAgent))
+	}
+
+	if feedConfig.BasicAuthUser != "" {
+		opts = append(opts, rssfeed.WithBasicAuth(feedConfig.BasicAuthUser, feedConfig.BasicAuthPassword))
+	}
+
+	if feedConfig.ProxyURL != "" {
+		proxy, err := url.Parse(feedConfig.ProxyURL)
+		if err != nil {
+			return nil, &InvalidSetting{Setting: "proxyurl", Err: err}
+		}
+		opts = append(opts, rssfeed.WithProxy(proxy))
+	}
+
+	return opts, nil
+}
+
+// SaveFeedState copies the feed parser's runtime state into the feed's saved
+// state. With keepPosted the statuses of posted items are kept until they're
+// pruned, rather than forgotten when their items drop out of the seen set.
+func SaveFeedState(state *config.FeedLastUpdate, feed *rssfeed.Config, keepPosted bool) {
+	state.LastPublished = feed.GetLastPublished()
+	state.LastUpdated = feed.GetLastUpdated()
+	state.ETag = feed.GetETag()
+	state.LastModified = feed.GetLastModified()
+	state.Seen = feed.GetSeen()
+	state.FirstSeen = feed.GetFirstSeen()
+	state.Current = feed.GetCurrent()
+	state.SeenTrimmed = false
+
+	// Held items are released once they're posted or skipped
+	for id := range state.Held {
+		if _, ok := state.Seen[id]; ok {
+			delete(state.Held, id)
+		}
+	}
+
+	// Items were held back, so the next fetch mustn't be answered with a 304
+	if feed.Pending() > 0 || len(state.Held) > 0 {
+		state.ETag = ""
+		state.LastModified = ""
+	}
+
+	// Forget the statuses of items that dropped out of the seen set
+	if keepPosted {
+		return
+	}
+	for id := range state.Posted {
+		if _, ok := state.Seen[id]; !ok {
+			delete(state.Posted, id)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package utils
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/rmrfslashbin/mastopost/pkg/config"
+)
+
+func TestFeedOptionsInvalid(t *testing.T) {
+	tests := []struct {
+		setting    string
+		feedConfig config.FeedConfig
+	}{
+		{"timeout", config.FeedConfig{Timeout: "soon"}},
+		{"proxyurl", config.FeedConfig{ProxyURL: "http://proxy:port"}},
+	}
+	for _, tt := range tests {
+		_, err := FeedOptions(&tt.feedConfig, &config.FeedLastUpdate{})
+		var invalid *InvalidSetting
+		if !errors.As(err, &invalid) || invalid.Setting != tt.setting {
+			t.Errorf("%s: FeedOptions returned %v, want *InvalidSetting", tt.setting, err)
+		}
+	}
+
+	feedConfig := config.FeedConfig{Timeout: "10s", ProxyURL: "http://proxy:3128", UserAgent: "test"}
+	if _, err := FeedOptions(&feedConfig, &config.FeedLastUpdate{}); err != nil {
+		t.Errorf("FeedOptions returned %v for valid settings", err)
+	}
+}
+
+func TestInvalidSettingError(t *testing.T) {
+	err := &InvalidSetting{Setting: "timeout", Err: errors.New("bad duration")}
+	want := "invalid feed setting: timeout: bad duration"
+	for i := 0; i < 2; i++ {
+		if got := err.Error(); got != want {
+			t.Errorf("call %d: Error() = %q, want %q", i+1, got, want)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/mmcdole/gofeed"
+	"github.com/rmrfslashbin/mastopost/pkg/config"
+)
+
+// filterFields are the item fields filter rules can match
+var filterFields = []string{"title", "description", "categories", "author", "link"}
+
+// Filter decides which items are posted from a feed's filter rules
+type Filter struct {
+	include []*filterRule
+	exclude []*filterRule
+}
+
+// filterRule is a FilterRule with its regular expression compiled
+type filterRule struct {
+	field      string
+	contains   string
+	regex      *regexp.Regexp
+	ignoreCase bool
+	all        []*filterRule
+	any        []*filterRule
+}
+
+// NewFilter compiles a feed's filter rules. A nil Filters lets every item through.
+func NewFilter(filters *config.Filters) (*Filter, error) {
+	f := &Filter{}
+	if filters == nil {
+		return f, nil
+	}
+
+	var err error
+	if f.include, err = compileRules(filters.Include); err != nil {
+		return nil, err
+	}
+	if f.exclude, err = compileRules(filters.Exclude); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// compileRules checks and compiles a list of filter rules
+func compileRules(rules []config.FilterRule) ([]*filterRule, error) {
+	var compiled []*filterRule
+	for _, rule := range rules {
+		r, err := compileRule(rule)
+		if err != nil {
+			return nil, err
+		}
+		compiled = append(compiled, r)
+	}
+	return compiled, nil
+}
+
+// compileRule checks and compiles a filter rule and any rules it groups
+func compileRule(rule config.FilterRule) (*filterRule, error) {
+	r := &filterRule{
+		field:      strings.ToLower(rule.Field),
+		contains:   rule.Contains,
+		ignoreCase: rule.IgnoreCase,
+	}
+
+	if len(rule.All) > 0 || len(rule.Any) > 0 {
+		var err error
+		if r.all, err = compileRules(rule.All); err != nil {
+			return nil, err
+		}
+		if r.any, err = compileRules(rule.Any); err != nil {
+			return nil, err
+		}
+		return r, nil
+	}
+
+	if r.field != "" && !validField(r.field) {
+		return nil, &InvalidSetting{Setting: "filters", Err: fmt.Errorf("unknown field %q. use %s", rule.Field, strings.Join(filterFields, ", "))}
+	}
+	if rule.Contains == "" && rule.Regex == "" {
+		return nil, &InvalidSetting{Setting: "filters", Err: fmt.Errorf("rule on %q needs contains or regex", rule.Field)}
+	}
+
+	if rule.Regex != "" {
+		expr := rule.Regex
+		if rule.IgnoreCase {
+			expr = "(?i)" + expr
+		}
+		regex, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, &InvalidSetting{Setting: "filters", Err: err}
+		}
+		r.regex = regex
+	}
+	if rule.IgnoreCase {
+		r.contains = strings.ToLower(r.contains)
+	}
+	return r, nil
+}
+
+// validField reports whether field can be matched by a filter rule
+func validField(field string) bool {
+	for _, f := range filterFields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// Check reports whether the item should be posted and, if not, why
+func (f *Filter) Check(item *gofeed.Item) (bool, string) {
+	for _, rule := range f.exclude {
+		if rule.matches(item) {
+			return false, "excluded by " + rule.String()
+		}
+	}
+
+	if len(f.include) == 0 {
+		return true, ""
+	}
+	for _, rule := range f.include {
+		if rule.matches(item) {
+			return true, ""
+		}
+	}
+	return false, "no include rule matched"
+}
+
+// matches reports whether the rule matches the item
+func (r *filterRule) matches(item *gofeed.Item) bool {
+	if r.all != nil || r.any != nil {
+		for _, rule := range r.all {
+			if !rule.matches(item) {
+				return false
+			}
+		}
+		if len(r.any) == 0 {
+			return true
+		}
+		for _, rule := range r.any {
+			if rule.matches(item) {
+				return true
+			}
+		}
+		return false
+	}
+
+	fields := filterFields
+	if r.field != "" {
+		fields = []string{r.field}
+	}
+	for _, field := range fields {
+		for _, value := range fieldValues(item, field) {
+			if r.matchValue(value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchValue reports whether a field value matches the rule's contains and regex
+func (r *filterRule) matchValue(value string) bool {
+	if r.contains != "" {
+		haystack := value
+		if r.ignoreCase {
+			haystack = strings.ToLower(value)
+		}
+		if !strings.Contains(haystack, r.contains) {
+			return false
+		}
+	}
+	return r.regex == nil || r.regex.MatchString(value)
+}
+
+// String describes the rule for logging
+func (r *filterRule) String() string {
+	if r.all != nil || r.any != nil {
+		var parts []string
+		for _, rule := range r.all {
+			parts = append(parts, rule.String())
+		}
+		all := strings.Join(parts, " and ")
+		parts = nil
+		for _, rule := range r.any {
+			parts = append(parts, rule.String())
+		}
+		if len(parts) == 0 {
+			return "(" + all + ")"
+		}
+		if all == "" {
+			return "(" + strings.Join(parts, " or ") + ")"
+		}
+		return "(" + all + " and (" + strings.Join(parts, " or ") + "))"
+	}
+
+	field := r.field
+	if field == "" {
+		field = "any field"
+	}
+	var conds []string
+	if r.contains != "" {
+		conds = append(conds, fmt.Sprintf("contains %q", r.contains))
+	}
+	if r.regex != nil {
+		conds = append(conds, fmt.Sprintf("matches %q", r.regex.String()))
+	}
+	return field + " " + strings.Join(conds, " and ")
+}
+
+// fieldValues returns the text of an item field for matching
+func fieldValues(item *gofeed.Item, field string) []string {
+	switch field {
+	case "title":
+		return []string{item.Title}
+	case "description":
+		return []string{HTMLToText(item.Description), HTMLToText(item.Content)}
+	case "categories":
+		return item.Categories
+	case "author":
+		var values []string
+		if item.Author != nil {
+			values = append(values, item.Author.Name, item.Author.Email)
+		}
+		for _, author := range item.Authors {
+			if author != nil {
+				values = append(values, author.Name, author.Email)
+			}
+		}
+		return values
+	case "link":
+		return append([]string{item.Link}, item.Links...)
+	}
+	return nil
+}
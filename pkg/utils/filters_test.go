@@ -0,0 +1,78 @@
+package utils
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/mmcdole/gofeed"
+	"github.com/rmrfslashbin/mastopost/pkg/config"
+)
+
+func TestFilterCheck(t *testing.T) {
+	filter, err := NewFilter(&config.Filters{
+		Include: []config.FilterRule{
+			{Field: "categories", Contains: "golang", IgnoreCase: true},
+			{All: []config.FilterRule{
+				{Field: "title", Regex: `^Release \d+`},
+				{Any: []config.FilterRule{
+					{Field: "author", Contains: "Jo"},
+					{Field: "link", Contains: "/releases/"},
+				}},
+			}},
+		},
+		Exclude: []config.FilterRule{
+			{Contains: "[sponsored]", IgnoreCase: true},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		item *gofeed.Item
+		want bool
+	}{
+		{"category", &gofeed.Item{Title: "News", Categories: []string{"GoLang"}}, true},
+		{"group", &gofeed.Item{Title: "Release 2", Link: "https://example.com/releases/2"}, true},
+		{"group by author", &gofeed.Item{Title: "Release 3", Author: &gofeed.Person{Name: "Jo"}}, true},
+		{"group without any", &gofeed.Item{Title: "Release 4", Link: "https://example.com/news/4"}, false},
+		{"no rule", &gofeed.Item{Title: "Weather", Categories: []string{"Rust"}}, false},
+		{"excluded", &gofeed.Item{Title: "News", Description: "<p>[Sponsored] post</p>", Categories: []string{"golang"}}, false},
+	}
+	for _, tt := range tests {
+		ok, reason := filter.Check(tt.item)
+		if ok != tt.want {
+			t.Errorf("%s: Check() = %v (%s), want %v", tt.name, ok, reason, tt.want)
+		}
+		if !ok && reason == "" {
+			t.Errorf("%s: skipped without a reason", tt.name)
+		}
+	}
+}
+
+func TestFilterNil(t *testing.T) {
+	filter, err := NewFilter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := filter.Check(&gofeed.Item{Title: "Anything"}); !ok {
+		t.Error("a nil filter skipped an item")
+	}
+}
+
+func TestFilterInvalid(t *testing.T) {
+	tests := []config.FilterRule{
+		{Field: "body", Contains: "x"},
+		{Field: "title"},
+		{Field: "title", Regex: "("},
+		{All: []config.FilterRule{{Field: "nope", Contains: "x"}}},
+	}
+	for _, rule := range tests {
+		_, err := NewFilter(&config.Filters{Exclude: []config.FilterRule{rule}})
+		var invalid *InvalidSetting
+		if !errors.As(err, &invalid) {
+			t.Errorf("%+v: NewFilter returned %v, want *InvalidSetting", rule, err)
+		}
+	}
+}
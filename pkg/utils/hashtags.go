@@ -0,0 +1,110 @@
+package utils
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Hashtagger turns an item's categories into hashtags
+type Hashtagger struct {
+	rename map[string]string
+	static []string
+	max    int
+}
+
+// NewHashtagger creates a Hashtagger. rename maps categories (ignoring case)
+// to the tag to use instead, or to "" to drop them. static tags are added to
+// every post. max caps the number of tags; zero means no cap.
+func NewHashtagger(rename map[string]string, static []string, max int) *Hashtagger {
+	h := &Hashtagger{
+		rename: make(map[string]string, len(rename)),
+		max:    max,
+	}
+	for category, tag := range rename {
+		h.rename[strings.ToLower(strings.TrimSpace(category))] = tag
+	}
+	seen := make(map[string]bool)
+	for _, tag := range static {
+		if tag = SanitizeHashtag(tag); tag != "" && !seen[strings.ToLower(tag)] {
+			seen[strings.ToLower(tag)] = true
+			h.static = append(h.static, tag)
+		}
+	}
+	return h
+}
+
+// Tags returns the hashtags for an item's categories, with the static tags
+// last. Tags are sanitized and duplicates dropped, ignoring case. If there are
+// more than max, tags from the end of the categories are dropped first.
+func (h *Hashtagger) Tags(categories []string) []string {
+	seen := make(map[string]bool)
+	for _, tag := range h.static {
+		seen[strings.ToLower(tag)] = true
+	}
+
+	var tags []string
+	for _, category := range categories {
+		tag := SanitizeHashtag(category)
+		if renamed, ok := h.rename[strings.ToLower(strings.TrimSpace(category))]; ok {
+			tag = SanitizeHashtag(renamed)
+		}
+		if tag == "" || seen[strings.ToLower(tag)] {
+			continue
+		}
+		seen[strings.ToLower(tag)] = true
+		tags = append(tags, "#"+tag)
+	}
+
+	// Make room for the static tags
+	if h.max > 0 {
+		keep := h.max - len(h.static)
+		if keep < 0 {
+			keep = 0
+		}
+		if len(tags) > keep {
+			tags = tags[:keep]
+		}
+	}
+	for _, tag := range h.static {
+		tags = append(tags, "#"+tag)
+	}
+	if h.max > 0 && len(tags) > h.max {
+		tags = tags[:h.max]
+	}
+	return tags
+}
+
+// SanitizeHashtag turns text into a hashtag Mastodon accepts, without the #.
+// Words are run together in CamelCase and anything but letters, digits and
+// underscores is removed. It returns "" if nothing usable is left, as tags
+// can't be all digits.
+func SanitizeHashtag(text string) string {
+	var b strings.Builder
+	upper := true
+	letters := false
+	for _, r := range strings.TrimPrefix(strings.TrimSpace(text), "#") {
+		switch {
+		case unicode.IsLetter(r) || unicode.Is(unicode.Mn, r) || unicode.Is(unicode.Mc, r):
+			if upper {
+				r = unicode.ToUpper(r)
+			}
+			b.WriteRune(r)
+			upper = false
+			letters = letters || unicode.IsLetter(r)
+		case unicode.IsDigit(r):
+			b.WriteRune(r)
+			upper = true
+		case r == '_':
+			b.WriteRune(r)
+			upper = false
+			letters = true
+		default:
+			// Spaces and punctuation split words
+			upper = true
+		}
+	}
+	if !letters {
+		return ""
+	}
+	return b.String()
+}
@@ -0,0 +1,77 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSanitizeHashtag(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"golang", "Golang"},
+		{"machine learning", "MachineLearning"},
+		{"#already", "Already"},
+		{"C++ / Rust", "CRust"},
+		{"snake_case", "Snake_case"},
+		{"café", "Café"},
+		{"Web 3.0", "Web30"},
+		{"2024", ""},
+		{"!!!", ""},
+	}
+	for _, tt := range tests {
+		if got := SanitizeHashtag(tt.text); got != tt.want {
+			t.Errorf("SanitizeHashtag(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestHashtaggerTags(t *testing.T) {
+	tests := []struct {
+		name       string
+		rename     map[string]string
+		static     []string
+		max        int
+		categories []string
+		want       []string
+	}{
+		{
+			name:       "plain",
+			categories: []string{"go", "Go", "open source"},
+			want:       []string{"#Go", "#OpenSource"},
+		},
+		{
+			name:       "renamed and dropped",
+			rename:     map[string]string{"Golang": "Go", "uncategorized": ""},
+			categories: []string{"golang", "Uncategorized", "news"},
+			want:       []string{"#Go", "#News"},
+		},
+		{
+			name:       "static last",
+			static:     []string{"#bot", "News"},
+			categories: []string{"news", "weather"},
+			want:       []string{"#Weather", "#Bot", "#News"},
+		},
+		{
+			name:       "capped",
+			static:     []string{"bot"},
+			max:        3,
+			categories: []string{"one", "two", "three"},
+			want:       []string{"#One", "#Two", "#Bot"},
+		},
+		{
+			name:       "capped below the static tags",
+			static:     []string{"bot", "news"},
+			max:        1,
+			categories: []string{"one"},
+			want:       []string{"#Bot"},
+		},
+	}
+	for _, tt := range tests {
+		got := NewHashtagger(tt.rename, tt.static, tt.max).Tags(tt.categories)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package utils
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+	ext "github.com/mmcdole/gofeed/extensions"
+	"github.com/rmrfslashbin/mastopost/pkg/mastoclient"
+)
+
+const (
+	// MAX_ALT_TEXT is the longest media description Mastodon accepts
+	MAX_ALT_TEXT = 1500
+
+	// DEFAULT_MEDIA_TIMEOUT is the time limit for downloading an image
+	DEFAULT_MEDIA_TIMEOUT = 15 * time.Second
+)
+
+// MediaError is returned when an item's image can't be used
+type MediaError struct {
+	Err error
+	Msg string
+	URL string
+}
+
+// Error returns the error message
+func (e *MediaError) Error() string {
+	msg := e.Msg
+	if msg == "" {
+		msg = "unable to get media"
+	}
+	if e.URL != "" {
+		msg += ": " + e.URL
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+// MediaRef is an image referenced by a feed item
+type MediaRef struct {
+	// URL is where the image is
+	URL string
+
+	// Type is the MIME type the feed gives for the image, if any
+	Type string
+
+	// Description is the alt text for the image
+	Description string
+}
+
+// FindMedia returns the images attached to an item through enclosures,
+// media:content or the item's image, in that order. Images without a
+// description of their own use the item's title as alt text.
+func FindMedia(item *gofeed.Item) []MediaRef {
+	var refs []MediaRef
+	seen := make(map[string]bool)
+	add := func(ref MediaRef) {
+		if ref.URL == "" || seen[ref.URL] || !isImage(ref.URL, ref.Type) {
+			return
+		}
+		seen[ref.URL] = true
+		if ref.Description == "" {
+			ref.Description = item.Title
+		}
+		ref.Description = truncateText(strings.TrimSpace(stripHTML(ref.Description)), MAX_ALT_TEXT)
+		refs = append(refs, ref)
+	}
+
+	for _, enclosure := range item.Enclosures {
+		if enclosure != nil {
+			add(MediaRef{URL: enclosure.URL, Type: enclosure.Type})
+		}
+	}
+
+	if media, ok := item.Extensions["media"]; ok {
+		for _, content := range mediaContent(media) {
+			if medium := content.Attrs["medium"]; medium != "" && medium != "image" {
+				continue
+			}
+			add(MediaRef{
+				URL:         content.Attrs["url"],
+				Type:        content.Attrs["type"],
+				Description: extensionText(content.Children, "description", extensionText(media, "description", "")),
+			})
+		}
+	}
+
+	if item.Image != nil {
+		add(MediaRef{URL: item.Image.URL, Description: item.Image.Title})
+	}
+
+	return refs
+}
+
+// mediaContent returns the media:content elements of an item, including
+// those inside media:group
+func mediaContent(media map[string][]ext.Extension) []ext.Extension {
+	contents := append([]ext.Extension(nil), media["content"]...)
+	for _, group := range media["group"] {
+		contents = append(contents, group.Children["content"]...)
+	}
+	return contents
+}
+
+// extensionText returns the text of the first extension element called name, or fallback
+func extensionText(elements map[string][]ext.Extension, name string, fallback string) string {
+	for _, element := range elements[name] {
+		if value := strings.TrimSpace(element.Value); value != "" {
+			return value
+		}
+	}
+	return fallback
+}
+
+// isImage guesses whether a media reference is an image from its type or file extension
+func isImage(link string, mimeType string) bool {
+	if mimeType != "" {
+		return strings.HasPrefix(strings.ToLower(mimeType), "image/")
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(mime.TypeByExtension(strings.ToLower(path.Ext(u.Path))), "image/")
+}
+
+// DownloadMedia downloads an image so it can be attached to a post. Images
+// larger than maxSize bytes or not of one of the allowed types are refused.
+func DownloadMedia(client *http.Client, ref MediaRef, maxSize int64, allowed []string) (*mastoclient.Media, error) {
+	if client == nil {
+		client = &http.Client{Timeout: DEFAULT_MEDIA_TIMEOUT}
+	}
+
+	resp, err := client.Get(ref.URL)
+	if err != nil {
+		return nil, &MediaError{URL: ref.URL, Err: err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, &MediaError{URL: ref.URL, Err: fmt.Errorf("unexpected status: %s", resp.Status)}
+	}
+	if resp.ContentLength > maxSize {
+		return nil, &MediaError{URL: ref.URL, Msg: fmt.Sprintf("media is larger than %d bytes", maxSize)}
+	}
+
+	// Read one byte past the limit to tell if the image is too big
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		return nil, &MediaError{URL: ref.URL, Err: err}
+	}
+	if int64(len(data)) > maxSize {
+		return nil, &MediaError{URL: ref.URL, Msg: fmt.Sprintf("media is larger than %d bytes", maxSize)}
+	}
+
+	// Trust the content over the headers, which are often generic
+	mimeType := http.DetectContentType(data)
+	if !strings.HasPrefix(mimeType, "image/") {
+		if header, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type")); err == nil {
+			mimeType = header
+		}
+	}
+	if !allowedType(mimeType, allowed) {
+		return nil, &MediaError{URL: ref.URL, Msg: "unsupported media type " + mimeType}
+	}
+
+	return &mastoclient.Media{
+		Data:        data,
+		Filename:    mediaFilename(ref.URL, mimeType),
+		Type:        mimeType,
+		Description: ref.Description,
+	}, nil
+}
+
+// allowedType reports whether mimeType is an image type in allowed
+func allowedType(mimeType string, allowed []string) bool {
+	if !strings.HasPrefix(mimeType, "image/") {
+		return false
+	}
+	for _, t := range allowed {
+		if strings.EqualFold(t, mimeType) {
+			return true
+		}
+	}
+	return false
+}
+
+// mediaFilename names an upload after the last part of its URL, with an extension matching its type
+func mediaFilename(link string, mimeType string) string {
+	name := "image"
+	if u, err := url.Parse(link); err == nil {
+		if base := path.Base(u.Path); base != "." && base != "/" {
+			if mime.TypeByExtension(path.Ext(base)) == mimeType {
+				return base
+			}
+			name = strings.TrimSuffix(base, path.Ext(base))
+		}
+	}
+	if ext, ok := imageExtensions[mimeType]; ok {
+		return name + ext
+	}
+	if exts, err := mime.ExtensionsByType(mimeType); err == nil && len(exts) > 0 {
+		return name + exts[0]
+	}
+	return name
+}
+
+// imageExtensions are the usual file extensions for common image types
+var imageExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
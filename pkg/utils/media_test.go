@@ -0,0 +1,119 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mmcdole/gofeed"
+)
+
+const mediaFeed = `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0" xmlns:media="http://search.yahoo.com/mrss/">
+<channel>
+<title>Media feed</title>
+<item>
+<title>A &lt;b&gt;picture&lt;/b&gt; post</title>
+<link>https://example.com/post</link>
+<enclosure url="https://example.com/photo.jpg" type="image/jpeg" length="1000"/>
+<enclosure url="https://example.com/episode.mp3" type="audio/mpeg" length="1000"/>
+<media:content url="https://example.com/photo.jpg" medium="image"/>
+<media:content url="https://example.com/clip.mp4" medium="video"/>
+<media:group>
+<media:content url="https://example.com/chart.png">
+<media:description>A chart</media:description>
+</media:content>
+</media:group>
+</item>
+</channel>
+</rss>`
+
+func TestFindMedia(t *testing.T) {
+	feed, err := gofeed.NewParser().ParseString(mediaFeed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refs := FindMedia(feed.Items[0])
+	want := []MediaRef{
+		{URL: "https://example.com/photo.jpg", Description: "A picture post"},
+		{URL: "https://example.com/chart.png", Description: "A chart"},
+	}
+	if len(refs) != len(want) {
+		t.Fatalf("found %+v, want %+v", refs, want)
+	}
+	for i := range want {
+		if refs[i].URL != want[i].URL || refs[i].Description != want[i].Description {
+			t.Errorf("media %d is %+v, want %+v", i, refs[i], want[i])
+		}
+	}
+}
+
+// testPNG returns a small PNG image
+func testPNG(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDownloadMedia(t *testing.T) {
+	data := testPNG(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Servers often send a generic type; the content decides
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(data)
+	}))
+	defer server.Close()
+
+	allowed := []string{"image/png", "image/jpeg"}
+	media, err := DownloadMedia(server.Client(), MediaRef{URL: server.URL + "/images/chart", Description: "A chart"}, 1024, allowed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if media.Type != "image/png" || media.Filename != "chart.png" || media.Description != "A chart" || !bytes.Equal(media.Data, data) {
+		t.Errorf("got %s %s %q with %d bytes", media.Type, media.Filename, media.Description, len(media.Data))
+	}
+
+	var mediaErr *MediaError
+	if _, err := DownloadMedia(server.Client(), MediaRef{URL: server.URL + "/chart.png"}, int64(len(data)-1), allowed); !errors.As(err, &mediaErr) {
+		t.Errorf("oversized image returned %v, want *MediaError", err)
+	}
+	if _, err := DownloadMedia(server.Client(), MediaRef{URL: server.URL + "/chart.png"}, 1024, []string{"image/jpeg"}); !errors.As(err, &mediaErr) {
+		t.Errorf("unsupported image returned %v, want *MediaError", err)
+	}
+}
+
+func TestMediaFilename(t *testing.T) {
+	tests := []struct {
+		link     string
+		mimeType string
+		want     string
+	}{
+		{"https://example.com/a/photo.jpg", "image/jpeg", "photo.jpg"},
+		{"https://example.com/a/photo.jpg?w=800", "image/png", "photo.png"},
+		{"https://example.com/a/photo", "image/webp", "photo.webp"},
+		{"https://example.com/", "image/gif", "image.gif"},
+	}
+	for _, tt := range tests {
+		if got := mediaFilename(tt.link, tt.mimeType); got != tt.want {
+			t.Errorf("mediaFilename(%q, %q) = %q, want %q", tt.link, tt.mimeType, got, tt.want)
+		}
+	}
+}
+
+func TestMediaError(t *testing.T) {
+	err := &MediaError{URL: "https://example.com/a.png", Err: errors.New("not found")}
+	want := "unable to get media: https://example.com/a.png: not found"
+	for i := 0; i < 2; i++ {
+		if got := err.Error(); got != want {
+			t.Errorf("call %d: Error() = %q, want %q", i+1, got, want)
+		}
+	}
+}
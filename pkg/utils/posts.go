@@ -2,35 +2,563 @@ package utils
 
 import (
 	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"unicode/utf8"
 
-	"github.com/iancoleman/strcase"
 	"github.com/mattn/go-mastodon"
+	"github.com/mmcdole/gofeed"
+	"github.com/rmrfslashbin/mastopost/pkg/config"
+	"github.com/rmrfslashbin/mastopost/pkg/mastoclient"
 	"github.com/rmrfslashbin/mastopost/pkg/rssfeed"
 )
 
-// makePost formats the RSS item into a Mastodon post
-func MakePost(item rssfeed.NewItems) (*mastodon.Toot, error) {
-	author := ""
-	hashtags := ""
+const (
+	// LANGUAGE_AUTO takes a post's language from the item's xml:lang or
+	// dc:language, or else the feed's language
+	LANGUAGE_AUTO = "auto"
+)
+
+// visibilities are the post visibilities a feed can use
+var visibilities = []string{"public", "unlisted", "private"}
+
+// MIN_TITLE_LENGTH is how short a title gets before hashtags are dropped to fit a post
+const MIN_TITLE_LENGTH = 60
+
+// DEFAULT_MAX_THREAD_POSTS is the most posts MakeThread splits an item into
+// unless set with WithThread
+const DEFAULT_MAX_THREAD_POSTS = 10
+
+// PostTooLong is returned when a post can't be shortened to fit the instance's limit
+type PostTooLong struct {
+	Err    error
+	Msg    string
+	Length int
+	Max    int
+}
+
+// Error returns the error message
+func (e *PostTooLong) Error() string {
+	msg := e.Msg
+	if msg == "" {
+		msg = fmt.Sprintf("post is %d characters, over the limit of %d", e.Length, e.Max)
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
 
-	if item.Author != nil {
-		if item.Author.Name != "" {
-			author = " by " + item.Author.Name
+// PostData is what post templates are rendered against. The item's fields
+// are available directly, e.g. {{.Title}} or {{.Link}}.
+type PostData struct {
+	*gofeed.Item
+
+	// Feed is the feed the item came from
+	Feed *gofeed.Feed
+
+	// FeedName is the name of the feed in the config
+	FeedName string
+
+	// Excerpt is a plain text excerpt of the item, see WithExcerpt
+	Excerpt string
+
+	// Hashtags are the item's categories as hashtags, plus the feed's static
+	// tags, see WithHashtags
+	Hashtags []string
+}
+
+// PostOption is a function that can be used to configure the PostBuilder
+type PostOption func(b *PostBuilder)
+
+// PostBuilder formats RSS items into Mastodon posts
+type PostBuilder struct {
+	template      *template.Template
+	text          string
+	feed          *gofeed.Feed
+	feedName      string
+	maxCharacters int
+	perURL        int
+	excerpt       string
+	excerptLength int
+	httpClient    *http.Client
+	warnings      []contentWarning
+	visibility    string
+	language      string
+	hashtagger    *Hashtagger
+	maxThread     int
+	unlisted      bool
+}
+
+// contentWarning is a config.ContentWarning with its keywords compiled
+type contentWarning struct {
+	categories map[string]bool
+	keywords   []*regexp.Regexp
+	text       string
+	sensitive  bool
+}
+
+// NewPostBuilder creates a new PostBuilder
+func NewPostBuilder(opts ...PostOption) (*PostBuilder, error) {
+	b := &PostBuilder{
+		maxCharacters: mastoclient.DEFAULT_MAX_CHARACTERS,
+		perURL:        mastoclient.DEFAULT_CHARACTERS_PER_URL,
+		excerptLength: DEFAULT_EXCERPT_LENGTH,
+		hashtagger:    NewHashtagger(nil, nil, 0),
+		maxThread:     DEFAULT_MAX_THREAD_POSTS,
+	}
+
+	// apply the list of options to PostBuilder
+	for _, opt := range opts {
+		opt(b)
+	}
+
+	tmpl, err := ParseTemplate(b.text)
+	if err != nil {
+		return nil, &InvalidSetting{Setting: "template", Err: err}
+	}
+	b.template = tmpl
+
+	if b.visibility != "" && !validVisibility(b.visibility) {
+		return nil, &InvalidSetting{Setting: "visibility", Err: fmt.Errorf("unknown visibility %q. use %s", b.visibility, strings.Join(visibilities, ", "))}
+	}
+
+	if b.language != "" && b.language != LANGUAGE_AUTO {
+		if b.language = languageCode(b.language); b.language == "" {
+			return nil, &InvalidSetting{Setting: "language", Err: fmt.Errorf("not an ISO 639 language code")}
 		}
-		if item.Author.Email != "" {
-			author += " (" + item.Author.Email + ")"
+	}
+
+	switch b.excerpt {
+	case "":
+		b.excerpt = EXCERPT_DESCRIPTION
+	case EXCERPT_DESCRIPTION, EXCERPT_LEAD, EXCERPT_ARTICLE:
+	default:
+		return nil, &InvalidSetting{Setting: "excerpt", Err: fmt.Errorf("unknown source %q. use description, lead or article", b.excerpt)}
+	}
+
+	return b, nil
+}
+
+// WithTemplate sets the text/template used to format posts
+func WithTemplate(text string) PostOption {
+	return func(b *PostBuilder) {
+		b.text = text
+	}
+}
+
+// WithFeed sets the feed metadata available to the template
+func WithFeed(feed *gofeed.Feed) PostOption {
+	return func(b *PostBuilder) {
+		b.feed = feed
+	}
+}
+
+// WithFeedName sets the feed name available to the template
+func WithFeedName(feedName string) PostOption {
+	return func(b *PostBuilder) {
+		b.feedName = feedName
+	}
+}
+
+// WithLimits sets the longest post allowed and how many characters a URL counts as.
+// A max of 0 or less turns off shortening.
+func WithLimits(maxCharacters int, perURL int) PostOption {
+	return func(b *PostBuilder) {
+		b.maxCharacters = maxCharacters
+		b.perURL = perURL
+	}
+}
+
+// WithContentWarnings sets the rules that put content warnings on posts
+func WithContentWarnings(rules []config.ContentWarning) PostOption {
+	return func(b *PostBuilder) {
+		b.warnings = nil
+		for _, rule := range rules {
+			cw := contentWarning{
+				categories: make(map[string]bool),
+				text:       strings.TrimSpace(rule.Text),
+				sensitive:  rule.Sensitive,
+			}
+			for _, category := range rule.Categories {
+				cw.categories[strings.ToLower(strings.TrimSpace(category))] = true
+			}
+			for _, keyword := range rule.Keywords {
+				if keyword = strings.TrimSpace(keyword); keyword != "" {
+					cw.keywords = append(cw.keywords, regexp.MustCompile(`(?i)(^|[^\pL\pN])`+regexp.QuoteMeta(keyword)+`($|[^\pL\pN])`))
+				}
+			}
+			b.warnings = append(b.warnings, cw)
+		}
+	}
+}
+
+// WithHashtags sets how categories become hashtags: rename maps categories to
+// other tags (or "" to drop them), static tags are added to every post and max
+// caps the number of tags
+func WithHashtags(rename map[string]string, static []string, max int) PostOption {
+	return func(b *PostBuilder) {
+		b.hashtagger = NewHashtagger(rename, static, max)
+	}
+}
+
+// WithVisibility sets the visibility of posts: public, unlisted or private.
+// Empty uses the account's default.
+func WithVisibility(visibility string) PostOption {
+	return func(b *PostBuilder) {
+		b.visibility = strings.ToLower(visibility)
+	}
+}
+
+// WithLanguage sets the language of posts as an ISO 639 code, or
+// LANGUAGE_AUTO to take it from the item or feed
+func WithLanguage(language string) PostOption {
+	return func(b *PostBuilder) {
+		b.language = strings.TrimSpace(language)
+	}
+}
+
+// WithExcerpt sets where .Excerpt is taken from (EXCERPT_DESCRIPTION,
+// EXCERPT_LEAD or EXCERPT_ARTICLE) and its longest length in characters
+func WithExcerpt(source string, length int) PostOption {
+	return func(b *PostBuilder) {
+		b.excerpt = source
+		if length > 0 {
+			b.excerptLength = length
 		}
 	}
+}
 
-	if item.Categories != nil {
-		hashtags = "\n"
-		for _, cat := range item.Categories {
-			hashtags += " #" + strcase.ToCamel(cat)
+// WithThread sets the most posts MakeThread splits an item into and whether
+// the follow-ups of public threads are unlisted
+func WithThread(maxPosts int, unlisted bool) PostOption {
+	return func(b *PostBuilder) {
+		if maxPosts > 0 {
+			b.maxThread = maxPosts
 		}
+		b.unlisted = unlisted
+	}
+}
+
+// WithHTTPClient sets the HTTP client used to fetch articles
+func WithHTTPClient(client *http.Client) PostOption {
+	return func(b *PostBuilder) {
+		b.httpClient = client
+	}
+}
+
+// MakePost formats the RSS item into a Mastodon post
+func (b *PostBuilder) MakePost(item rssfeed.NewItems) (*mastodon.Toot, error) {
+	spoiler, sensitive := b.contentWarning(item)
+
+	// The content warning counts towards the instance's limit
+	status, err := b.fit(item, b.maxCharacters-b.length(spoiler))
+	if err != nil {
+		return nil, err
 	}
 
 	newPost := &mastodon.Toot{
-		Status: fmt.Sprintf("%s%s\n\n%s\n\n%s\n\n%s", item.Title, author, item.Published, item.Link, hashtags),
+		Status:      status,
+		SpoilerText: spoiler,
+		Sensitive:   sensitive,
+		Visibility:  b.visibility,
+		Language:    b.languageOf(item),
 	}
 	return newPost, nil
 }
+
+// MakeThread formats the RSS item into a post, or if it's too long for one,
+// a thread of posts split between paragraphs, lines or sentences, each ending
+// with a 1/n counter. Nothing is shortened, except the last post when the
+// thread is capped. The follow-ups of a public thread are unlisted if set
+// with WithThread; it's up to the caller to chain them with InReplyToID.
+func (b *PostBuilder) MakeThread(item rssfeed.NewItems) ([]*mastodon.Toot, error) {
+	spoiler, sensitive := b.contentWarning(item)
+	max := b.maxCharacters - b.length(spoiler)
+
+	status, err := b.render(b.postData(item))
+	if err != nil {
+		return nil, err
+	}
+
+	var parts []string
+	if b.maxCharacters <= 0 || b.length(status) <= max {
+		parts = []string{status}
+	} else if parts = b.splitThread(strings.TrimSpace(status), max); parts == nil {
+		return nil, &PostTooLong{Length: b.length(status), Max: max}
+	}
+
+	language := b.languageOf(item)
+	var toots []*mastodon.Toot
+	for i, part := range parts {
+		toot := &mastodon.Toot{
+			Status:      part,
+			SpoilerText: spoiler,
+			Sensitive:   sensitive,
+			Visibility:  b.visibility,
+			Language:    language,
+		}
+		// Only a public thread's follow-ups are made unlisted, so they're
+		// never more visible than the first post
+		if i > 0 && b.unlisted && b.visibility == "public" {
+			toot.Visibility = "unlisted"
+		}
+		toots = append(toots, toot)
+	}
+	return toots, nil
+}
+
+// splitThread splits a status into parts of at most max characters with
+// room for a counter, adds the counters and caps the thread. It returns nil
+// if there's no room for anything but the counter.
+func (b *PostBuilder) splitThread(status string, max int) []string {
+	for digits := 1; ; digits++ {
+		// Room for "\n\nn/n"
+		room := max - 3 - 2*digits
+		if room < 1 {
+			return nil
+		}
+
+		parts := splitText(status, room, b.length)
+		if len(parts) > b.maxThread {
+			parts = parts[:b.maxThread]
+			last := parts[len(parts)-1]
+			parts[len(parts)-1] = truncateText(last, utf8.RuneCountInString(last)-1)
+		}
+		if len(strconv.Itoa(len(parts))) > digits {
+			continue
+		}
+
+		for i := range parts {
+			parts[i] += fmt.Sprintf("\n\n%d/%d", i+1, len(parts))
+		}
+		return parts
+	}
+}
+
+// languageOf returns the language to post an item in
+func (b *PostBuilder) languageOf(item *gofeed.Item) string {
+	if b.language != LANGUAGE_AUTO {
+		return b.language
+	}
+	if lang := languageCode(rssfeed.ItemLanguage(item)); lang != "" {
+		return lang
+	}
+	if b.feed != nil {
+		return languageCode(b.feed.Language)
+	}
+	return ""
+}
+
+// languageCode reduces a language tag such as "en-US" to the ISO 639 code
+// Mastodon expects, or "" if it isn't one
+func languageCode(tag string) string {
+	code := strings.ToLower(strings.TrimSpace(tag))
+	if i := strings.IndexAny(code, "-_"); i >= 0 {
+		code = code[:i]
+	}
+	if len(code) < 2 || len(code) > 3 {
+		return ""
+	}
+	for _, r := range code {
+		if r < 'a' || r > 'z' {
+			return ""
+		}
+	}
+	return code
+}
+
+// validVisibility reports whether visibility is one a feed can post with
+func validVisibility(visibility string) bool {
+	for _, v := range visibilities {
+		if v == visibility {
+			return true
+		}
+	}
+	return false
+}
+
+// contentWarning returns the content warning for an item and whether its
+// media is sensitive, from the rules it matches
+func (b *PostBuilder) contentWarning(item *gofeed.Item) (string, bool) {
+	var texts []string
+	seen := make(map[string]bool)
+	sensitive := false
+	for _, cw := range b.warnings {
+		if !cw.matches(item) {
+			continue
+		}
+		sensitive = sensitive || cw.sensitive
+		if cw.text != "" && !seen[strings.ToLower(cw.text)] {
+			seen[strings.ToLower(cw.text)] = true
+			texts = append(texts, cw.text)
+		}
+	}
+	return strings.Join(texts, ", "), sensitive
+}
+
+// matches reports whether the item has one of the rule's categories or title keywords
+func (cw *contentWarning) matches(item *gofeed.Item) bool {
+	for _, category := range item.Categories {
+		if cw.categories[strings.ToLower(strings.TrimSpace(category))] {
+			return true
+		}
+	}
+	for _, keyword := range cw.keywords {
+		if keyword.MatchString(item.Title) {
+			return true
+		}
+	}
+	return false
+}
+
+// render executes the template
+func (b *PostBuilder) render(data *PostData) (string, error) {
+	var status strings.Builder
+	if err := b.template.Execute(&status, data); err != nil {
+		return "", err
+	}
+	return status.String(), nil
+}
+
+// excerptOf returns the item's excerpt. If the linked article can't be
+// fetched, the lead paragraph of the item's own content is used.
+func (b *PostBuilder) excerptOf(item *gofeed.Item) string {
+	if b.excerpt == EXCERPT_ARTICLE && item.Link != "" {
+		if lead, err := ArticleLead(b.httpClient, item.Link); err == nil && lead != "" {
+			return truncateText(lead, b.excerptLength)
+		}
+	}
+	return Excerpt(item.Description, item.Content, b.excerpt, b.excerptLength)
+}
+
+// fit renders the item, shortening it until the post is at most max
+// characters. The excerpt, content and description go first, then the title
+// down to MIN_TITLE_LENGTH, then hashtags from the end (static tags last) and
+// finally the rest of the title. The link is never touched.
+func (b *PostBuilder) fit(item *gofeed.Item, max int) (string, error) {
+	data := b.postData(item)
+	short := data.Item
+
+	status, err := b.render(data)
+	if err != nil || b.maxCharacters <= 0 || b.length(status) <= max {
+		return status, err
+	}
+
+	shrinks := []struct {
+		field *string
+		floor int
+	}{
+		{&data.Excerpt, 0},
+		{&short.Content, 0},
+		{&short.Description, 0},
+		{&short.Title, MIN_TITLE_LENGTH},
+	}
+	for _, shrink := range shrinks {
+		if status, err = b.shrink(data, shrink.field, shrink.floor, status, max); err != nil || b.length(status) <= max {
+			return status, err
+		}
+	}
+
+	// Drop hashtags from the end, keeping the static tags to last
+	static := len(b.hashtagger.static)
+	if static > len(data.Hashtags) {
+		static = len(data.Hashtags)
+	}
+	for len(data.Hashtags) > 0 {
+		drop := len(data.Hashtags) - static - 1
+		if drop < 0 {
+			drop = len(data.Hashtags) - 1
+			static--
+		}
+		data.Hashtags = append(data.Hashtags[:drop:drop], data.Hashtags[drop+1:]...)
+		if status, err = b.render(data); err != nil || b.length(status) <= max {
+			return status, err
+		}
+	}
+
+	// Templates may use the categories directly
+	for len(short.Categories) > 0 {
+		short.Categories = short.Categories[:len(short.Categories)-1]
+		if status, err = b.render(data); err != nil || b.length(status) <= max {
+			return status, err
+		}
+	}
+
+	if status, err = b.shrink(data, &short.Title, 0, status, max); err != nil || b.length(status) <= max {
+		return status, err
+	}
+
+	return "", &PostTooLong{Length: b.length(status), Max: max}
+}
+
+// postData returns the data to render an item's post with. It holds a copy
+// of the item, so shortening its fields doesn't change the item itself.
+func (b *PostBuilder) postData(item *gofeed.Item) *PostData {
+	short := *item
+	short.Categories = append([]string(nil), item.Categories...)
+	data := &PostData{
+		Item:     &short,
+		Feed:     b.feed,
+		FeedName: b.feedName,
+		Hashtags: b.hashtagger.Tags(item.Categories),
+	}
+	if b.usesExcerpt() {
+		data.Excerpt = b.excerptOf(item)
+	}
+	return data
+}
+
+// usesExcerpt reports whether the template refers to .Excerpt, so articles
+// aren't fetched for nothing
+func (b *PostBuilder) usesExcerpt() bool {
+	return strings.Contains(b.text, ".Excerpt")
+}
+
+// shrink cuts field down towards floor characters until the post fits. It
+// gives up if cutting the field doesn't shorten the post, as when the
+// template doesn't use it.
+func (b *PostBuilder) shrink(data *PostData, field *string, floor int, status string, max int) (string, error) {
+	for {
+		size := utf8.RuneCountInString(*field)
+		over := b.length(status) - max
+		if over <= 0 || size <= floor {
+			return status, nil
+		}
+
+		target := size - over
+		if target < floor {
+			target = floor
+		}
+		if target >= size {
+			target = size - 1
+		}
+		*field = truncateText(*field, target)
+
+		next, err := b.render(data)
+		if err != nil {
+			return "", err
+		}
+		if b.length(next) >= b.length(status) {
+			return next, nil
+		}
+		status = next
+	}
+}
+
+// length returns the length of a post as the instance counts it
+func (b *PostBuilder) length(status string) int {
+	return StatusLength(status, b.perURL)
+}
+
+// MakePost formats the RSS item into a Mastodon post using the default template
+func MakePost(item rssfeed.NewItems) (*mastodon.Toot, error) {
+	b, err := NewPostBuilder()
+	if err != nil {
+		return nil, err
+	}
+	return b.MakePost(item)
+}
@@ -0,0 +1,125 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mmcdole/gofeed"
+	ext "github.com/mmcdole/gofeed/extensions"
+	"github.com/rmrfslashbin/mastopost/pkg/config"
+)
+
+func TestContentWarnings(t *testing.T) {
+	b, err := NewPostBuilder(
+		WithTemplate("{{.Title}}"),
+		WithLimits(30, 23),
+		WithContentWarnings([]config.ContentWarning{
+			{Categories: []string{"Politics"}, Text: "politics"},
+			{Keywords: []string{"spoiler"}, Text: "spoilers", Sensitive: true},
+			{Categories: []string{"elections"}, Text: "Politics"},
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		item      *gofeed.Item
+		spoiler   string
+		sensitive bool
+	}{
+		{"none", &gofeed.Item{Title: "Weather today"}, "", false},
+		{"category", &gofeed.Item{Title: "Vote", Categories: []string{" politics "}}, "politics", false},
+		{"keyword", &gofeed.Item{Title: "Spoiler: the butler did it"}, "spoilers", true},
+		{"keyword within a word", &gofeed.Item{Title: "Spoilers everywhere"}, "", false},
+		{"combined", &gofeed.Item{Title: "Election spoiler", Categories: []string{"Politics", "Elections"}}, "politics, spoilers", true},
+	}
+	for _, tt := range tests {
+		toot, err := b.MakePost(tt.item)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if toot.SpoilerText != tt.spoiler || toot.Sensitive != tt.sensitive {
+			t.Errorf("%s: got %q, %v; want %q, %v", tt.name, toot.SpoilerText, toot.Sensitive, tt.spoiler, tt.sensitive)
+		}
+		// The content warning counts towards the limit
+		if length := StatusLength(toot.Status, 23) + StatusLength(toot.SpoilerText, 23); length > 30 {
+			t.Errorf("%s: post is %d characters with its content warning", tt.name, length)
+		}
+	}
+}
+
+func TestContentWarningShortensPost(t *testing.T) {
+	b, err := NewPostBuilder(
+		WithTemplate("{{.Title}}"),
+		WithLimits(100, 23),
+		WithContentWarnings([]config.ContentWarning{{Categories: []string{"long"}, Text: strings.Repeat("x", 40)}}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	toot, err := b.MakePost(&gofeed.Item{Title: strings.Repeat("word ", 19) + "end", Categories: []string{"long"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if length := StatusLength(toot.Status, 23); length > 60 {
+		t.Errorf("post is %d characters, want at most 60 next to its content warning", length)
+	}
+}
+
+func TestVisibility(t *testing.T) {
+	b, err := NewPostBuilder(WithVisibility("Unlisted"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	toot, err := b.MakePost(&gofeed.Item{Title: "A title"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if toot.Visibility != "unlisted" {
+		t.Errorf("visibility = %q, want unlisted", toot.Visibility)
+	}
+
+	for _, visibility := range []string{"direct", "everyone"} {
+		if _, err := NewPostBuilder(WithVisibility(visibility)); err == nil {
+			t.Errorf("NewPostBuilder accepted visibility %q", visibility)
+		}
+	}
+}
+
+func TestLanguage(t *testing.T) {
+	feed := &gofeed.Feed{Language: "de-DE"}
+	tests := []struct {
+		name     string
+		language string
+		item     *gofeed.Item
+		want     string
+	}{
+		{"none", "", &gofeed.Item{Title: "A title"}, ""},
+		{"set", "en-US", &gofeed.Item{Title: "A title", Custom: map[string]string{"xml:lang": "fr"}}, "en"},
+		{"xml:lang", LANGUAGE_AUTO, &gofeed.Item{Title: "A title", Custom: map[string]string{"xml:lang": "fr-CA"}}, "fr"},
+		{"dc:language", LANGUAGE_AUTO, &gofeed.Item{Title: "A title", DublinCoreExt: &ext.DublinCoreExtension{Language: []string{"es"}}}, "es"},
+		{"feed", LANGUAGE_AUTO, &gofeed.Item{Title: "A title"}, "de"},
+	}
+	for _, tt := range tests {
+		b, err := NewPostBuilder(WithLanguage(tt.language), WithFeed(feed))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		toot, err := b.MakePost(tt.item)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if toot.Language != tt.want {
+			t.Errorf("%s: language = %q, want %q", tt.name, toot.Language, tt.want)
+		}
+	}
+
+	if _, err := NewPostBuilder(WithLanguage("english")); err == nil {
+		t.Error("NewPostBuilder accepted language \"english\"")
+	}
+}
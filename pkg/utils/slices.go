@@ -0,0 +1,11 @@
+package utils
+
+// Contains reports whether list holds s
+func Contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,24 @@
+package utils
+
+import "testing"
+
+func TestContains(t *testing.T) {
+	list := []string{"a", "b"}
+	tests := []struct {
+		s    string
+		want bool
+	}{
+		{s: "a", want: true},
+		{s: "b", want: true},
+		{s: "c", want: false},
+		{s: "", want: false},
+	}
+	for _, tt := range tests {
+		if got := Contains(list, tt.s); got != tt.want {
+			t.Errorf("Contains(%v, %q) = %v, want %v", list, tt.s, got, tt.want)
+		}
+	}
+	if Contains(nil, "a") {
+		t.Error("empty list holds a")
+	}
+}
@@ -0,0 +1,93 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// DEFAULT_TEMPLATE is the post template used when a feed doesn't set one
+const DEFAULT_TEMPLATE = `{{.Title}}{{with .Author}}{{if .Name}} by {{.Name}}{{end}}{{if .Email}} ({{.Email}}){{end}}{{end}}
+
+{{.Published}}
+
+{{.Link}}
+
+{{if .Hashtags}}
+{{range .Hashtags}} {{.}}{{end}}{{end}}`
+
+// TemplateFuncs are the helper functions available to post templates
+var TemplateFuncs = template.FuncMap{
+	"truncate":   truncate,
+	"date":       formatDate,
+	"stripHTML":  stripHTML,
+	"text":       HTMLToText,
+	"hashtagify": hashtagify,
+	"lower":      strings.ToLower,
+	"upper":      strings.ToUpper,
+}
+
+// ParseTemplate parses a post template, falling back to DEFAULT_TEMPLATE if it's empty
+func ParseTemplate(text string) (*template.Template, error) {
+	if text == "" {
+		text = DEFAULT_TEMPLATE
+	}
+	return template.New("post").Funcs(TemplateFuncs).Parse(text)
+}
+
+// truncate shortens s to at most n characters, ending it with an ellipsis if cut.
+// Arguments are ordered for pipelines: {{.Title | truncate 80}}
+func truncate(n int, s string) string {
+	if n < 1 {
+		return s
+	}
+	return truncateText(s, n)
+}
+
+// formatDate formats a time with a Go layout in an IANA time zone.
+// Arguments are ordered for pipelines: {{.PublishedParsed | date "Jan 2, 2006 15:04 MST" "America/New_York"}}
+func formatDate(layout string, zone string, t interface{}) (string, error) {
+	var when time.Time
+	switch v := t.(type) {
+	case time.Time:
+		when = v
+	case *time.Time:
+		if v == nil {
+			return "", nil
+		}
+		when = *v
+	default:
+		return "", fmt.Errorf("date: unsupported value %T", t)
+	}
+
+	loc, err := time.LoadLocation(zone)
+	if err != nil {
+		return "", err
+	}
+	return when.In(loc).Format(layout), nil
+}
+
+// stripHTML removes markup from s, leaving its text with entities decoded
+func stripHTML(s string) string {
+	var b strings.Builder
+	tokens := html.NewTokenizer(strings.NewReader(s))
+	for {
+		switch tokens.Next() {
+		case html.ErrorToken:
+			return strings.TrimSpace(b.String())
+		case html.TextToken:
+			b.Write(tokens.Text())
+		}
+	}
+}
+
+// hashtagify turns a category into a hashtag, or "" if it can't be one
+func hashtagify(s string) string {
+	if tag := SanitizeHashtag(s); tag != "" {
+		return "#" + tag
+	}
+	return ""
+}
@@ -0,0 +1,73 @@
+package utils
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+)
+
+func TestTemplateFuncs(t *testing.T) {
+	published := time.Date(2024, 1, 2, 15, 4, 0, 0, time.UTC)
+	item := &gofeed.Item{
+		Title:           "A <b>bold</b> title",
+		Link:            "https://example.com/post",
+		PublishedParsed: &published,
+		Categories:      []string{"Go lang"},
+	}
+
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{`{{.Title | stripHTML}}`, "A bold title"},
+		{`{{.Link | upper}}`, "HTTPS://EXAMPLE.COM/POST"},
+		{`{{.PublishedParsed | date "2006-01-02 15:04 MST" "America/New_York"}}`, "2024-01-02 10:04 EST"},
+		{`{{range .Categories}}{{hashtagify .}}{{end}}`, "#GoLang"},
+		{`{{"Hello, world" | truncate 6}}`, "Hello…"},
+		{`{{.FeedName}}`, "test"},
+	}
+	for _, tt := range tests {
+		b, err := NewPostBuilder(WithTemplate(tt.template), WithFeedName("test"))
+		if err != nil {
+			t.Errorf("%s: %v", tt.template, err)
+			continue
+		}
+		toot, err := b.MakePost(item)
+		if err != nil {
+			t.Errorf("%s: %v", tt.template, err)
+			continue
+		}
+		if toot.Status != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.template, toot.Status, tt.want)
+		}
+	}
+}
+
+func TestDefaultTemplate(t *testing.T) {
+	b, err := NewPostBuilder()
+	if err != nil {
+		t.Fatal(err)
+	}
+	toot, err := b.MakePost(&gofeed.Item{
+		Title:  "A title",
+		Link:   "https://example.com/post",
+		Author: &gofeed.Person{Name: "Jo"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(toot.Status, "A title by Jo") || !strings.Contains(toot.Status, "https://example.com/post") {
+		t.Errorf("default template gave %q", toot.Status)
+	}
+}
+
+func TestInvalidTemplate(t *testing.T) {
+	_, err := NewPostBuilder(WithTemplate("{{.Title"))
+	var invalid *InvalidSetting
+	if !errors.As(err, &invalid) || invalid.Setting != "template" {
+		t.Fatalf("NewPostBuilder returned %v, want *InvalidSetting", err)
+	}
+}
@@ -0,0 +1,218 @@
+package utils
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+)
+
+const (
+	// EXCERPT_DESCRIPTION takes the excerpt from the item's description, or its content if it has none
+	EXCERPT_DESCRIPTION = "description"
+
+	// EXCERPT_LEAD takes the excerpt from the lead paragraph of the item's content
+	EXCERPT_LEAD = "lead"
+
+	// EXCERPT_ARTICLE takes the excerpt from the lead paragraph of the linked article
+	EXCERPT_ARTICLE = "article"
+
+	// DEFAULT_EXCERPT_LENGTH is the longest excerpt, in characters
+	DEFAULT_EXCERPT_LENGTH = 280
+
+	// MIN_LEAD_LENGTH is how long a paragraph has to be to count as the lead
+	MIN_LEAD_LENGTH = 80
+)
+
+// blockElements start a new line when converting HTML to text
+var blockElements = map[string]bool{
+	"address": true, "article": true, "aside": true, "blockquote": true, "dd": true,
+	"div": true, "dl": true, "dt": true, "figcaption": true, "figure": true,
+	"footer": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true,
+	"h6": true, "header": true, "hr": true, "li": true, "main": true, "nav": true,
+	"ol": true, "p": true, "pre": true, "section": true, "table": true, "tr": true,
+	"ul": true,
+}
+
+// skipElements are dropped, content and all, when converting HTML to text
+var skipElements = map[string]bool{
+	"script": true, "style": true, "noscript": true, "template": true, "iframe": true,
+	"svg": true, "head": true,
+}
+
+// spaces matches runs of whitespace within a line
+var spaces = regexp.MustCompile(`[ \t\f\r\n\p{Zs}]+`)
+
+// HTMLToText converts HTML to plain text. Entities are decoded, paragraphs
+// and other blocks are separated by blank lines, <br> becomes a line break
+// and links are reduced to their text.
+func HTMLToText(s string) string {
+	var paragraphs []string
+	var line strings.Builder
+	var lines []string
+	skip := 0
+
+	endLine := func() {
+		if text := strings.TrimSpace(spaces.ReplaceAllString(line.String(), " ")); text != "" {
+			lines = append(lines, text)
+		}
+		line.Reset()
+	}
+	endParagraph := func() {
+		endLine()
+		if len(lines) > 0 {
+			paragraphs = append(paragraphs, strings.Join(lines, "\n"))
+		}
+		lines = nil
+	}
+
+	tokens := html.NewTokenizer(strings.NewReader(s))
+	for {
+		tt := tokens.Next()
+		switch tt {
+		case html.ErrorToken:
+			endParagraph()
+			return strings.Join(paragraphs, "\n\n")
+
+		case html.TextToken:
+			if skip == 0 {
+				line.Write(tokens.Text())
+			}
+
+		case html.StartTagToken, html.EndTagToken, html.SelfClosingTagToken:
+			name, _ := tokens.TagName()
+			tag := string(name)
+			if skipElements[tag] {
+				if tt == html.StartTagToken {
+					skip++
+				} else if tt == html.EndTagToken && skip > 0 {
+					skip--
+				}
+				continue
+			}
+			if skip > 0 {
+				continue
+			}
+			switch {
+			case tag == "br":
+				endLine()
+			case blockElements[tag]:
+				endParagraph()
+			case tag == "img" && tt != html.EndTagToken:
+				// Keep an image's alt text in place of the image
+				for more := true; more; {
+					var key, val []byte
+					key, val, more = tokens.TagAttr()
+					if string(key) == "alt" && len(val) > 0 {
+						line.WriteString(" " + string(val) + " ")
+					}
+				}
+			}
+		}
+	}
+}
+
+// LeadParagraph returns the text of the lead paragraph of an HTML document
+// or fragment: the first paragraph long enough to be prose and not mostly
+// links. If there's no such paragraph, the first paragraph of its text is used.
+func LeadParagraph(s string) string {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(s))
+	if err != nil {
+		return firstParagraph(HTMLToText(s))
+	}
+	return leadParagraph(doc)
+}
+
+// leadParagraph finds the lead paragraph in a parsed document, preferring
+// the article or main element if there is one
+func leadParagraph(doc *goquery.Document) string {
+	doc.Find("script, style, nav, header, footer, aside, form, figure, noscript").Remove()
+
+	root := doc.Selection
+	for _, selector := range []string{"article", "main", "[role=main]"} {
+		if found := doc.Find(selector).First(); found.Length() > 0 {
+			root = found
+			break
+		}
+	}
+
+	lead := ""
+	root.Find("p").EachWithBreak(func(_ int, p *goquery.Selection) bool {
+		inner, err := p.Html()
+		if err != nil {
+			return true
+		}
+		text := HTMLToText(inner)
+		if len([]rune(text)) < MIN_LEAD_LENGTH {
+			return true
+		}
+
+		// Skip link lists and bylines
+		linkText := 0
+		p.Find("a").Each(func(_ int, a *goquery.Selection) {
+			linkText += len([]rune(strings.TrimSpace(a.Text())))
+		})
+		if linkText*2 > len([]rune(text)) {
+			return true
+		}
+
+		lead = text
+		return false
+	})
+	if lead != "" {
+		return lead
+	}
+
+	inner, err := root.Html()
+	if err != nil {
+		return ""
+	}
+	return firstParagraph(HTMLToText(inner))
+}
+
+// firstParagraph returns the text up to the first blank line
+func firstParagraph(text string) string {
+	if i := strings.Index(text, "\n\n"); i >= 0 {
+		return text[:i]
+	}
+	return text
+}
+
+// ArticleLead fetches the article at link and returns its lead paragraph
+func ArticleLead(client *http.Client, link string) (string, error) {
+	doc, _, err := fetchArticle(client, link)
+	if err != nil {
+		return "", err
+	}
+	return leadParagraph(doc), nil
+}
+
+// Excerpt returns a plain text excerpt of the item's HTML, cut to at most
+// length characters. mode picks the source; see EXCERPT_DESCRIPTION and
+// EXCERPT_LEAD. The lead paragraph of the linked article is fetched by the
+// PostBuilder.
+func Excerpt(description string, content string, mode string, length int) string {
+	var text string
+	switch mode {
+	case EXCERPT_LEAD, EXCERPT_ARTICLE:
+		if content != "" {
+			text = LeadParagraph(content)
+		}
+		if text == "" && description != "" {
+			text = LeadParagraph(description)
+		}
+	default:
+		if description != "" {
+			text = HTMLToText(description)
+		} else if content != "" {
+			text = HTMLToText(content)
+		}
+	}
+
+	if length <= 0 {
+		length = DEFAULT_EXCERPT_LENGTH
+	}
+	return truncateText(text, length)
+}
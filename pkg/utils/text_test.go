@@ -0,0 +1,97 @@
+package utils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mmcdole/gofeed"
+)
+
+func TestHTMLToText(t *testing.T) {
+	tests := []struct {
+		html string
+		want string
+	}{
+		{"plain &amp; simple", "plain & simple"},
+		{"<p>One</p><p>Two</p>", "One\n\nTwo"},
+		{"line<br>break", "line\nbreak"},
+		{`<p>A <a href="https://example.com">link</a>   here</p>`, "A link here"},
+		{`<p>Before<script>alert(1)</script> after</p>`, "Before after"},
+		{`<img src="x.png" alt="A photo">`, "A photo"},
+		{"<ul><li>a</li><li>b</li></ul>", "a\n\nb"},
+	}
+	for _, tt := range tests {
+		if got := HTMLToText(tt.html); got != tt.want {
+			t.Errorf("HTMLToText(%q) = %q, want %q", tt.html, got, tt.want)
+		}
+	}
+}
+
+const leadArticle = `<html><body>
+<nav><p>Home | News | Sport | Weather | Contact us | About this site | Sign in</p></nav>
+<article>
+<p>By <a href="/jo">Jo Bloggs</a></p>
+<p><a href="/a">Related one</a> <a href="/b">Related two</a> <a href="/c">Related three</a> <a href="/d">Related four</a></p>
+<p>The lead paragraph is the first one long enough to be prose, and it isn't mostly links.</p>
+<p>The second paragraph is also long enough, but it comes after the lead one.</p>
+</article>
+</body></html>`
+
+func TestLeadParagraph(t *testing.T) {
+	want := "The lead paragraph is the first one long enough to be prose, and it isn't mostly links."
+	if got := LeadParagraph(leadArticle); got != want {
+		t.Errorf("LeadParagraph() = %q, want %q", got, want)
+	}
+
+	// Without a long enough paragraph, the first one is used
+	if got := LeadParagraph("<p>Short.</p><p>Also short.</p>"); got != "Short." {
+		t.Errorf("LeadParagraph() = %q, want the first paragraph", got)
+	}
+}
+
+func TestExcerpt(t *testing.T) {
+	description := "<p>The description.</p>"
+	content := "<p>Short intro.</p><p>" + strings.Repeat("The lead of the content. ", 5) + "</p>"
+
+	if got := Excerpt(description, content, EXCERPT_DESCRIPTION, 0); got != "The description." {
+		t.Errorf("description excerpt = %q", got)
+	}
+	if got := Excerpt("", content, EXCERPT_DESCRIPTION, 0); !strings.HasPrefix(got, "Short intro.") {
+		t.Errorf("description excerpt without a description = %q", got)
+	}
+	if got := Excerpt(description, content, EXCERPT_LEAD, 0); !strings.HasPrefix(got, "The lead of the content.") {
+		t.Errorf("lead excerpt = %q", got)
+	}
+	if got := Excerpt(description, content, EXCERPT_LEAD, 20); len([]rune(got)) > 20 {
+		t.Errorf("excerpt %q is over 20 characters", got)
+	}
+}
+
+func TestArticleExcerpt(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(leadArticle))
+	}))
+	defer server.Close()
+
+	b, err := NewPostBuilder(
+		WithTemplate("{{.Excerpt}}"),
+		WithExcerpt(EXCERPT_ARTICLE, 0),
+		WithHTTPClient(server.Client()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	toot, err := b.MakePost(&gofeed.Item{Title: "A title", Link: server.URL, Description: "The description."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(toot.Status, "The lead paragraph") {
+		t.Errorf("article excerpt = %q", toot.Status)
+	}
+
+	if _, err := NewPostBuilder(WithExcerpt("summary", 0)); err == nil {
+		t.Error("NewPostBuilder accepted an unknown excerpt source")
+	}
+}
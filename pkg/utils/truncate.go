@@ -0,0 +1,189 @@
+package utils
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// urlPattern matches the URLs Mastodon counts at a fixed length
+var urlPattern = regexp.MustCompile(`https?://[^\s]+`)
+
+// StatusLength returns the length of text as Mastodon counts it: in
+// characters, with every URL counting as perURL characters
+func StatusLength(text string, perURL int) int {
+	length := utf8.RuneCountInString(text)
+	for _, u := range urlPattern.FindAllString(text, -1) {
+		length += perURL - utf8.RuneCountInString(u)
+	}
+	return length
+}
+
+// truncateText shortens s to at most n characters, including the ellipsis it
+// ends with if cut. It cuts at a word boundary where it can, and never in the
+// middle of a grapheme such as an accented letter or an emoji sequence.
+func truncateText(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	if n < 1 {
+		return ""
+	}
+
+	// Leave room for the ellipsis
+	cut := graphemeBoundary(runes, n-1)
+
+	// Back up to the last space, unless that loses more than half the text
+	if !unicode.IsSpace(runes[cut]) {
+		for i := cut - 1; i > cut/2; i-- {
+			if unicode.IsSpace(runes[i]) {
+				cut = i
+				break
+			}
+		}
+	}
+
+	return strings.TrimRightFunc(string(runes[:cut]), func(r rune) bool {
+		return unicode.IsSpace(r) || (unicode.IsPunct(r) && !strings.ContainsRune(`)"'`, r))
+	}) + "…"
+}
+
+// graphemeBoundary moves cut back until runes[:cut] doesn't split a grapheme
+func graphemeBoundary(runes []rune, cut int) int {
+	for cut > 0 && (extendsGrapheme(runes[cut]) || runes[cut-1] == '\u200d') {
+		cut--
+	}
+
+	// Regional indicators pair up into flags
+	indicators := 0
+	for i := cut - 1; i >= 0 && isRegionalIndicator(runes[i]); i-- {
+		indicators++
+	}
+	if indicators%2 == 1 && isRegionalIndicator(runes[cut]) {
+		cut--
+	}
+	return cut
+}
+
+// extendsGrapheme reports whether r attaches to the character before it
+func extendsGrapheme(r rune) bool {
+	switch {
+	case unicode.In(r, unicode.Mn, unicode.Me, unicode.Mc):
+		return true
+	case r == '\u200d': // zero width joiner
+		return true
+	case r >= '\ufe00' && r <= '\ufe0f', r >= '\U000e0100' && r <= '\U000e01ef': // variation selectors
+		return true
+	case r >= '\U0001f3fb' && r <= '\U0001f3ff': // skin tone modifiers
+		return true
+	case r >= '\U000e0020' && r <= '\U000e007f': // emoji tag sequences
+		return true
+	}
+	return false
+}
+
+// isRegionalIndicator reports whether r is half of a flag emoji
+func isRegionalIndicator(r rune) bool {
+	return r >= '\U0001f1e6' && r <= '\U0001f1ff'
+}
+
+var (
+	// paragraphBreak matches the blank lines between paragraphs
+	paragraphBreak = regexp.MustCompile(`\n[ \t]*\n\s*`)
+
+	// sentenceEnd matches the end of a sentence and the space after it
+	sentenceEnd = regexp.MustCompile(`[.!?…]+["'’”)\]]*\s+`)
+)
+
+// textSplitter breaks text into pieces and joins them back up
+type textSplitter struct {
+	split func(string) []string
+	join  string
+}
+
+// textSplitters break text into ever smaller pieces: paragraphs, lines,
+// sentences and words
+var textSplitters = []textSplitter{
+	{func(s string) []string { return paragraphBreak.Split(s, -1) }, "\n\n"},
+	{func(s string) []string { return strings.Split(s, "\n") }, "\n"},
+	{splitSentences, " "},
+	{strings.Fields, " "},
+}
+
+// splitText splits text into chunks at most max long, as counted by length.
+// It breaks between paragraphs where it can, then between lines, sentences
+// and words, and only cuts words longer than max.
+func splitText(text string, max int, length func(string) int) []string {
+	return splitLevel(text, max, length, 0)
+}
+
+// splitLevel splits text using the splitters from level on
+func splitLevel(text string, max int, length func(string) int, level int) []string {
+	if length(text) <= max {
+		return []string{text}
+	}
+	if level == len(textSplitters) {
+		return splitRunes(text, max)
+	}
+
+	splitter := textSplitters[level]
+	var pieces []string
+	for _, piece := range splitter.split(text) {
+		if piece = strings.TrimSpace(piece); piece == "" {
+			continue
+		}
+		if length(piece) > max {
+			// Pieces too long on their own are split further, and the
+			// parts packed in with the pieces around them
+			pieces = append(pieces, splitLevel(piece, max, length, level+1)...)
+		} else {
+			pieces = append(pieces, piece)
+		}
+	}
+
+	var chunks []string
+	current := ""
+	for _, piece := range pieces {
+		switch {
+		case current == "":
+			current = piece
+		case length(current+splitter.join+piece) <= max:
+			current += splitter.join + piece
+		default:
+			chunks = append(chunks, current)
+			current = piece
+		}
+	}
+	if current != "" {
+		chunks = append(chunks, current)
+	}
+	return chunks
+}
+
+// splitSentences splits text after each sentence
+func splitSentences(text string) []string {
+	var sentences []string
+	start := 0
+	for _, match := range sentenceEnd.FindAllStringIndex(text, -1) {
+		sentences = append(sentences, text[start:match[1]])
+		start = match[1]
+	}
+	return append(sentences, text[start:])
+}
+
+// splitRunes cuts text into chunks of max characters, keeping graphemes whole
+func splitRunes(text string, max int) []string {
+	runes := []rune(text)
+	var chunks []string
+	for len(runes) > max {
+		cut := graphemeBoundary(runes, max)
+		if cut == 0 {
+			cut = max
+		}
+		chunks = append(chunks, string(runes[:cut]))
+		runes = runes[cut:]
+	}
+	return append(chunks, string(runes))
+}
@@ -0,0 +1,224 @@
+package utils
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/mmcdole/gofeed"
+)
+
+func TestStatusLength(t *testing.T) {
+	tests := []struct {
+		text string
+		want int
+	}{
+		{"hello", 5},
+		{"héllo wörld", 11},
+		{"see https://example.com/a/very/long/path/to/an/article", 4 + 23},
+		{"https://a.example http://b.example", 23 + 1 + 23},
+	}
+	for _, tt := range tests {
+		if got := StatusLength(tt.text, 23); got != tt.want {
+			t.Errorf("StatusLength(%q) = %d, want %d", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestTruncateText(t *testing.T) {
+	tests := []struct {
+		text string
+		n    int
+		want string
+	}{
+		{"short", 10, "short"},
+		{"The quick brown fox jumps", 16, "The quick brown…"},
+		{"The quick, brown fox", 12, "The quick…"},
+		{"Supercalifragilistic", 8, "Superca…"},
+		// Never split an accented letter or a flag
+		{"cafe\u0301 au lait", 5, "caf…"},
+		{"\U0001f1eb\U0001f1f7\U0001f1e9\U0001f1ea!", 4, "\U0001f1eb\U0001f1f7…"},
+		{"anything", 0, ""},
+	}
+	for _, tt := range tests {
+		got := truncateText(tt.text, tt.n)
+		if got != tt.want {
+			t.Errorf("truncateText(%q, %d) = %q, want %q", tt.text, tt.n, got, tt.want)
+		}
+		if utf8.RuneCountInString(got) > tt.n && got != tt.text {
+			t.Errorf("truncateText(%q, %d) is %d characters", tt.text, tt.n, utf8.RuneCountInString(got))
+		}
+	}
+}
+
+func TestMakePostFits(t *testing.T) {
+	b, err := NewPostBuilder(
+		WithTemplate("{{.Title}}\n\n{{.Description}}\n\n{{.Link}}"),
+		WithLimits(100, 23),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	link := "https://example.com/" + strings.Repeat("path/", 20)
+	toot, err := b.MakePost(&gofeed.Item{
+		Title:       "A title",
+		Description: strings.Repeat("Lots of words. ", 40),
+		Link:        link,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := StatusLength(toot.Status, 23); got > 100 {
+		t.Errorf("post is %d characters, over 100", got)
+	}
+	if !strings.HasPrefix(toot.Status, "A title") || !strings.HasSuffix(toot.Status, link) {
+		t.Errorf("title or link changed: %q", toot.Status)
+	}
+}
+
+func TestMakePostTooLong(t *testing.T) {
+	b, err := NewPostBuilder(WithTemplate("{{.Link}} and some text that isn't from the item"), WithLimits(30, 23))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = b.MakePost(&gofeed.Item{Title: "A title", Link: "https://example.com/"})
+	var tooLong *PostTooLong
+	if !errors.As(err, &tooLong) {
+		t.Fatalf("MakePost returned %v, want *PostTooLong", err)
+	}
+}
+
+func TestSplitText(t *testing.T) {
+	length := func(s string) int { return StatusLength(s, 23) }
+	tests := []struct {
+		name string
+		text string
+		max  int
+		want []string
+	}{
+		{"fits", "Short text", 20, []string{"Short text"}},
+		{"paragraphs", "First para.\n\nSecond para.", 15, []string{"First para.", "Second para."}},
+		{"lines", "Line one\nLine two\nLine three", 18, []string{"Line one\nLine two", "Line three"}},
+		{"sentences and words", "One. Two. Three four five.", 10, []string{"One. Two.", "Three four", "five."}},
+		{"long word", "abcdefghij", 4, []string{"abcd", "efgh", "ij"}},
+		{"urls", "See https://example.com/" + strings.Repeat("a", 40) + " now", 30, []string{"See https://example.com/" + strings.Repeat("a", 40), "now"}},
+	}
+	for _, tt := range tests {
+		got := splitText(tt.text, tt.max, length)
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+		for _, chunk := range got {
+			if length(chunk) > tt.max {
+				t.Errorf("%s: chunk %q is over %d characters", tt.name, chunk, tt.max)
+			}
+		}
+	}
+}
+
+func TestMakeThread(t *testing.T) {
+	b, err := NewPostBuilder(WithTemplate("{{.Title}}"), WithLimits(40, 23), WithThread(10, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	toots, err := b.MakeThread(&gofeed.Item{Title: "A short title"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(toots) != 1 || toots[0].Status != "A short title" {
+		t.Errorf("short item made %d posts, want it as one post without a counter", len(toots))
+	}
+
+	title := "The first sentence is here. The second one follows it. A third closes the item."
+	toots, err = b.MakeThread(&gofeed.Item{Title: title})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(toots) < 2 {
+		t.Fatalf("long item made %d posts, want a thread", len(toots))
+	}
+	var words []string
+	for i, toot := range toots {
+		if length := StatusLength(toot.Status, 23); length > 40 {
+			t.Errorf("post %d is %d characters, over 40", i+1, length)
+		}
+		counter := "\n\n" + strconv.Itoa(i+1) + "/" + strconv.Itoa(len(toots))
+		if !strings.HasSuffix(toot.Status, counter) {
+			t.Errorf("post %d is %q, want it to end with %q", i+1, toot.Status, counter)
+		}
+		words = append(words, strings.Fields(strings.TrimSuffix(toot.Status, counter))...)
+	}
+	// Nothing is shortened
+	if strings.Join(words, " ") != title {
+		t.Errorf("thread reads %q, want %q", strings.Join(words, " "), title)
+	}
+}
+
+func TestMakeThreadCapped(t *testing.T) {
+	b, err := NewPostBuilder(WithTemplate("{{.Title}}"), WithLimits(40, 23), WithThread(2, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	toots, err := b.MakeThread(&gofeed.Item{Title: strings.Repeat("Some more words to post. ", 10)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(toots) != 2 {
+		t.Fatalf("made %d posts, want 2", len(toots))
+	}
+	if !strings.HasSuffix(toots[1].Status, "…\n\n2/2") {
+		t.Errorf("last post is %q, want it shortened", toots[1].Status)
+	}
+	if length := StatusLength(toots[1].Status, 23); length > 40 {
+		t.Errorf("last post is %d characters, over 40", length)
+	}
+
+	// No room for anything but the counter
+	b, err = NewPostBuilder(WithTemplate("{{.Title}}"), WithLimits(5, 23), WithThread(2, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = b.MakeThread(&gofeed.Item{Title: "A title too long"})
+	var tooLong *PostTooLong
+	if !errors.As(err, &tooLong) {
+		t.Errorf("MakeThread returned %v, want *PostTooLong", err)
+	}
+}
+
+func TestThreadVisibility(t *testing.T) {
+	tests := []struct {
+		visibility string
+		unlisted   bool
+		want       string
+	}{
+		{"public", true, "unlisted"},
+		{"public", false, "public"},
+		{"unlisted", true, "unlisted"},
+		{"private", true, "private"},
+		{"", true, ""},
+	}
+	for _, tt := range tests {
+		b, err := NewPostBuilder(WithTemplate("{{.Title}}"), WithLimits(40, 23), WithThread(10, tt.unlisted), WithVisibility(tt.visibility))
+		if err != nil {
+			t.Fatal(err)
+		}
+		toots, err := b.MakeThread(&gofeed.Item{Title: strings.Repeat("Some more words to post. ", 4)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(toots) < 2 {
+			t.Fatalf("made %d posts, want a thread", len(toots))
+		}
+		if toots[0].Visibility != tt.visibility {
+			t.Errorf("%q, %v: first post is %q", tt.visibility, tt.unlisted, toots[0].Visibility)
+		}
+		for _, toot := range toots[1:] {
+			if toot.Visibility != tt.want {
+				t.Errorf("%q, %v: follow-up is %q, want %q", tt.visibility, tt.unlisted, toot.Visibility, tt.want)
+			}
+		}
+	}
+}
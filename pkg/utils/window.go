@@ -0,0 +1,164 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rmrfslashbin/mastopost/pkg/config"
+)
+
+// weekdays maps day names to days of the week
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// Window decides when a feed's items may be posted from its posting window.
+// A nil Window is always open.
+type Window struct {
+	days   map[time.Weekday]bool
+	ranges []timeRange
+	loc    *time.Location
+}
+
+// timeRange is a range of minutes into the day. One that ends before it
+// starts runs past midnight.
+type timeRange struct {
+	start int
+	end   int
+}
+
+// NewWindow checks and parses a feed's posting window. A nil PostingWindow
+// gives a nil Window, which is always open.
+func NewWindow(window *config.PostingWindow) (*Window, error) {
+	if window == nil {
+		return nil, nil
+	}
+
+	w := &Window{loc: time.UTC}
+	if window.Timezone != "" {
+		loc, err := time.LoadLocation(window.Timezone)
+		if err != nil {
+			return nil, &InvalidSetting{Setting: "postingwindow", Err: err}
+		}
+		w.loc = loc
+	}
+
+	if len(window.Days) > 0 {
+		w.days = make(map[time.Weekday]bool)
+		for _, day := range window.Days {
+			weekday, ok := weekdays[strings.ToLower(day)]
+			if !ok {
+				return nil, &InvalidSetting{Setting: "postingwindow", Err: fmt.Errorf("unknown day %q. use mon, tue, wed, thu, fri, sat or sun", day)}
+			}
+			w.days[weekday] = true
+		}
+	}
+
+	for _, times := range window.Times {
+		r, err := parseTimeRange(times)
+		if err != nil {
+			return nil, &InvalidSetting{Setting: "postingwindow", Err: err}
+		}
+		w.ranges = append(w.ranges, r)
+	}
+	if len(w.ranges) == 0 {
+		w.ranges = []timeRange{{start: 0, end: 24 * 60}}
+	}
+
+	return w, nil
+}
+
+// parseTimeRange parses a "HH:MM-HH:MM" range
+func parseTimeRange(times string) (timeRange, error) {
+	from, to, ok := strings.Cut(times, "-")
+	if !ok {
+		return timeRange{}, fmt.Errorf("invalid time range %q. use HH:MM-HH:MM", times)
+	}
+	start, err := parseClock(strings.TrimSpace(from))
+	if err != nil {
+		return timeRange{}, err
+	}
+	end, err := parseClock(strings.TrimSpace(to))
+	if err != nil {
+		return timeRange{}, err
+	}
+	if start == end {
+		return timeRange{}, fmt.Errorf("empty time range %q", times)
+	}
+	return timeRange{start: start, end: end}, nil
+}
+
+// parseClock parses a "HH:MM" time of day into minutes. "24:00" is the end
+// of the day.
+func parseClock(clock string) (int, error) {
+	if clock == "24:00" {
+		return 24 * 60, nil
+	}
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q. use HH:MM", clock)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Open reports whether items may be posted at t
+func (w *Window) Open(t time.Time) bool {
+	if w == nil {
+		return true
+	}
+
+	t = t.In(w.loc)
+	minute := t.Hour()*60 + t.Minute()
+	today := w.postsOn(t.Weekday())
+	yesterday := w.postsOn((t.Weekday() + 6) % 7)
+	for _, r := range w.ranges {
+		if r.start < r.end {
+			if today && minute >= r.start && minute < r.end {
+				return true
+			}
+			continue
+		}
+		// The range runs past midnight and belongs to the day it starts on
+		if (today && minute >= r.start) || (yesterday && minute < r.end) {
+			return true
+		}
+	}
+	return false
+}
+
+// Next returns t if the window is open then, or else when it next opens
+func (w *Window) Next(t time.Time) time.Time {
+	if w.Open(t) {
+		return t
+	}
+
+	// The window opens at the start of one of its ranges, within a week
+	t = t.In(w.loc)
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, w.loc)
+	var next time.Time
+	for day := 0; day <= 7; day++ {
+		date := midnight.AddDate(0, 0, day)
+		for _, r := range w.ranges {
+			start := time.Date(date.Year(), date.Month(), date.Day(), r.start/60, r.start%60, 0, 0, w.loc)
+			if start.After(t) && w.Open(start) && (next.IsZero() || start.Before(next)) {
+				next = start
+			}
+		}
+		if !next.IsZero() {
+			return next
+		}
+	}
+	return t
+}
+
+// postsOn reports whether posts go out on a day of the week
+func (w *Window) postsOn(day time.Weekday) bool {
+	return w.days == nil || w.days[day]
+}
@@ -0,0 +1,125 @@
+package utils
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rmrfslashbin/mastopost/pkg/config"
+)
+
+// newTestWindow returns the window, failing the test if it's invalid
+func newTestWindow(t *testing.T, window *config.PostingWindow) *Window {
+	t.Helper()
+	w, err := NewWindow(window)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+func TestNewWindowInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		window config.PostingWindow
+	}{
+		{"timezone", config.PostingWindow{Timezone: "Mars/Olympus_Mons"}},
+		{"day", config.PostingWindow{Days: []string{"mon", "someday"}}},
+		{"no dash", config.PostingWindow{Times: []string{"09:00"}}},
+		{"clock", config.PostingWindow{Times: []string{"9am-5pm"}}},
+		{"hour", config.PostingWindow{Times: []string{"09:00-25:00"}}},
+		{"empty", config.PostingWindow{Times: []string{"09:00-09:00"}}},
+	}
+	for _, tt := range tests {
+		_, err := NewWindow(&tt.window)
+		var invalid *InvalidSetting
+		if !errors.As(err, &invalid) || invalid.Setting != "postingwindow" {
+			t.Errorf("%s: NewWindow returned %v, want an invalid postingwindow", tt.name, err)
+		}
+	}
+}
+
+func TestParseTimeRange(t *testing.T) {
+	tests := []struct {
+		times string
+		want  timeRange
+	}{
+		{"07:00-12:30", timeRange{start: 7 * 60, end: 12*60 + 30}},
+		{" 22:00 - 02:00 ", timeRange{start: 22 * 60, end: 2 * 60}},
+		{"18:00-24:00", timeRange{start: 18 * 60, end: 24 * 60}},
+	}
+	for _, tt := range tests {
+		got, err := parseTimeRange(tt.times)
+		if err != nil || got != tt.want {
+			t.Errorf("parseTimeRange(%q) = %v, %v; want %v", tt.times, got, err, tt.want)
+		}
+	}
+}
+
+func TestWindowOpen(t *testing.T) {
+	// 1 January 2024 is a Monday
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2024, 1, day, hour, minute, 0, 0, time.UTC)
+	}
+	var always *Window
+	weekdays := newTestWindow(t, &config.PostingWindow{Days: []string{"Mon", "tue", "wed", "thu", "fri"}})
+	hours := newTestWindow(t, &config.PostingWindow{Times: []string{"07:00-12:00", "13:00-22:00"}})
+	// Friday nights run into Saturday morning
+	nights := newTestWindow(t, &config.PostingWindow{Days: []string{"fri"}, Times: []string{"22:00-02:00"}})
+	berlin := newTestWindow(t, &config.PostingWindow{Times: []string{"09:00-17:00"}, Timezone: "Europe/Berlin"})
+
+	tests := []struct {
+		name   string
+		window *Window
+		t      time.Time
+		want   bool
+	}{
+		{"nil", always, at(6, 3, 0), true},
+		{"weekday", weekdays, at(5, 23, 59), true},
+		{"weekend", weekdays, at(6, 12, 0), false},
+		{"morning", hours, at(1, 7, 0), true},
+		{"lunch", hours, at(1, 12, 0), false},
+		{"evening", hours, at(1, 21, 59), true},
+		{"night", hours, at(1, 22, 0), false},
+		{"friday night", nights, at(5, 23, 0), true},
+		{"saturday morning", nights, at(6, 1, 59), true},
+		{"saturday night", nights, at(6, 23, 0), false},
+		{"friday morning", nights, at(5, 1, 0), false},
+		{"berlin morning", berlin, at(1, 8, 0), true},
+		{"berlin evening", berlin, at(1, 16, 30), false},
+	}
+	for _, tt := range tests {
+		if got := tt.window.Open(tt.t); got != tt.want {
+			t.Errorf("%s: Open(%v) = %v, want %v", tt.name, tt.t, got, tt.want)
+		}
+	}
+}
+
+func TestWindowNext(t *testing.T) {
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2024, 1, day, hour, minute, 0, 0, time.UTC)
+	}
+	var always *Window
+	hours := newTestWindow(t, &config.PostingWindow{Days: []string{"mon", "tue", "wed", "thu", "fri"}, Times: []string{"07:00-12:00", "13:00-22:00"}})
+	nights := newTestWindow(t, &config.PostingWindow{Days: []string{"fri"}, Times: []string{"22:00-02:00"}})
+
+	tests := []struct {
+		name   string
+		window *Window
+		t      time.Time
+		want   time.Time
+	}{
+		{"nil", always, at(6, 3, 0), at(6, 3, 0)},
+		{"open", hours, at(1, 8, 15), at(1, 8, 15)},
+		{"lunch", hours, at(1, 12, 30), at(1, 13, 0)},
+		{"night", hours, at(1, 23, 0), at(2, 7, 0)},
+		{"early", hours, at(2, 6, 0), at(2, 7, 0)},
+		{"weekend", hours, at(5, 22, 30), at(8, 7, 0)},
+		{"after the night", nights, at(6, 2, 0), at(12, 22, 0)},
+	}
+	for _, tt := range tests {
+		if got := tt.window.Next(tt.t); !got.Equal(tt.want) {
+			t.Errorf("%s: Next(%v) = %v, want %v", tt.name, tt.t, got, tt.want)
+		}
+	}
+}